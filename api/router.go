@@ -4,6 +4,7 @@ import (
 	"github.com/gin-gonic/gin"                   // gin是一个web框架
 	v0 "github.com/qinguoyi/osproxy/api/v0"      // api
 	"github.com/qinguoyi/osproxy/app/middleware" // 中间件
+	"github.com/qinguoyi/osproxy/app/pkg/base"
 	"github.com/qinguoyi/osproxy/bootstrap"
 	"github.com/qinguoyi/osproxy/config"
 	"github.com/qinguoyi/osproxy/docs"
@@ -25,9 +26,11 @@ func NewRouter(
 	traceL := middleware.NewTrace(lgLogger)              // NewTrace()函数用于创建一个trace中间件
 	requestL := middleware.NewRequestLog(lgLogger)       // NewRequestLog()函数用于创建一个request-log中间件
 	panicRecover := middleware.NewPanicRecover(lgLogger) // NewPanicRecover()函数用于创建一个panic-recover中间件
+	peer := middleware.NewPeer(false)                    // NewPeer()函数用于创建一个集群内请求校验中间件
+	drain := middleware.NewDrain()                       // NewDrain()函数用于创建一个统计进行中请求的中间件
 
 	// 跨域 trace-id 日志
-	router.Use(corsM.Handler(), traceL.Handler(), requestL.Handler(), panicRecover.Handler()) // Use()函数用于注册中间件
+	router.Use(corsM.Handler(), traceL.Handler(), requestL.Handler(), panicRecover.Handler(), peer.Handler(), drain.Handler()) // Use()函数用于注册中间件
 	// 中间件在请求处理函数之前执行，所以中间件可以在请求处理函数之前做一些前置处理，也可以在请求处理函数之后做一些后置处理，比如日志记录、权限验证、异常处理等
 	// 在gin中，中间件是一个HandlerFunc，它的定义如下： type HandlerFunc func(*Context)。中间件的参数是一个Context指针，返回值是一个空接口
 	// 在java中，中间件是一个Filter（拦截器），它的定义如下： public void doFilter(ServletRequest request, ServletResponse response, FilterChain chain) throws IOException, ServletException
@@ -45,6 +48,9 @@ func NewRouter(
 	// 动态资源 注册 api 分组路由
 	setApiGroupRoutes(router)
 
+	// 分享短链接
+	router.GET("/s/:code", v0.ShareHandler)
+
 	return router
 }
 
@@ -69,18 +75,62 @@ func setApiGroupRoutes(
 		// link
 		group.POST("/link/upload", v0.UploadLinkHandler)     // upload link 上传链接
 		group.POST("/link/download", v0.DownloadLinkHandler) // DownloadLinkHandler 下载链接
+		group.POST("/link/revoke", v0.RevokeLinkHandler)     // 吊销下载链接
+
+		// share
+		group.POST("/share", v0.CreateShareHandler) // 创建分享
+		group.GET("/share/log", v0.ShareLogHandler) // 分享访问日志
 
 		// proxy
-		group.GET("/proxy", v0.IsOnCurrentServerHandler) // IsOnCurrentServerHandler()函数用于判断是否在当前服务器
+		group.GET("/proxy", middleware.NewPeer(true).Handler(), v0.IsOnCurrentServerHandler) // 判断是否在当前服务器，只接受集群内请求
+		if base.PeerAuthEnabled() {
+			group.PUT("/proxy/staging", middleware.NewPeer(true).Handler(), v0.ReceiveStagingHandler) // 接收排空服务推送的暂存分片，需要配置集群校验
+		}
 
 		// upload
-		group.PUT("/upload", v0.UploadSingleHandler)          // PUT请求，路由为/upload，处理函数为UploadSingleHandler
-		group.PUT("/upload/multi", v0.UploadMultiPartHandler) // PUT请求，路由为/upload/multi，处理函数为UploadMultiPartHandler
-		group.PUT("/upload/merge", v0.UploadMergeHandler)     // PUT请求，路由为/upload/merge，处理函数为UploadMergeHandler
+		group.PUT("/upload", v0.UploadSingleHandler)                 // PUT请求，路由为/upload，处理函数为UploadSingleHandler
+		group.PUT("/upload/multi", v0.UploadMultiPartHandler)        // PUT请求，路由为/upload/multi，处理函数为UploadMultiPartHandler
+		group.PUT("/upload/merge", v0.UploadMergeHandler)            // PUT请求，路由为/upload/merge，处理函数为UploadMergeHandler
+		group.GET("/upload/merge", v0.MergeStatusHandler)            // 查询合并状态
+		group.PUT("/upload/raw", v0.UploadSingleRawHandler)          // 请求体即文件内容，无需multipart/form-data
+		group.PUT("/upload/multi/raw", v0.UploadMultiPartRawHandler) // 请求体即分片内容
+		group.PUT("/upload/complete", v0.UploadCompleteHandler)      // 直传对象存储完成回调
+
+		// upload session 分片上传会话
+		group.POST("/upload/session", v0.InitSessionHandler)             // 创建上传会话
+		group.GET("/upload/session", v0.SessionStatusHandler)            // 查询分片上传状态
+		group.DELETE("/upload/session", v0.AbortSessionHandler)          // 终止上传会话
+		group.PUT("/upload/session/complete", v0.CompleteSessionHandler) // 完成上传会话
+
+		// status
+		group.GET("/status", v0.UploadStatusHandler) // 查询上传状态，支持SSE推送
 
 		//download
 		group.GET("/download", v0.DownloadHandler)
 
+		// admin 管理接口，只接受本机或集群内请求
+		admin := group.Group("/admin", middleware.NewAdmin().Handler())
+		{
+			admin.POST("/drain", v0.DrainHandler)             // 排空当前服务
+			admin.GET("/task/list", v0.TaskListHandler)       // 查询任务
+			admin.GET("/task/queue", v0.TaskQueueHandler)     // 各任务类型的队列情况
+			admin.GET("/task/dead", v0.DeadTaskHandler)       // 死信任务
+			admin.GET("/task/detail", v0.TaskDetailHandler)   // 任务及执行日志
+			admin.PUT("/task/requeue", v0.RequeueTaskHandler) // 重新执行任务
+			admin.PUT("/task/cancel", v0.CancelTaskHandler)   // 取消未执行的任务
+		}
+
+		// tus 断点续传协议
+		tus := group.Group("/tus", middleware.NewTus().Handler())
+		{
+			tus.OPTIONS("", v0.TusOptionsHandler)
+			tus.OPTIONS("/:uid", v0.TusOptionsHandler)
+			tus.POST("", v0.TusCreateHandler)        // 创建上传
+			tus.HEAD("/:uid", v0.TusHeadHandler)     // 查询上传进度
+			tus.PATCH("/:uid", v0.TusPatchHandler)   // 上传数据
+			tus.DELETE("/:uid", v0.TusDeleteHandler) // 终止上传
+		}
+
 	}
 	return group
 }
@@ -1,7 +1,6 @@
 package v0
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -9,7 +8,6 @@ import (
 	"net/http"
 	"os"
 	"path"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -18,7 +16,6 @@ import (
 	"github.com/qinguoyi/osproxy/app/pkg/base"
 	"github.com/qinguoyi/osproxy/app/pkg/repo"
 	"github.com/qinguoyi/osproxy/app/pkg/storage"
-	"github.com/qinguoyi/osproxy/app/pkg/thirdparty"
 	"github.com/qinguoyi/osproxy/app/pkg/utils"
 	"github.com/qinguoyi/osproxy/app/pkg/web"
 	"github.com/qinguoyi/osproxy/bootstrap"
@@ -42,6 +39,7 @@ import (
 //	@Param        expire     query  string  true  "过期时间"
 //	@Param        bucket     query  string  true  "存储桶"
 //	@Param        object     query  string  true  "存储名称"
+//	@Param        linkId     query  string  true  "链接ID"
 //	@Param        signature  query  string  true  "签名"
 //	@Produce      application/json
 //	@Success      200  {object}  web.Response
@@ -67,6 +65,7 @@ func DownloadHandler(c *gin.Context) {
 	expireStr := c.Query("expire")
 	bucketName := c.Query("bucket")
 	objectName := c.Query("object")
+	linkId := c.Query("linkId")
 	signature := c.Query("signature")
 
 	if online == "" {
@@ -82,7 +81,7 @@ func DownloadHandler(c *gin.Context) {
 		web.ParamsError(c, errorInfo)
 		return
 	}
-	if !base.CheckDownloadSignature(date, expireStr, bucketName, objectName, signature) {
+	if !base.CheckDownloadSignature(date, expireStr, bucketName, objectName, linkId, signature) {
 		web.ParamsError(c, "签名校验失败")
 		return
 	}
@@ -121,10 +120,39 @@ func DownloadHandler(c *gin.Context) {
 		lgRedis.Expire(context.Background(), fmt.Sprintf("%s-meta", uidStr), 5*60*time.Second)
 		meta = &msg
 	}
+	// 未扫描完成、已隔离或扫描失败的数据不提供下载
+	if reason := scanBlocked(meta, bootstrap.NewConfig("").Scan.Mode != ""); reason != "" {
+		web.Forbidden(c, reason)
+		return
+	}
 	bucketName = meta.Bucket
 	objectName = meta.StorageName
 	fileSize := meta.StorageSize
+
+	proxyFlag := false
+	// local存储: 单文件上传完uid会删除, 大文件合并后会删除
+	if bootstrap.NewConfig("").Local.Enabled {
+		dirName := path.Join(utils.LocalStore, uidStr)
+		// 不分片：单文件或大文件已合并
+		if !meta.MultiPart {
+			dirName = path.Join(utils.LocalStore, bucketName, objectName)
+		}
+		if _, err := os.Stat(dirName); os.IsNotExist(err) {
+			proxyFlag = true
+		}
+	}
+	// 不在本地，转发到数据所在服务，Range、状态码及响应头原样透传，响应体以流的方式返回
+	// 链接是否被吊销、扣减次数由实际提供数据的服务校验，避免重复扣减
+	if proxyFlag {
+		forwardRequest(c, uidStr, meta.OwnerNode)
+		return
+	}
+	// 链接是否被吊销，限制次数的链接每次下载都扣减，扣减后续传窗口内同一客户端的断点续传不扣减
 	start, end := base.GetRange(c.GetHeader("Range"), fileSize)
+	if ok, errorInfo := base.CheckDownloadLink(uid, linkId, c.ClientIP(), start != 0); !ok {
+		web.UnAuthorization(c, errorInfo)
+		return
+	}
 	c.Writer.Header().Add("Content-Length", fmt.Sprintf("%d", end-start+1))
 	if online == "0" {
 		c.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", name))
@@ -145,216 +173,140 @@ func DownloadHandler(c *gin.Context) {
 	}
 
 	ch := make(chan []byte, 1024*1024*20)
-	proxyFlag := false
-	// local存储: 单文件上传完uid会删除, 大文件合并后会删除
-	if bootstrap.NewConfig("").Local.Enabled {
-		dirName := path.Join(utils.LocalStore, uidStr)
-		// 不分片：单文件或大文件已合并
-		if !meta.MultiPart {
-			dirName = path.Join(utils.LocalStore, bucketName, objectName)
-		}
-		if _, err := os.Stat(dirName); os.IsNotExist(err) {
-			proxyFlag = true
-		}
-	}
-	if proxyFlag {
-		// 不在本地，询问集群内其他服务并转发
-		serviceList, err := base.NewServiceRegister().Discovery()
-		if err != nil || serviceList == nil {
-			lgLogger.WithContext(c).Error("发现其他服务失败")
-			web.InternalError(c, "发现其他服务失败")
-			return
-		}
-		var wg sync.WaitGroup
-		var ipList []string
-		ipChan := make(chan string, len(serviceList))
-		for _, service := range serviceList {
-			wg.Add(1)
-			go func(ip string, port string, ipChan chan string, wg *sync.WaitGroup) {
-				defer wg.Done()
-				res, err := thirdparty.NewStorageService().Locate(utils.Scheme, ip, port, uidStr)
-				if err != nil {
-					fmt.Print(err.Error())
-					return
+	// local在本地 || 其他os
+	if !meta.MultiPart {
+		go func() {
+			step := int64(1 * 1024 * 1024)
+			for {
+				if start >= end {
+					close(ch)
+					break
 				}
-				ipChan <- res
-			}(service.IP, service.Port, ipChan, &wg)
-		}
-		wg.Wait()
-		close(ipChan)
-		for re := range ipChan {
-			ipList = append(ipList, re)
-		}
-		if len(ipList) == 0 {
-			lgLogger.WithContext(c).Error("发现其他服务失败")
-			web.InternalError(c, "发现其他服务失败")
-			return
-		}
-		proxyIP := ipList[0]
-		_, bodyData, _, err := thirdparty.NewStorageService().DownloadForward(c, utils.Scheme, proxyIP,
-			bootstrap.NewConfig("").App.Port)
-		if err != nil {
-			lgLogger.WithContext(c).Error("下载转发失败")
-			web.InternalError(c, err.Error())
-			return
+				length := step
+				if start+length > end {
+					length = end - start + 1
+				}
+				data, err := storage.NewStorage().Storage.GetObject(bucketName, objectName, start, length)
+				if err != nil && err != io.EOF {
+					lgLogger.WithContext(c).Error(fmt.Sprintf("从对象存储获取数据失败%s", err.Error()))
+				}
+				ch <- data
+				start += step
+			}
+		}()
+
+		// 这种场景，会先从minio中获取全部数据，再流式传输，所以下载前会等待一下，但会把内存打爆
+		//go func() {
+		//	data, err := inner.NewStorage().Storage.GetObject(bucketName, objectName, start, end-start+1)
+		//	if err != nil && err != io.EOF {
+		//		lgLogger.WithContext(c).Error(fmt.Sprintf("从minio获取数据失败%s", err.Error()))
+		//	}
+		//	ch <- data
+		//	close(ch)
+		//}()
+
+	} else {
+		// 分片数据传输
+		var multiPartInfoList []models.MultiPartInfo
+		val, err := lgRedis.Get(context.Background(), fmt.Sprintf("%s-multiPart", uidStr)).Result()
+		// key在redis中不存在
+		if err == redis.Nil {
+			lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+			if err := lgDB.Model(&models.MultiPartInfo{}).Where(
+				"storage_uid = ? and status = ?", uid, 1).Order("chunk_num ASC").Find(&multiPartInfoList).Error; err != nil {
+				lgLogger.WithContext(c).Error("下载数据，查询分片数据失败")
+				web.InternalError(c, "查询分片数据失败")
+				return
+			}
+			// 写入redis
+			b, err := json.Marshal(multiPartInfoList)
+			if err != nil {
+				lgLogger.WithContext(c).Warn("下载数据，写入redis失败")
+			}
+			lgRedis.SetNX(context.Background(), fmt.Sprintf("%s-multiPart", uidStr), b, 5*60*time.Second)
+		} else {
+			if err != nil {
+				lgLogger.WithContext(c).Error("下载数据，查询redis失败")
+				web.InternalError(c, "")
+				return
+			}
+			var msg []models.MultiPartInfo
+			if err := json.Unmarshal([]byte(val), &msg); err != nil {
+				lgLogger.WithContext(c).Error("下载数据，查询reids，结果序列化失败")
+				web.InternalError(c, "")
+				return
+			}
+			// 续期
+			lgRedis.Expire(context.Background(), fmt.Sprintf("%s-multiPart", uidStr), 5*60*time.Second)
+			multiPartInfoList = msg
 		}
-		defer bodyData.Close()
-		// 避免响应体全部读入内存，导致内存溢出问题
-		buffer := new(bytes.Buffer)
-		_, err = io.Copy(buffer, bodyData)
-		if err != nil {
-			lgLogger.WithContext(c).Error("转发下载数据发送失败")
-			web.InternalError(c, "转发下载数据发送失败")
+
+		if meta.PartNum != len(multiPartInfoList) {
+			lgLogger.WithContext(c).Error("分片数量和整体数量不一致")
+			web.InternalError(c, "分片数量和整体数量不一致")
 			return
 		}
-		data := buffer.Bytes()
-		for len(data) > 0 {
-			chunkSize := 1024 * 1024 // 每次读取 1MB 数据
-			if len(data) < chunkSize {
-				chunkSize = len(data)
+
+		// 查找起始分片
+		index, totalSize := int64(0), int64(0)
+		var startP, lengthP int64
+		for {
+			if totalSize >= start {
+				startP, lengthP = 0, multiPartInfoList[index].StorageSize
+			} else {
+				if totalSize+multiPartInfoList[index].StorageSize > start {
+					startP, lengthP = start-totalSize, multiPartInfoList[index].StorageSize-(start-totalSize)
+				} else {
+					totalSize += multiPartInfoList[index].StorageSize
+					index++
+					continue
+				}
 			}
-			ch <- data[:chunkSize]
-			data = data[chunkSize:]
+			break
+		}
+		var chanSlice []chan int
+		for i := 0; i < utils.MultiPartDownload; i++ {
+			chanSlice = append(chanSlice, make(chan int, 1))
 		}
 
-		// 关闭 channel
-		close(ch)
-	} else {
-		// local在本地 || 其他os
-		if !meta.MultiPart {
-			go func() {
-				step := int64(1 * 1024 * 1024)
+		chanSlice[0] <- 1
+		j := 0
+		for i := 0; i < utils.MultiPartDownload; i++ {
+			go func(i int, startP_, lengthP_ int64) {
 				for {
-					if start >= end {
+					// 当前块计算完后，需要等待前一个块合并到主哈希
+					<-chanSlice[i]
+
+					if index >= int64(meta.PartNum) {
 						close(ch)
 						break
 					}
-					length := step
-					if start+length > end {
-						length = end - start + 1
+					if totalSize >= start {
+						startP_, lengthP_ = 0, multiPartInfoList[index].StorageSize
 					}
-					data, err := storage.NewStorage().Storage.GetObject(bucketName, objectName, start, length)
+					totalSize += multiPartInfoList[index].StorageSize
+
+					data, err := storage.NewStorage().Storage.GetObject(
+						multiPartInfoList[index].Bucket,
+						multiPartInfoList[index].StorageName,
+						startP_,
+						lengthP_,
+					)
 					if err != nil && err != io.EOF {
 						lgLogger.WithContext(c).Error(fmt.Sprintf("从对象存储获取数据失败%s", err.Error()))
 					}
+					// 合并到主哈希
 					ch <- data
-					start += step
-				}
-			}()
-
-			// 这种场景，会先从minio中获取全部数据，再流式传输，所以下载前会等待一下，但会把内存打爆
-			//go func() {
-			//	data, err := inner.NewStorage().Storage.GetObject(bucketName, objectName, start, end-start+1)
-			//	if err != nil && err != io.EOF {
-			//		lgLogger.WithContext(c).Error(fmt.Sprintf("从minio获取数据失败%s", err.Error()))
-			//	}
-			//	ch <- data
-			//	close(ch)
-			//}()
-
-		} else {
-			// 分片数据传输
-			var multiPartInfoList []models.MultiPartInfo
-			val, err := lgRedis.Get(context.Background(), fmt.Sprintf("%s-multiPart", uidStr)).Result()
-			// key在redis中不存在
-			if err == redis.Nil {
-				lgDB := new(plugins.LangGoDB).Use("default").NewDB()
-				if err := lgDB.Model(&models.MultiPartInfo{}).Where(
-					"storage_uid = ? and status = ?", uid, 1).Order("chunk_num ASC").Find(&multiPartInfoList).Error; err != nil {
-					lgLogger.WithContext(c).Error("下载数据，查询分片数据失败")
-					web.InternalError(c, "查询分片数据失败")
-					return
-				}
-				// 写入redis
-				b, err := json.Marshal(multiPartInfoList)
-				if err != nil {
-					lgLogger.WithContext(c).Warn("下载数据，写入redis失败")
-				}
-				lgRedis.SetNX(context.Background(), fmt.Sprintf("%s-multiPart", uidStr), b, 5*60*time.Second)
-			} else {
-				if err != nil {
-					lgLogger.WithContext(c).Error("下载数据，查询redis失败")
-					web.InternalError(c, "")
-					return
-				}
-				var msg []models.MultiPartInfo
-				if err := json.Unmarshal([]byte(val), &msg); err != nil {
-					lgLogger.WithContext(c).Error("下载数据，查询reids，结果序列化失败")
-					web.InternalError(c, "")
-					return
-				}
-				// 续期
-				lgRedis.Expire(context.Background(), fmt.Sprintf("%s-multiPart", uidStr), 5*60*time.Second)
-				multiPartInfoList = msg
-			}
-
-			if meta.PartNum != len(multiPartInfoList) {
-				lgLogger.WithContext(c).Error("分片数量和整体数量不一致")
-				web.InternalError(c, "分片数量和整体数量不一致")
-				return
-			}
-
-			// 查找起始分片
-			index, totalSize := int64(0), int64(0)
-			var startP, lengthP int64
-			for {
-				if totalSize >= start {
-					startP, lengthP = 0, multiPartInfoList[index].StorageSize
-				} else {
-					if totalSize+multiPartInfoList[index].StorageSize > start {
-						startP, lengthP = start-totalSize, multiPartInfoList[index].StorageSize-(start-totalSize)
+					index++
+					// 这里要注意适配chanSlice的长度
+					if j == utils.MultiPartDownload-1 {
+						j = 0
 					} else {
-						totalSize += multiPartInfoList[index].StorageSize
-						index++
-						continue
+						j++
 					}
+					chanSlice[j] <- 1
 				}
-				break
-			}
-			var chanSlice []chan int
-			for i := 0; i < utils.MultiPartDownload; i++ {
-				chanSlice = append(chanSlice, make(chan int, 1))
-			}
-
-			chanSlice[0] <- 1
-			j := 0
-			for i := 0; i < utils.MultiPartDownload; i++ {
-				go func(i int, startP_, lengthP_ int64) {
-					for {
-						// 当前块计算完后，需要等待前一个块合并到主哈希
-						<-chanSlice[i]
-
-						if index >= int64(meta.PartNum) {
-							close(ch)
-							break
-						}
-						if totalSize >= start {
-							startP_, lengthP_ = 0, multiPartInfoList[index].StorageSize
-						}
-						totalSize += multiPartInfoList[index].StorageSize
-
-						data, err := storage.NewStorage().Storage.GetObject(
-							multiPartInfoList[index].Bucket,
-							multiPartInfoList[index].StorageName,
-							startP_,
-							lengthP_,
-						)
-						if err != nil && err != io.EOF {
-							lgLogger.WithContext(c).Error(fmt.Sprintf("从对象存储获取数据失败%s", err.Error()))
-						}
-						// 合并到主哈希
-						ch <- data
-						index++
-						// 这里要注意适配chanSlice的长度
-						if j == utils.MultiPartDownload-1 {
-							j = 0
-						} else {
-							j++
-						}
-						chanSlice[j] <- 1
-					}
-				}(i, startP, lengthP)
-			}
+			}(i, startP, lengthP)
 		}
 	}
 
@@ -378,3 +330,21 @@ func DownloadHandler(c *gin.Context) {
 	})
 	return
 }
+
+// scanBlocked 数据不能下载时返回原因
+// 开启内容扫描时，合并完成前的分片数据尚未扫描，即使没有扫描状态也不提供下载
+func scanBlocked(meta *models.MetaDataInfo, scanEnabled bool) string {
+	switch meta.ScanStatus {
+	case utils.ScanStatusPending:
+		return "数据扫描中，暂不能下载"
+	case utils.ScanStatusQuarantined:
+		return "数据包含恶意内容，已隔离"
+	case utils.ScanStatusFailed:
+		return "数据扫描失败，暂不能下载"
+	case "":
+		if scanEnabled && meta.MultiPart {
+			return "数据扫描中，暂不能下载"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,51 @@
+package v0
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/qinguoyi/osproxy/app/models"
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/bootstrap"
+)
+
+func TestScanBlockedBeforeMerge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("scan:\n  mode: \"\"\n"), 0644); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	conf := bootstrap.NewConfig(path)
+	conf.Scan.Mode = utils.ScanClamd
+	defer func() { conf.Scan.Mode = "" }()
+
+	// 提交合并后、合并完成前，分片数据不能下载
+	columns := markScanPending(map[string]interface{}{"multi_part": true, "status": 1})
+	status, _ := columns["scan_status"].(string)
+	if status != utils.ScanStatusPending {
+		t.Fatalf("Expected merge submission to mark scan pending, but got %q", status)
+	}
+	meta := &models.MetaDataInfo{MultiPart: true, ScanStatus: status}
+	if scanBlocked(meta, true) == "" {
+		t.Errorf("Expected pending multipart data to be blocked")
+	}
+	// 未标记扫描状态的分片数据同样不能下载
+	if scanBlocked(&models.MetaDataInfo{MultiPart: true}, true) == "" {
+		t.Errorf("Expected unscanned multipart data to be blocked while scanning is enabled")
+	}
+
+	if scanBlocked(&models.MetaDataInfo{MultiPart: true}, false) != "" {
+		t.Errorf("Expected multipart data to be downloadable without scanning")
+	}
+	if scanBlocked(&models.MetaDataInfo{ScanStatus: utils.ScanStatusClean}, true) != "" {
+		t.Errorf("Expected clean data to be downloadable")
+	}
+	if scanBlocked(&models.MetaDataInfo{ScanStatus: utils.ScanStatusQuarantined}, true) == "" {
+		t.Errorf("Expected quarantined data to be blocked")
+	}
+
+	conf.Scan.Mode = ""
+	if _, ok := markScanPending(map[string]interface{}{})["scan_status"]; ok {
+		t.Errorf("Expected scan status to be left unset without scanning")
+	}
+}
@@ -0,0 +1,92 @@
+package v0
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qinguoyi/osproxy/app/pkg/base"
+	"github.com/qinguoyi/osproxy/app/pkg/drain"
+	"github.com/qinguoyi/osproxy/app/pkg/staging"
+	"github.com/qinguoyi/osproxy/app/pkg/web"
+	"go.uber.org/zap"
+)
+
+/*
+节点排空：管理接口触发排空，以及接收其他服务排空时推送的暂存分片
+*/
+
+// DrainHandler    排空当前服务
+//
+//	@Summary      排空当前服务
+//	@Description  摘除注册、拒绝新的上传链接，等待进行中的上传及合并完成后推送暂存的分片，只接受本机或集群内请求
+//	@Tags         管理
+//	@Accept       application/json
+//	@Produce      application/json
+//	@Success      200  {object}  web.Response
+//	@Router       /api/storage/v0/admin/drain [post]
+func DrainHandler(c *gin.Context) {
+	drain.Start()
+	lgLogger.WithContext(c).Info("收到排空请求")
+	web.Success(c, "")
+}
+
+// ReceiveStagingHandler    接收暂存分片
+//
+//	@Summary      接收暂存分片
+//	@Description  其他服务排空时推送本地暂存的分片，name为空时只创建目录，只接受集群内请求，未配置集群密钥及双向TLS时不注册
+//	@Tags         管理
+//	@Accept       application/octet-stream
+//	@Param        uid   query  string  true   "文件uid"
+//	@Param        name  query  string  false  "暂存文件名称"
+//	@Produce      application/json
+//	@Success      200  {object}  web.Response
+//	@Router       /api/storage/v0/proxy/staging [put]
+func ReceiveStagingHandler(c *gin.Context) {
+	// 无法确认请求来自集群内时不接收
+	if !base.PeerAuthEnabled() {
+		web.Forbidden(c, "未配置集群密钥及双向TLS，不接收暂存分片")
+		return
+	}
+	uid, err := strconv.ParseInt(c.Query("uid"), 10, 64)
+	if err != nil {
+		web.ParamsError(c, "uid参数有误")
+		return
+	}
+	name := c.Query("name")
+	if name != "" && (name != filepath.Base(name) || name == "." || name == "..") {
+		web.ParamsError(c, "name参数有误")
+		return
+	}
+	st := staging.NewStaging()
+	if st.Shared() {
+		web.ParamsError(c, "分片暂存在对象存储，无需推送")
+		return
+	}
+	if err := st.Create(uid); err != nil {
+		lgLogger.WithContext(c).Error("接收暂存分片，创建目录失败", zap.Any("err", err.Error()))
+		web.InternalError(c, "内部异常")
+		return
+	}
+	if name == "" {
+		web.Success(c, "")
+		return
+	}
+	dir, _ := st.Dir(uid)
+	f, err := os.Create(path.Join(dir, name))
+	if err != nil {
+		lgLogger.WithContext(c).Error("接收暂存分片，创建文件失败", zap.Any("err", err.Error()))
+		web.InternalError(c, "内部异常")
+		return
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, c.Request.Body); err != nil {
+		lgLogger.WithContext(c).Error("接收暂存分片，写入文件失败", zap.Any("err", err.Error()))
+		web.InternalError(c, "内部异常")
+		return
+	}
+	web.Success(c, "")
+}
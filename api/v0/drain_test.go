@@ -0,0 +1,42 @@
+package v0
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qinguoyi/osproxy/app/middleware"
+	"github.com/qinguoyi/osproxy/bootstrap"
+)
+
+func TestReceiveStagingRejected(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("cluster:\n  secret: \"\"\n"), 0644); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	conf := bootstrap.NewConfig(path)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.PUT("/proxy/staging", middleware.NewPeer(true).Handler(), ReceiveStagingHandler)
+	send := func() int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/proxy/staging?uid=1&name=1_1", nil))
+		return w.Code
+	}
+
+	// 未配置集群校验时无法确认请求来自集群内
+	if code := send(); code != http.StatusForbidden {
+		t.Errorf("Expected 403 without peer auth, but got %d", code)
+	}
+
+	// 配置集群密钥后拒绝未签名的请求
+	conf.Cluster.Secret = "test-secret"
+	defer func() { conf.Cluster.Secret = "" }()
+	if code := send(); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for unsigned request, but got %d", code)
+	}
+}
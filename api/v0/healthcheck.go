@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/gin-gonic/gin"
+	"github.com/qinguoyi/osproxy/app/pkg/drain"
 	"github.com/qinguoyi/osproxy/app/pkg/web"
 	"github.com/qinguoyi/osproxy/bootstrap"
 	"github.com/qinguoyi/osproxy/bootstrap/plugins"
@@ -59,6 +60,11 @@ func PingHandler(c *gin.Context) { // PingHandler()函数用于处理GET请求
 //  @Success      200  {object}  web.Response
 //  @Router       /api/storage/v0/health [get]
 func HealthCheckHandler(c *gin.Context) {
+	// 排空中返回503，负载均衡及k8s据此摘除流量
+	if drain.Draining() {
+		web.ServiceUnavailable(c, "Draining...")
+		return
+	}
 	web.Success(c, "Health...")
 	return
 }
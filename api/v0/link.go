@@ -1,19 +1,17 @@
 package v0
 
 import (
-	"context"
-	"encoding/json"
 	"fmt"
-	"os"
-	"path"
 	"strconv"
 	"sync"
 
 	"github.com/gin-gonic/gin"
-	"github.com/go-redis/redis/v8"
 	"github.com/qinguoyi/osproxy/app/models"
 	"github.com/qinguoyi/osproxy/app/pkg/base"
+	"github.com/qinguoyi/osproxy/app/pkg/drain"
 	"github.com/qinguoyi/osproxy/app/pkg/repo"
+	"github.com/qinguoyi/osproxy/app/pkg/staging"
+	"github.com/qinguoyi/osproxy/app/pkg/storage"
 	"github.com/qinguoyi/osproxy/app/pkg/utils"
 	"github.com/qinguoyi/osproxy/app/pkg/web"
 	"github.com/qinguoyi/osproxy/bootstrap/plugins"
@@ -35,6 +33,10 @@ import (
 //	@Success      200  {object}  web.Response{data=models.GenUploadResp}
 //	@Router       /api/storage/v0/link/upload [post]
 func UploadLinkHandler(c *gin.Context) {
+	if drain.Draining() {
+		web.ServiceUnavailable(c, "当前服务排空中，不再生成上传链接")
+		return
+	}
 	var genUploadReq models.GenUpload // GenUpload是一个结构体，包含两个成员变量，一个是FilePath，一个是Expire
 	if err := c.ShouldBindJSON(&genUploadReq); err != nil {
 		web.ParamsError(c, fmt.Sprintf("参数解析有误，详情：%s", err))
@@ -44,6 +46,23 @@ func UploadLinkHandler(c *gin.Context) {
 		web.ParamsError(c, fmt.Sprintf("批量上传路径数量有限，最多%d条", utils.LinkLimit))
 		return
 	}
+	if genUploadReq.Direct {
+		if _, ok := storage.NewStorage().Presign(); !ok {
+			web.ParamsError(c, "当前存储不支持直传")
+			return
+		}
+		if genUploadReq.Expire <= 0 || genUploadReq.Expire > utils.DirectMaxExpire {
+			web.ParamsError(c, fmt.Sprintf("直传的过期时间范围为1-%d秒", utils.DirectMaxExpire))
+			return
+		}
+		if genUploadReq.PartNum < 0 || genUploadReq.PartNum > utils.DirectMaxPart {
+			web.ParamsError(c, fmt.Sprintf("直传的分片数量范围为0-%d", utils.DirectMaxPart))
+			return
+		}
+	} else if genUploadReq.PartNum != 0 {
+		web.ParamsError(c, "partNum仅在直传时有效")
+		return
+	}
 
 	// deduplication filepath 翻译：去重文件路径 deduplication是去重的意思
 	fileNameList := utils.RemoveDuplicates(genUploadReq.FilePath) // RemoveDuplicates()函数用于去重
@@ -82,9 +101,9 @@ func UploadLinkHandler(c *gin.Context) {
 	if !(len(resp) == len(resourceInfo) && len(resp) == len(fileNameList)) {
 		// clean local dir
 		for _, i := range resp {
-			dirName := path.Join(utils.LocalStore, i.Uid)
+			uid, _ := strconv.ParseInt(i.Uid, 10, 64)
 			go func() {
-				_ = os.RemoveAll(dirName)
+				_ = staging.NewStaging().Remove(uid)
 			}()
 		}
 		lgLogger.WithContext(c).Error("生成链接，生成的url和输入数量不一致")
@@ -92,6 +111,23 @@ func UploadLinkHandler(c *gin.Context) {
 		return
 	}
 
+	// 直传时生成预签名链接，数据不经过本地，清理本地目录
+	if genUploadReq.Direct {
+		uidMapMeta := map[string]*models.MetaDataInfo{}
+		for i := range resourceInfo {
+			uidMapMeta[strconv.FormatInt(resourceInfo[i].UID, 10)] = &resourceInfo[i]
+		}
+		for i := range resp {
+			if err := base.GenDirectUpload(uidMapMeta[resp[i].Uid], &resp[i], genUploadReq.PartNum,
+				genUploadReq.Expire); err != nil {
+				lgLogger.WithContext(c).Error("生成链接，生成预签名链接失败，详情：", zap.Any("err", err.Error()))
+				web.InternalError(c, "内部异常")
+				return
+			}
+			_ = staging.NewStaging().Remove(uidMapMeta[resp[i].Uid].UID)
+		}
+	}
+
 	// db batch create
 	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
 	if err := repo.NewMetaDataInfoRepo().BatchCreate(lgDB, &resourceInfo); err != nil {
@@ -123,6 +159,10 @@ func DownloadLinkHandler(c *gin.Context) {
 		web.ParamsError(c, "uid获取下载链接，数量不能超过200个")
 		return
 	}
+	if genDownloadReq.MaxDownload < 0 {
+		web.ParamsError(c, "maxDownload参数有误")
+		return
+	}
 	expireStr := fmt.Sprintf("%d", genDownloadReq.Expire)
 	var uidList []int64
 	var resp []models.GenDownloadResp
@@ -132,30 +172,25 @@ func DownloadLinkHandler(c *gin.Context) {
 			web.ParamsError(c, "uid参数有误")
 			return
 		}
-
-		// 查询redis
-		key := fmt.Sprintf("%d-%s", uid, expireStr)
-		lgRedis := new(plugins.LangGoRedis).NewRedis()
-		val, err := lgRedis.Get(context.Background(), key).Result()
-		// Get()函数用于获取key对应的value，如果key不存在，那么返回redis.Nil
-		// key在redis中不存在
-		if err == redis.Nil {
+		// 限制次数的链接每次都重新生成
+		if genDownloadReq.MaxDownload > 0 {
 			uidList = append(uidList, uid)
 			continue
 		}
+
+		// 查询redis
+		msg, err := base.GetCachedDownloadLink(uid, expireStr)
 		if err != nil {
 			lgLogger.WithContext(c).Error("获取下载链接，查询redis失败")
 			web.InternalError(c, "")
 			return
 		}
-		var msg models.GenDownloadResp
-		if err := json.Unmarshal([]byte(val), &msg); err != nil {
-			// []byte(val)是将val转换成[]byte类型的数据，json.Unmarshal()函数用于将json字符串转换成结构体
-			lgLogger.WithContext(c).Error("查询redis结果，序列化失败")
-			web.InternalError(c, "")
-			return
+		// key在redis中不存在
+		if msg == nil {
+			uidList = append(uidList, uid)
+			continue
 		}
-		resp = append(resp, msg)
+		resp = append(resp, *msg)
 	}
 
 	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
@@ -169,23 +204,59 @@ func DownloadLinkHandler(c *gin.Context) {
 		web.Success(c, nil)
 		return
 	}
-	uidMapMeta := map[int64]models.MetaDataInfo{}
-	for _, meta := range metaList {
-		uidMapMeta[meta.UID] = meta
-	}
-
-	respChan := make(chan models.GenDownloadResp, len(metaList))
-	var wg sync.WaitGroup
-	for _, uid := range uidList {
-		wg.Add(1)
-		go base.GenDownloadSingle(uidMapMeta[uid], expireStr, respChan, &wg)
+	linkResp, err := base.GenDownloadLinks(metaList, expireStr, genDownloadReq.MaxDownload)
+	if err != nil {
+		lgLogger.WithContext(c).Error("获取下载链接，批量落数据库失败，详情：", zap.Any("err", err.Error()))
+		web.InternalError(c, "内部异常")
+		return
 	}
-	wg.Wait()
-	close(respChan)
-
-	for re := range respChan {
+	for _, re := range linkResp {
+		if genDownloadReq.MaxDownload == 0 {
+			base.CacheDownloadLink(re, expireStr)
+		}
 		resp = append(resp, re)
 	}
 	web.Success(c, resp)
 	return
 }
+
+// RevokeLinkHandler    吊销下载链接
+//
+//	@Summary      吊销下载链接
+//	@Description  按uid或linkId吊销下载链接，吊销后链接立即失效
+//	@Tags         链接
+//	@Accept       application/json
+//	@Param        RequestBody  body  models.RevokeLink  true  "吊销链接请求体"
+//	@Produce      application/json
+//	@Success      200  {object}  web.Response
+//	@Router       /api/storage/v0/link/revoke [post]
+func RevokeLinkHandler(c *gin.Context) {
+	var revokeReq models.RevokeLink
+	if err := c.ShouldBindJSON(&revokeReq); err != nil {
+		web.ParamsError(c, fmt.Sprintf("参数解析有误，详情：%s", err))
+		return
+	}
+	if len(revokeReq.Uid) == 0 && len(revokeReq.LinkId) == 0 {
+		web.ParamsError(c, "uid和linkId不能同时为空")
+		return
+	}
+	if len(revokeReq.Uid)+len(revokeReq.LinkId) > 200 {
+		web.ParamsError(c, "吊销链接，数量不能超过200个")
+		return
+	}
+	var uidList []int64
+	for _, uidStr := range utils.RemoveDuplicates(revokeReq.Uid) {
+		uid, err := strconv.ParseInt(uidStr, 10, 64)
+		if err != nil {
+			web.ParamsError(c, "uid参数有误")
+			return
+		}
+		uidList = append(uidList, uid)
+	}
+	if err := base.RevokeDownloadLinks(uidList, utils.RemoveDuplicates(revokeReq.LinkId)); err != nil {
+		lgLogger.WithContext(c).Error("吊销下载链接失败，详情：", zap.Any("err", err.Error()))
+		web.InternalError(c, "内部异常")
+		return
+	}
+	web.Success(c, "")
+}
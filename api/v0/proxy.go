@@ -1,15 +1,19 @@
 package v0
 
 import (
+	"errors"
 	"fmt"
-	"os"
-	"path"
 	"strconv"
+	"sync"
 
 	"github.com/gin-gonic/gin"
+	"github.com/qinguoyi/osproxy/app/models"
 	"github.com/qinguoyi/osproxy/app/pkg/base"
+	"github.com/qinguoyi/osproxy/app/pkg/staging"
+	"github.com/qinguoyi/osproxy/app/pkg/thirdparty"
 	"github.com/qinguoyi/osproxy/app/pkg/utils"
 	"github.com/qinguoyi/osproxy/app/pkg/web"
+	"github.com/qinguoyi/osproxy/bootstrap"
 )
 
 // IsOnCurrentServerHandler   .
@@ -24,17 +28,16 @@ import (
 //	@Router       /api/storage/v0/proxy [get]
 func IsOnCurrentServerHandler(c *gin.Context) {
 	uidStr := c.Query("uid")
-	_, err := strconv.ParseInt(uidStr, 10, 64) // ParseInt()函数用于将字符串转换成int64类型的数字
+	uid, err := strconv.ParseInt(uidStr, 10, 64) // ParseInt()函数用于将字符串转换成int64类型的数字
 	if err != nil {
 		web.ParamsError(c, fmt.Sprintf("uid参数有误，详情:%s", err))
 		return
 	}
-	dirName := path.Join(utils.LocalStore, uidStr) // Join()函数用于将多个字符串拼接成一个字符串
-	if _, err := os.Stat(dirName); os.IsNotExist(err) {
+	if !staging.NewStaging().Exists(uid) {
 		web.NotFoundResource(c, "")
 		return
 	} else {
-		ip, err := base.GetOutBoundIP()
+		ip, err := base.LocalNodeID()
 		if err != nil {
 			panic(err)
 		}
@@ -42,3 +45,66 @@ func IsOnCurrentServerHandler(c *gin.Context) {
 		return
 	}
 }
+
+// locateServer 定位uid本地目录所在服务，优先一跳转发到元数据记录的服务，记录缺失或服务已下线时广播询问
+func locateServer(uidStr string, ownerNode string) (string, string, error) {
+	if localNode, err := base.LocalNodeID(); ownerNode != "" && (err != nil || ownerNode != localNode) {
+		service, err := base.NewDiscovery().Get(ownerNode)
+		if err == nil && service != nil {
+			return service.IP, service.Port, nil
+		}
+	}
+	serviceList, err := base.NewDiscovery().Discovery()
+	if err != nil || serviceList == nil {
+		return "", "", errors.New("发现其他服务失败")
+	}
+	var wg sync.WaitGroup
+	ipChan := make(chan string, len(serviceList))
+	for _, service := range serviceList {
+		wg.Add(1)
+		go func(ip string, port string) {
+			defer wg.Done()
+			res, err := thirdparty.NewStorageService().Locate(base.PeerScheme(), ip, port, uidStr)
+			if err != nil {
+				return
+			}
+			ipChan <- res
+		}(service.IP, service.Port)
+	}
+	wg.Wait()
+	close(ipChan)
+	if re, ok := <-ipChan; ok {
+		for _, service := range serviceList {
+			if service.IP == re {
+				return re, service.Port, nil
+			}
+		}
+		return re, bootstrap.NewConfig("").App.Port, nil
+	}
+	return "", "", errors.New("发现其他服务失败")
+}
+
+// forwardToOwner 当前服务不能处理时转发到上传目录所在服务，返回是否已转发
+func forwardToOwner(c *gin.Context, metaData *models.MetaDataInfo) bool {
+	if staging.NewStaging().Exists(metaData.UID) {
+		return false
+	}
+	forwardRequest(c, strconv.FormatInt(metaData.UID, 10), metaData.OwnerNode)
+	return true
+}
+
+// forwardRequest 将请求原样转发到uid本地目录所在服务，其他服务转发过来的请求不再转发，避免循环
+func forwardRequest(c *gin.Context, uidStr string, ownerNode string) {
+	if c.GetBool(utils.PeerContextKey) {
+		lgLogger.WithContext(c).Error("转发的请求在当前服务不存在")
+		web.NotFoundResource(c, "数据不在当前服务")
+		return
+	}
+	proxyIP, proxyPort, err := locateServer(uidStr, ownerNode)
+	if err != nil {
+		lgLogger.WithContext(c).Error("发现其他服务失败")
+		web.NotFoundResource(c, "发现其他服务失败")
+		return
+	}
+	thirdparty.NewStorageService().RawForward(c, base.PeerScheme(), proxyIP, proxyPort)
+}
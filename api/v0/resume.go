@@ -95,7 +95,7 @@ func ResumeHandler(c *gin.Context) { //context是gin的上下文，它包含了
 			continue
 		}
 		// 相同数据上传需要复制一份数据
-		uid, _ := base.NewSnowFlake().NextId() // NewSnowFlake()函数用于创建一个雪花算法实例，NextId()函数用于获取下一个id
+		uid, _ := base.NewIdGenerator().NextId() // NewIdGenerator()函数用于获取发号器，NextId()函数用于获取下一个id
 		now := time.Now()
 		newMetaDataList = append(newMetaDataList,
 			models.MetaDataInfo{
@@ -109,6 +109,9 @@ func ResumeHandler(c *gin.Context) { //context是gin的上下文，它包含了
 				StorageSize: md5MapMetaInfo[resume.Md5].StorageSize,
 				Status:      1,
 				ContentType: md5MapMetaInfo[resume.Md5].ContentType,
+				ScanStatus:  md5MapMetaInfo[resume.Md5].ScanStatus,
+				ScanResult:  md5MapMetaInfo[resume.Md5].ScanResult,
+				ScannedAt:   md5MapMetaInfo[resume.Md5].ScannedAt,
 				CreatedAt:   &now,
 				UpdatedAt:   &now,
 			})
@@ -116,13 +119,18 @@ func ResumeHandler(c *gin.Context) { //context是gin的上下文，它包含了
 	} // 这个循环的作用是：newMetaDataList中添加数据，md5MapResp中添加数据
 
 	if len(newMetaDataList) != 0 { // 如果newMetaDataList的长度不为0，那么就将newMetaDataList中的数据落到数据库中
-		if err := repo.NewMetaDataInfoRepo().BatchCreate(lgDB, &newMetaDataList); err != nil {
+		if err := repo.NewMetaDataInfoRepo().BatchCreateWithEvent(lgDB, &newMetaDataList,
+			utils.EventObjectUploaded); err != nil {
 			// BatchCreate()函数用于批量创建元数据信息，这里的批量创建是指一次性创建多条数据
 			// NewMetaDataInfoRepo()函数用于创建一个元数据信息仓库
 			lgLogger.WithContext(c).Error("秒传批量落数据库失败，详情：", zap.Any("err", err.Error()))
 			web.InternalError(c, "内部异常")
 			return
 		}
+		// 秒传的对象同样推送上传完成事件
+		for _, metaData := range newMetaDataList {
+			publishObject(c, lgDB, utils.EventObjectUploaded, metaData.UID)
+		}
 	}
 	lgRedis := new(plugins.LangGoRedis).NewRedis()
 	for _, metaDataCache := range newMetaDataList { // 遍历newMetaDataList，newMetaDataList是一个切片，切片的元素是MetaDataInfo类型
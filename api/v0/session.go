@@ -0,0 +1,377 @@
+package v0
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qinguoyi/osproxy/app/models"
+	"github.com/qinguoyi/osproxy/app/pkg/base"
+	"github.com/qinguoyi/osproxy/app/pkg/repo"
+	"github.com/qinguoyi/osproxy/app/pkg/staging"
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/app/pkg/web"
+	"github.com/qinguoyi/osproxy/bootstrap/plugins"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+/*
+分片上传会话：创建、查询进度、终止、完成
+分片仍通过/upload/multi上传，存在会话时校验分片序号及大小，可乱序并发上传
+*/
+
+// InitSessionHandler    创建上传会话
+//
+//	@Summary      创建上传会话
+//	@Description  声明文件总大小及分片大小，之后按分片序号乱序并发上传
+//	@Tags         上传会话
+//	@Accept       application/json
+//	@Param        uid          query  string              true  "文件uid"
+//	@Param        date         query  string              true  "链接生成时间"
+//	@Param        expire       query  string              true  "过期时间"
+//	@Param        signature    query  string              true  "签名"
+//	@Param        RequestBody  body   models.InitSession  true  "创建上传会话请求体"
+//	@Produce      application/json
+//	@Success      200  {object}  web.Response{data=models.SessionResp}
+//	@Router       /api/storage/v0/upload/session [post]
+func InitSessionHandler(c *gin.Context) {
+	uid, ok := checkSessionSignature(c)
+	if !ok {
+		return
+	}
+	var initReq models.InitSession
+	if err := c.ShouldBindJSON(&initReq); err != nil {
+		web.ParamsError(c, fmt.Sprintf("参数解析有误，详情：%s", err))
+		return
+	}
+	if initReq.TotalSize <= 0 || initReq.ChunkSize <= 0 || initReq.Expire < 0 {
+		web.ParamsError(c, "totalSize、chunkSize或expire参数有误")
+		return
+	}
+	chunkSum := (initReq.TotalSize + initReq.ChunkSize - 1) / initReq.ChunkSize
+	if chunkSum > utils.SessionMaxChunk {
+		web.ParamsError(c, fmt.Sprintf("分片数量不能超过%d个", utils.SessionMaxChunk))
+		return
+	}
+	expire := initReq.Expire
+	if expire == 0 {
+		expire = utils.SessionDefaultExpire
+	}
+
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	metaData, err := repo.NewMetaDataInfoRepo().GetByUid(lgDB, uid)
+	if err != nil {
+		web.NotFoundResource(c, "当前上传链接无效，uid不存在")
+		return
+	}
+	if metaData.Status == 1 {
+		web.ParamsError(c, "文件已上传")
+		return
+	}
+	// 已终止或已过期的会话可以重新创建
+	existing, err := repo.NewUploadSessionRepo().GetByUid(lgDB, uid)
+	reinit := err == nil
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		lgLogger.WithContext(c).Error("创建上传会话，查询上传会话失败")
+		web.InternalError(c, "内部异常")
+		return
+	}
+	if reinit && !reinitSession(c, lgDB, existing) {
+		return
+	}
+
+	now := time.Now()
+	expireAt := now.Add(time.Duration(expire) * time.Second)
+	session := models.UploadSession{
+		UID:       uid,
+		TotalSize: initReq.TotalSize,
+		ChunkSize: initReq.ChunkSize,
+		ChunkSum:  int(chunkSum),
+		Md5:       initReq.Md5,
+		Status:    utils.SessionStatusUploading,
+		ExpireAt:  &expireAt,
+		CreatedAt: &now,
+		UpdatedAt: &now,
+	}
+	if reinit {
+		// 旧会话的分片已清理或置为无效，会话按新参数覆盖
+		err = lgDB.Transaction(func(tx *gorm.DB) error {
+			if err := repo.NewMultiPartInfoRepo().Updates(tx, uid, map[string]interface{}{
+				"status": -1,
+			}); err != nil {
+				return err
+			}
+			return repo.NewUploadSessionRepo().Updates(tx, uid, map[string]interface{}{
+				"total_size": session.TotalSize,
+				"chunk_size": session.ChunkSize,
+				"chunk_sum":  session.ChunkSum,
+				"md5":        session.Md5,
+				"status":     session.Status,
+				"expire_at":  session.ExpireAt,
+				"created_at": session.CreatedAt,
+				"updated_at": session.UpdatedAt,
+			})
+		})
+	} else {
+		err = repo.NewUploadSessionRepo().Create(lgDB, &session)
+	}
+	if err != nil {
+		lgLogger.WithContext(c).Error("创建上传会话，落数据库失败", zap.Any("err", err.Error()))
+		web.InternalError(c, "内部异常")
+		return
+	}
+	bitmap, _, _ := base.SessionBitmap(&session, nil)
+	web.Success(c, genSessionResp(&session, bitmap, nil))
+}
+
+// SessionStatusHandler    查询上传会话
+//
+//	@Summary      查询上传会话
+//	@Description  返回每个分片的上传状态位图及已上传大小
+//	@Tags         上传会话
+//	@Accept       application/json
+//	@Param        uid        query  string  true  "文件uid"
+//	@Param        date       query  string  true  "链接生成时间"
+//	@Param        expire     query  string  true  "过期时间"
+//	@Param        signature  query  string  true  "签名"
+//	@Produce      application/json
+//	@Success      200  {object}  web.Response{data=models.SessionResp}
+//	@Router       /api/storage/v0/upload/session [get]
+func SessionStatusHandler(c *gin.Context) {
+	uid, ok := checkSessionSignature(c)
+	if !ok {
+		return
+	}
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	session, ok := getSession(c, lgDB, uid)
+	if !ok {
+		return
+	}
+	parts, err := repo.NewMultiPartInfoRepo().GetUploadedPartsByUid(lgDB, uid)
+	if err != nil {
+		lgLogger.WithContext(c).Error("查询上传会话，查询分片信息失败")
+		web.InternalError(c, "内部异常")
+		return
+	}
+	bitmap, _, _ := base.SessionBitmap(session, parts)
+	web.Success(c, genSessionResp(session, bitmap, parts))
+}
+
+// AbortSessionHandler    终止上传会话
+//
+//	@Summary      终止上传会话
+//	@Description  终止上传会话，清理已上传的分片
+//	@Tags         上传会话
+//	@Accept       application/json
+//	@Param        uid        query  string  true  "文件uid"
+//	@Param        date       query  string  true  "链接生成时间"
+//	@Param        expire     query  string  true  "过期时间"
+//	@Param        signature  query  string  true  "签名"
+//	@Produce      application/json
+//	@Success      200  {object}  web.Response
+//	@Router       /api/storage/v0/upload/session [delete]
+func AbortSessionHandler(c *gin.Context) {
+	uid, ok := checkSessionSignature(c)
+	if !ok {
+		return
+	}
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	session, ok := getSession(c, lgDB, uid)
+	if !ok {
+		return
+	}
+	if session.Status == utils.SessionStatusComplete {
+		web.ParamsError(c, "上传会话已完成，不能终止")
+		return
+	}
+	if session.Status == utils.SessionStatusAbort {
+		web.Success(c, "")
+		return
+	}
+
+	now := time.Now()
+	if err := lgDB.Transaction(func(tx *gorm.DB) error {
+		if err := repo.NewUploadSessionRepo().Updates(tx, uid, map[string]interface{}{
+			"status":     utils.SessionStatusAbort,
+			"updated_at": &now,
+		}); err != nil {
+			return err
+		}
+		metaData, err := repo.NewMetaDataInfoRepo().GetByUid(tx, uid)
+		if err != nil {
+			return err
+		}
+		return repo.NewOutboxRepo().Append(tx, utils.EventObjectDeleted, uid, models.NewObjectEventData(metaData))
+	}); err != nil {
+		lgLogger.WithContext(c).Error("终止上传会话失败", zap.Any("err", err.Error()))
+		web.InternalError(c, "内部异常")
+		return
+	}
+	// 本地分片直接删除，对象存储中的分片交给删除任务清理
+	_ = staging.NewStaging().Remove(uid)
+	b, err := json.Marshal(models.MergeInfo{StorageUid: uid, ChunkSum: int64(session.ChunkSum)})
+	if err != nil {
+		lgLogger.WithContext(c).Error("消息struct转成json字符串失败", zap.Any("err", err.Error()))
+		web.InternalError(c, "创建删除任务失败")
+		return
+	}
+	if err := repo.NewTaskRepo().Create(lgDB, &models.TaskInfo{
+		Status:    utils.TaskStatusUndo,
+		TaskType:  utils.TaskPartDelete,
+		ExtraData: string(b),
+		RefId:     uid,
+	}); err != nil {
+		lgLogger.WithContext(c).Error("终止上传会话，创建删除任务失败", zap.Any("err", err.Error()))
+		web.InternalError(c, "创建删除任务失败")
+		return
+	}
+	publishObject(c, lgDB, utils.EventObjectDeleted, uid)
+	web.Success(c, "")
+}
+
+// CompleteSessionHandler    完成上传会话
+//
+//	@Summary      完成上传会话
+//	@Description  校验分片齐全且大小合计等于文件总大小后创建合并任务
+//	@Tags         上传会话
+//	@Accept       application/json
+//	@Param        uid        query  string  true  "文件uid"
+//	@Param        date       query  string  true  "链接生成时间"
+//	@Param        expire     query  string  true  "过期时间"
+//	@Param        signature  query  string  true  "签名"
+//	@Produce      application/json
+//	@Success      200  {object}  web.Response
+//	@Router       /api/storage/v0/upload/session/complete [put]
+func CompleteSessionHandler(c *gin.Context) {
+	uid, ok := checkSessionSignature(c)
+	if !ok {
+		return
+	}
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	session, ok := getSession(c, lgDB, uid)
+	if !ok {
+		return
+	}
+	if session.Status == utils.SessionStatusComplete {
+		web.Success(c, "")
+		return
+	}
+	if err := base.CheckSessionActive(session); err != nil {
+		web.ParamsError(c, err.Error())
+		return
+	}
+	metaData, err := repo.NewMetaDataInfoRepo().GetByUid(lgDB, uid)
+	if err != nil {
+		web.NotFoundResource(c, "当前合并链接无效，uid不存在")
+		return
+	}
+
+	parts, err := repo.NewMultiPartInfoRepo().GetUploadedPartsByUid(lgDB, uid)
+	if err != nil {
+		lgLogger.WithContext(c).Error("完成上传会话，查询分片信息失败")
+		web.InternalError(c, "查询分片数据失败")
+		return
+	}
+	_, missing, duplicate := base.SessionBitmap(session, parts)
+	if len(missing) != 0 {
+		web.ParamsError(c, fmt.Sprintf("分片未上传完成，缺少%d个分片，首个缺少的分片:%d", len(missing), missing[0]))
+		return
+	}
+	if len(duplicate) != 0 || len(parts) != session.ChunkSum {
+		web.ParamsError(c, "存在重复或超出范围的分片")
+		return
+	}
+	for _, part := range parts {
+		if expect := base.ExpectedChunkSize(session, part.ChunkNum); part.StorageSize != expect {
+			web.ParamsError(c, fmt.Sprintf("分片%d大小有误，应为%d，实际%d", part.ChunkNum, expect, part.StorageSize))
+			return
+		}
+	}
+
+	// 合并需要在分片所在服务执行
+	if forwardToOwner(c, metaData) {
+		return
+	}
+	mergeLocalParts(c, metaData, parts, session.Md5)
+}
+
+// checkSessionSignature 校验上传链接的签名
+func checkSessionSignature(c *gin.Context) (int64, bool) {
+	date := c.Query("date")
+	expireStr := c.Query("expire")
+	uid, err, errorInfo := base.CheckValid(c.Query("uid"), date, expireStr)
+	if err != nil {
+		web.ParamsError(c, errorInfo)
+		return 0, false
+	}
+	if !base.CheckUploadSignature(date, expireStr, c.Query("signature")) {
+		web.ParamsError(c, "签名校验失败")
+		return 0, false
+	}
+	return uid, true
+}
+
+// reinitSession 已存在会话时，只有已终止或已过期的会话可以重新创建
+// 终止后的删除任务未完成时不能重新创建，否则删除任务会清理新上传的分片
+func reinitSession(c *gin.Context, db *gorm.DB, session *models.UploadSession) bool {
+	switch {
+	case session.Status == utils.SessionStatusAbort:
+		task, err := repo.NewTaskRepo().GetLatestByRef(db, utils.TaskPartDelete, session.UID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			lgLogger.WithContext(c).Error("创建上传会话，查询删除任务失败")
+			web.InternalError(c, "内部异常")
+			return false
+		}
+		if err == nil && (task.Status == utils.TaskStatusUndo || task.Status == utils.TaskStatusRunning) {
+			web.ParamsError(c, "上一次上传的分片正在清理，请稍后重试")
+			return false
+		}
+		return true
+	case session.Status == utils.SessionStatusUploading && session.ExpireAt != nil &&
+		session.ExpireAt.Before(time.Now()):
+		// 过期会话的分片没有删除任务，直接清理暂存区
+		if err := staging.NewStaging().Remove(session.UID); err != nil {
+			lgLogger.WithContext(c).Error("创建上传会话，清理过期会话的分片失败", zap.Any("err", err.Error()))
+			web.InternalError(c, "内部异常")
+			return false
+		}
+		return true
+	default:
+		web.ParamsError(c, "上传会话已存在")
+		return false
+	}
+}
+
+// getSession 查询上传会话，不存在时响应404
+func getSession(c *gin.Context, db *gorm.DB, uid int64) (*models.UploadSession, bool) {
+	session, err := repo.NewUploadSessionRepo().GetByUid(db, uid)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			web.NotFoundResource(c, "上传会话不存在")
+			return nil, false
+		}
+		lgLogger.WithContext(c).Error("查询上传会话失败")
+		web.InternalError(c, "内部异常")
+		return nil, false
+	}
+	return session, true
+}
+
+func genSessionResp(session *models.UploadSession, bitmap string, parts []models.MultiPartInfo) models.SessionResp {
+	return models.SessionResp{
+		Uid:          strconv.FormatInt(session.UID, 10),
+		TotalSize:    session.TotalSize,
+		ChunkSize:    session.ChunkSize,
+		ChunkSum:     session.ChunkSum,
+		Md5:          session.Md5,
+		Status:       session.Status,
+		ExpireAt:     session.ExpireAt,
+		Bitmap:       bitmap,
+		UploadedNum:  len(parts),
+		UploadedSize: sumPartSize(parts),
+	}
+}
@@ -0,0 +1,299 @@
+package v0
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qinguoyi/osproxy/app/models"
+	"github.com/qinguoyi/osproxy/app/pkg/base"
+	"github.com/qinguoyi/osproxy/app/pkg/repo"
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/app/pkg/web"
+	"github.com/qinguoyi/osproxy/bootstrap/plugins"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+/*
+分享链接，分享码解析为签名下载链接
+*/
+
+// CreateShareHandler    创建分享
+//
+//	@Summary      创建分享
+//	@Description  为一个或多个uid创建分享码，可设置访问密码、过期时间及访问次数
+//	@Tags         分享
+//	@Accept       application/json
+//	@Param        RequestBody  body  models.GenShare  true  "创建分享请求体"
+//	@Produce      application/json
+//	@Success      200  {object}  web.Response{data=models.GenShareResp}
+//	@Router       /api/storage/v0/share [post]
+func CreateShareHandler(c *gin.Context) {
+	var genShareReq models.GenShare
+	if err := c.ShouldBindJSON(&genShareReq); err != nil {
+		web.ParamsError(c, fmt.Sprintf("参数解析有误，详情：%s", err))
+		return
+	}
+	if len(genShareReq.Uid) > 200 {
+		web.ParamsError(c, "创建分享，uid数量不能超过200个")
+		return
+	}
+	if genShareReq.Expire <= 0 || genShareReq.MaxDownload < 0 {
+		web.ParamsError(c, "expire或maxDownload参数有误")
+		return
+	}
+	uidStrList := utils.RemoveDuplicates(genShareReq.Uid)
+	var uidList []int64
+	for _, uidStr := range uidStrList {
+		uid, err := strconv.ParseInt(uidStr, 10, 64)
+		if err != nil {
+			web.ParamsError(c, "uid参数有误")
+			return
+		}
+		uidList = append(uidList, uid)
+	}
+
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	metaList, err := repo.NewMetaDataInfoRepo().GetByUidList(lgDB, uidList)
+	if err != nil {
+		lgLogger.WithContext(c).Error("创建分享，查询元数据信息失败")
+		web.InternalError(c, "内部异常")
+		return
+	}
+	uploaded := map[int64]bool{}
+	for _, meta := range metaList {
+		if meta.Status == 1 {
+			uploaded[meta.UID] = true
+		}
+	}
+	for _, uid := range uidList {
+		if !uploaded[uid] {
+			web.ParamsError(c, fmt.Sprintf("uid[%d]不存在或未上传完成", uid))
+			return
+		}
+	}
+
+	code, err := base.GenShareCode()
+	if err != nil {
+		lgLogger.WithContext(c).Error("创建分享，生成分享码失败，详情：", zap.Any("err", err.Error()))
+		web.InternalError(c, "内部异常")
+		return
+	}
+	passwordHash, err := base.HashSharePassword(genShareReq.Password)
+	if err != nil {
+		lgLogger.WithContext(c).Error("创建分享，密码哈希失败，详情：", zap.Any("err", err.Error()))
+		web.InternalError(c, "内部异常")
+		return
+	}
+	now := time.Now()
+	expireAt := now.Add(time.Duration(genShareReq.Expire) * time.Second)
+	share := models.Share{
+		Code:         code,
+		Uids:         strings.Join(uidStrList, ","),
+		Owner:        genShareReq.Owner,
+		PasswordHash: passwordHash,
+		MaxDownload:  genShareReq.MaxDownload,
+		ExpireAt:     &expireAt,
+		CreatedAt:    &now,
+		UpdatedAt:    &now,
+	}
+	if err := repo.NewShareRepo().Create(lgDB, &share); err != nil {
+		lgLogger.WithContext(c).Error("创建分享，落数据库失败，详情：", zap.Any("err", err.Error()))
+		web.InternalError(c, "内部异常")
+		return
+	}
+	web.Success(c, models.GenShareResp{
+		Code:         code,
+		Url:          fmt.Sprintf("/s/%s", code),
+		ExpireAt:     &expireAt,
+		LogSignature: base.GenShareLogSignature(code),
+	})
+}
+
+// ShareHandler    访问分享
+//
+//	@Summary      访问分享
+//	@Description  解析分享码，返回签名下载链接；指定uid时直接重定向到下载链接
+//	@Tags         分享
+//	@Accept       application/json
+//	@Param        code              path    string  true   "分享码"
+//	@Param        uid               query   string  false  "文件uid"
+//	@Param        X-Share-Password  header  string  false  "访问密码"
+//	@Produce      application/json
+//	@Success      200  {object}  web.Response{data=models.ShareResp}
+//	@Success      302
+//	@Router       /s/{code} [get]
+func ShareHandler(c *gin.Context) {
+	code := c.Param("code")
+	uidStr := c.Query("uid")
+	password := c.GetHeader("X-Share-Password")
+
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	share, err := repo.NewShareRepo().GetByCode(lgDB, code)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			web.NotFoundResource(c, "分享不存在")
+			return
+		}
+		lgLogger.WithContext(c).Error("访问分享，查询分享信息失败，详情：", zap.Any("err", err.Error()))
+		web.InternalError(c, "内部异常")
+		return
+	}
+
+	// 密码只从请求头读取，避免出现在访问日志中
+	// 每次访问都记录日志，包括失败的访问
+	accessLog := func(result string) {
+		now := time.Now()
+		userAgent := c.Request.UserAgent()
+		if len(userAgent) > 512 {
+			userAgent = userAgent[:512]
+		}
+		if err := repo.NewShareAccessLogRepo().Create(lgDB, &models.ShareAccessLog{
+			ShareCode: code,
+			UID:       uidStr,
+			IP:        c.ClientIP(),
+			UserAgent: userAgent,
+			Result:    result,
+			CreatedAt: &now,
+		}); err != nil {
+			lgLogger.WithContext(c).Error("访问分享，记录访问日志失败，详情：", zap.Any("err", err.Error()))
+		}
+	}
+
+	if share.ExpireAt != nil && share.ExpireAt.Before(time.Now()) {
+		accessLog("expired")
+		web.NotFoundResource(c, "分享已过期")
+		return
+	}
+	if share.PasswordHash != "" {
+		if base.SharePasswordLimited(code, c.ClientIP()) {
+			accessLog("passwordLimited")
+			web.TooManyRequests(c, "密码错误次数过多，请稍后再试")
+			return
+		}
+		if !base.CheckSharePassword(share.PasswordHash, password) {
+			base.SharePasswordFailed(code, c.ClientIP())
+			accessLog("passwordError")
+			web.UnAuthorization(c, "访问密码有误")
+			return
+		}
+	}
+	var uidList []int64
+	for _, i := range strings.Split(share.Uids, ",") {
+		if uidStr != "" && i != uidStr {
+			continue
+		}
+		uid, _ := strconv.ParseInt(i, 10, 64)
+		uidList = append(uidList, uid)
+	}
+	if len(uidList) == 0 {
+		accessLog("uidError")
+		web.ParamsError(c, "uid不在分享中")
+		return
+	}
+	if share.MaxDownload > 0 && share.DownloadCount >= share.MaxDownload {
+		accessLog("limitExceeded")
+		web.NotFoundResource(c, "分享访问次数已用完")
+		return
+	}
+
+	metaList, err := repo.NewMetaDataInfoRepo().GetByUidList(lgDB, uidList)
+	if err != nil {
+		lgLogger.WithContext(c).Error("访问分享，查询元数据信息失败")
+		web.InternalError(c, "内部异常")
+		return
+	}
+	// 下载链接有效期不超过分享剩余时间
+	expire := utils.ShareLinkExpire
+	if share.ExpireAt != nil {
+		if remain := int(time.Until(*share.ExpireAt).Seconds()); remain < expire {
+			expire = remain + 1
+		}
+	}
+	// 限制访问次数的分享，解析出的链接只能下载一次，避免绕过次数限制
+	maxDownload := 0
+	if share.MaxDownload > 0 {
+		maxDownload = 1
+	}
+	files, err := base.GenDownloadLinks(metaList, strconv.Itoa(expire), maxDownload)
+	if err != nil {
+		lgLogger.WithContext(c).Error("访问分享，生成下载链接失败，详情：", zap.Any("err", err.Error()))
+		web.InternalError(c, "内部异常")
+		return
+	}
+	// 链接生成成功后再扣减次数，内部异常不消耗次数；以数据库为准，条件更新保证并发下不会超发
+	if repo.NewShareRepo().IncrDownloadCount(lgDB, code) == 0 {
+		accessLog("limitExceeded")
+		web.NotFoundResource(c, "分享访问次数已用完")
+		return
+	}
+	accessLog("success")
+	if uidStr != "" && len(files) == 1 {
+		c.Redirect(http.StatusFound, files[0].Url)
+		return
+	}
+	web.Success(c, models.ShareResp{
+		Code:     code,
+		ExpireAt: share.ExpireAt,
+		Files:    files,
+	})
+}
+
+// ShareLogHandler    查询分享访问日志
+//
+//	@Summary      查询分享访问日志
+//	@Description  分享者使用创建分享时返回的签名，按分享码分页查询访问日志
+//	@Tags         分享
+//	@Accept       application/json
+//	@Param        code       query  string  true   "分享码"
+//	@Param        signature  query  string  true   "创建分享时返回的logSignature"
+//	@Param        page       query  int     false  "页码，默认1"
+//	@Param        size       query  int     false  "每页数量，默认20，最大200"
+//	@Produce      application/json
+//	@Success      200  {object}  web.Response{data=models.ShareLogResp}
+//	@Router       /api/storage/v0/share/log [get]
+func ShareLogHandler(c *gin.Context) {
+	code := c.Query("code")
+	signature := c.Query("signature")
+	if code == "" || signature == "" {
+		web.ParamsError(c, "code和signature不能为空")
+		return
+	}
+	if !base.CheckShareLogSignature(code, signature) {
+		web.UnAuthorization(c, "签名校验失败")
+		return
+	}
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		web.ParamsError(c, "page参数有误")
+		return
+	}
+	size, err := strconv.Atoi(c.DefaultQuery("size", "20"))
+	if err != nil || size < 1 || size > 200 {
+		web.ParamsError(c, "size参数有误")
+		return
+	}
+
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	if _, err := repo.NewShareRepo().GetByCode(lgDB, code); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			web.NotFoundResource(c, "分享不存在")
+			return
+		}
+		lgLogger.WithContext(c).Error("查询访问日志，查询分享信息失败，详情：", zap.Any("err", err.Error()))
+		web.InternalError(c, "内部异常")
+		return
+	}
+	list, total, err := repo.NewShareAccessLogRepo().PageByCode(lgDB, code, page, size)
+	if err != nil {
+		lgLogger.WithContext(c).Error("查询访问日志失败，详情：", zap.Any("err", err.Error()))
+		web.InternalError(c, "内部异常")
+		return
+	}
+	web.Success(c, models.ShareLogResp{Total: total, List: list})
+}
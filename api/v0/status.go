@@ -0,0 +1,223 @@
+package v0
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qinguoyi/osproxy/app/models"
+	"github.com/qinguoyi/osproxy/app/pkg/base"
+	"github.com/qinguoyi/osproxy/app/pkg/drain"
+	"github.com/qinguoyi/osproxy/app/pkg/repo"
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/app/pkg/web"
+	"github.com/qinguoyi/osproxy/bootstrap/plugins"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+/*
+上传状态：由元数据、分片及合并任务得出，合并完成且通过扫描后才是ready
+请求头Accept为text/event-stream时以SSE的方式推送，状态变化时推送，ready或failed后结束
+*/
+
+// UploadStatusHandler    查询上传状态
+//
+//	@Summary      查询上传状态
+//	@Description  查询上传、合并及扫描的状态，Accept为text/event-stream时推送状态变化
+//	@Tags         上传
+//	@Accept       application/json
+//	@Param        uid        query  string  true  "文件uid"
+//	@Param        date       query  string  true  "链接生成时间"
+//	@Param        expire     query  string  true  "过期时间"
+//	@Param        signature  query  string  true  "签名"
+//	@Produce      application/json,text/event-stream
+//	@Success      200  {object}  web.Response{data=models.UploadStatusResp}
+//	@Router       /api/storage/v0/status [get]
+func UploadStatusHandler(c *gin.Context) {
+	uid, ok := checkSessionSignature(c)
+	if !ok {
+		return
+	}
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	resp, err := uploadStatus(lgDB, uid)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			web.NotFoundResource(c, "uid不存在")
+			return
+		}
+		lgLogger.WithContext(c).Error("查询上传状态失败，详情：", zap.Any("err", err.Error()))
+		web.InternalError(c, "内部异常")
+		return
+	}
+	if c.GetHeader("Accept") != "text/event-stream" {
+		web.Success(c, resp)
+		return
+	}
+
+	// 推送到结束状态、连接超时、客户端断开或服务排空为止
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	deadline := time.Now().Add(utils.UploadStatusStreamTimeout)
+	last := *resp
+	sent := false
+	ticker := time.NewTicker(utils.UploadStatusPushInterval)
+	defer ticker.Stop()
+	c.Stream(func(w io.Writer) bool {
+		if !sent {
+			c.SSEvent("status", resp)
+			sent = true
+			return true
+		}
+		if last.Status == utils.UploadStatusReady || last.Status == utils.UploadStatusFailed {
+			return false
+		}
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-ticker.C:
+		}
+		if drain.Draining() || time.Now().After(deadline) {
+			return false
+		}
+		resp, err := uploadStatus(lgDB, uid)
+		if err != nil {
+			lgLogger.WithContext(c).Error("推送上传状态失败，详情：", zap.Any("err", err.Error()))
+			return false
+		}
+		if *resp != last {
+			c.SSEvent("status", resp)
+			last = *resp
+		}
+		return true
+	})
+}
+
+// uploadStatus 查询元数据、合并任务、上传会话及分片后得出上传状态
+func uploadStatus(db *gorm.DB, uid int64) (*models.UploadStatusResp, error) {
+	metaData, err := repo.NewMetaDataInfoRepo().GetByUid(db, uid)
+	if err != nil {
+		return nil, err
+	}
+	state := uploadState{meta: metaData, now: time.Now()}
+
+	// 已提交合并
+	if metaData.Status == 1 && metaData.MultiPart {
+		task, err := repo.NewTaskRepo().GetLatestByRef(db, utils.TaskPartMerge, uid)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if err == nil {
+			state.mergeTask = task
+			if task.TaskLogID != 0 {
+				if taskLog, err := repo.TaskLogRepo.GetByID(db, int64(task.TaskLogID)); err == nil {
+					state.mergeError = taskLog.ErrorInfo
+				}
+			}
+			state.progress = base.GetMergeProgress(uid)
+		}
+	}
+
+	// 上传中
+	if metaData.Status != 1 {
+		session, err := repo.NewUploadSessionRepo().GetByUid(db, uid)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if err == nil {
+			state.session = session
+		}
+		parts, err := repo.NewMultiPartInfoRepo().GetUploadedPartsByUid(db, uid)
+		if err != nil {
+			return nil, err
+		}
+		state.uploaded = len(parts)
+	}
+	return state.resp(), nil
+}
+
+// uploadState 得出上传状态需要的数据
+type uploadState struct {
+	meta       *models.MetaDataInfo
+	mergeTask  *models.TaskInfo      // 最近一个合并任务，没有时为nil
+	mergeError string                // 合并任务最近一次失败的原因
+	progress   int                   // 合并进度
+	session    *models.UploadSession // 上传会话，没有时为nil
+	uploaded   int                   // 已上传的分片数量
+	now        time.Time
+}
+
+// resp 已提交合并时以合并任务为准，否则以上传会话及已上传的分片为准
+// 分片总量来自上传会话、直传链接的分片数量或合并请求，都没有时不返回
+func (s uploadState) resp() *models.UploadStatusResp {
+	metaData := s.meta
+	resp := &models.UploadStatusResp{Uid: strconv.FormatInt(metaData.UID, 10), TotalChunks: metaData.PartNum}
+
+	// 上传及合并完成
+	if metaData.Status == 1 && !metaData.MultiPart {
+		resp.UploadedChunks = resp.TotalChunks
+		resp.Percent = 100
+		switch metaData.ScanStatus {
+		case utils.ScanStatusPending:
+			resp.Status = utils.UploadStatusScanning
+		case utils.ScanStatusQuarantined:
+			resp.Status = utils.UploadStatusFailed
+			resp.Reason = fmt.Sprintf("数据包含恶意内容：%s", metaData.ScanResult)
+		case utils.ScanStatusFailed:
+			resp.Status = utils.UploadStatusFailed
+			resp.Reason = fmt.Sprintf("数据扫描失败：%s", metaData.ScanResult)
+		default:
+			resp.Status = utils.UploadStatusReady
+		}
+		return resp
+	}
+
+	// 已提交合并
+	if metaData.Status == 1 {
+		resp.UploadedChunks = resp.TotalChunks
+		resp.Status = utils.UploadStatusMerging
+		task := s.mergeTask
+		if task == nil {
+			return resp
+		}
+		switch task.Status {
+		case utils.TaskStatusError:
+			resp.Status = utils.UploadStatusFailed
+		case utils.TaskStatusCancel:
+			resp.Status = utils.UploadStatusFailed
+			resp.Reason = "合并任务已取消"
+			return resp
+		case utils.TaskStatusFinish:
+			// 任务完成和元数据更新之间查询，以合并完成处理
+			resp.Percent = 100
+			return resp
+		default:
+			resp.Percent = s.progress
+		}
+		// 失败或等待重试时返回最近一次失败的原因
+		resp.Reason = s.mergeError
+		return resp
+	}
+
+	// 上传中
+	resp.UploadedChunks = s.uploaded
+	resp.Status = utils.UploadStatusPending
+	if s.uploaded != 0 {
+		resp.Status = utils.UploadStatusUploading
+	}
+	if session := s.session; session != nil {
+		resp.TotalChunks = session.ChunkSum
+		if session.Status == utils.SessionStatusAbort {
+			resp.Status = utils.UploadStatusFailed
+			resp.Reason = "上传会话已终止"
+		} else if session.Status == utils.SessionStatusUploading && session.ExpireAt != nil &&
+			session.ExpireAt.Before(s.now) {
+			resp.Status = utils.UploadStatusFailed
+			resp.Reason = "上传会话已过期"
+		}
+	}
+	return resp
+}
@@ -0,0 +1,109 @@
+package v0
+
+import (
+	"testing"
+	"time"
+
+	"github.com/qinguoyi/osproxy/app/models"
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+)
+
+func TestUploadStateResp(t *testing.T) {
+	now := time.Now()
+	expired := now.Add(-time.Minute)
+	cases := []struct {
+		name     string
+		state    uploadState
+		status   string
+		uploaded int
+		total    int
+		percent  int
+	}{
+		{
+			name:   "no parts without session",
+			state:  uploadState{meta: &models.MetaDataInfo{MultiPart: true}},
+			status: utils.UploadStatusPending,
+		},
+		{
+			name:     "parts without session",
+			state:    uploadState{meta: &models.MetaDataInfo{MultiPart: true}, uploaded: 3},
+			status:   utils.UploadStatusUploading,
+			uploaded: 3,
+		},
+		{
+			name: "session",
+			state: uploadState{meta: &models.MetaDataInfo{MultiPart: true}, uploaded: 3,
+				session: &models.UploadSession{ChunkSum: 10, Status: utils.SessionStatusUploading}},
+			status:   utils.UploadStatusUploading,
+			uploaded: 3,
+			total:    10,
+		},
+		{
+			name: "expired session",
+			state: uploadState{meta: &models.MetaDataInfo{MultiPart: true}, uploaded: 3, now: now,
+				session: &models.UploadSession{ChunkSum: 10, Status: utils.SessionStatusUploading, ExpireAt: &expired}},
+			status:   utils.UploadStatusFailed,
+			uploaded: 3,
+			total:    10,
+		},
+		{
+			name:     "direct multipart",
+			state:    uploadState{meta: &models.MetaDataInfo{PartNum: 4}},
+			status:   utils.UploadStatusPending,
+			total:    4,
+			uploaded: 0,
+		},
+		{
+			name:     "merge submitted",
+			state:    uploadState{meta: &models.MetaDataInfo{Status: 1, MultiPart: true, PartNum: 10}},
+			status:   utils.UploadStatusMerging,
+			uploaded: 10,
+			total:    10,
+		},
+		{
+			name: "merging",
+			state: uploadState{meta: &models.MetaDataInfo{Status: 1, MultiPart: true, PartNum: 10},
+				mergeTask: &models.TaskInfo{Status: utils.TaskStatusRunning}, progress: 40},
+			status:   utils.UploadStatusMerging,
+			uploaded: 10,
+			total:    10,
+			percent:  40,
+		},
+		{
+			name: "merge failed",
+			state: uploadState{meta: &models.MetaDataInfo{Status: 1, MultiPart: true, PartNum: 10},
+				mergeTask: &models.TaskInfo{Status: utils.TaskStatusError}, mergeError: "分片数量和整体数量不一致"},
+			status:   utils.UploadStatusFailed,
+			uploaded: 10,
+			total:    10,
+		},
+		{
+			name:    "scanning",
+			state:   uploadState{meta: &models.MetaDataInfo{Status: 1, ScanStatus: utils.ScanStatusPending}},
+			status:  utils.UploadStatusScanning,
+			percent: 100,
+		},
+		{
+			name:    "quarantined",
+			state:   uploadState{meta: &models.MetaDataInfo{Status: 1, ScanStatus: utils.ScanStatusQuarantined}},
+			status:  utils.UploadStatusFailed,
+			percent: 100,
+		},
+		{
+			name:     "ready",
+			state:    uploadState{meta: &models.MetaDataInfo{Status: 1, PartNum: 10, ScanStatus: utils.ScanStatusClean}},
+			status:   utils.UploadStatusReady,
+			uploaded: 10,
+			total:    10,
+			percent:  100,
+		},
+	}
+	for _, c := range cases {
+		resp := c.state.resp()
+		if resp.Status != c.status || resp.UploadedChunks != c.uploaded || resp.TotalChunks != c.total ||
+			resp.Percent != c.percent {
+			t.Errorf("%s: expected %s %d/%d %d%%, but got %s %d/%d %d%%", c.name, c.status, c.uploaded, c.total,
+				c.percent, resp.Status, resp.UploadedChunks, resp.TotalChunks, resp.Percent)
+		}
+	}
+}
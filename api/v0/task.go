@@ -0,0 +1,322 @@
+package v0
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qinguoyi/osproxy/app/models"
+	"github.com/qinguoyi/osproxy/app/pkg/event"
+	"github.com/qinguoyi/osproxy/app/pkg/repo"
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/app/pkg/web"
+	"github.com/qinguoyi/osproxy/bootstrap/plugins"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+/*
+任务管理：任务查询、死信任务、任务执行日志、重新执行、取消及队列情况，只接受本机或集群内请求
+合并状态查询面向客户端，使用上传链接的签名校验
+*/
+
+// TaskListHandler    查询任务
+//
+//	@Summary      查询任务
+//	@Description  按任务类型、状态、执行服务及创建日期分页查询任务
+//	@Tags         管理
+//	@Accept       application/json
+//	@Param        type    query  string  false  "任务类型"
+//	@Param        status  query  int     false  "任务状态 0 未执行 1 执行中 2 执行完成 3 已取消 99 执行失败"
+//	@Param        node    query  string  false  "执行服务"
+//	@Param        start   query  string  false  "创建日期起，格式2006-01-02"
+//	@Param        end     query  string  false  "创建日期止（含），格式2006-01-02"
+//	@Param        page    query  int     false  "页码，默认1"
+//	@Param        size    query  int     false  "每页数量，默认20，最大200"
+//	@Produce      application/json
+//	@Success      200  {object}  web.Response{data=models.TaskListResp}
+//	@Router       /api/storage/v0/admin/task/list [get]
+func TaskListHandler(c *gin.Context) {
+	filter := models.TaskFilter{
+		TaskType: c.Query("type"),
+		NodeId:   c.Query("node"),
+	}
+	if statusStr := c.Query("status"); statusStr != "" {
+		status, err := strconv.Atoi(statusStr)
+		if err != nil {
+			web.ParamsError(c, "status参数有误")
+			return
+		}
+		filter.Status = &status
+	}
+	if startStr := c.Query("start"); startStr != "" {
+		start, err := time.ParseInLocation("2006-01-02", startStr, time.Local)
+		if err != nil {
+			web.ParamsError(c, "start参数有误")
+			return
+		}
+		filter.Start = &start
+	}
+	if endStr := c.Query("end"); endStr != "" {
+		end, err := time.ParseInLocation("2006-01-02", endStr, time.Local)
+		if err != nil {
+			web.ParamsError(c, "end参数有误")
+			return
+		}
+		end = end.AddDate(0, 0, 1)
+		filter.End = &end
+	}
+	pageTask(c, filter)
+}
+
+// DeadTaskHandler    查询死信任务
+//
+//	@Summary      查询死信任务
+//	@Description  分页查询达到重试上限仍失败的任务
+//	@Tags         管理
+//	@Accept       application/json
+//	@Param        page  query  int  false  "页码，默认1"
+//	@Param        size  query  int  false  "每页数量，默认20，最大200"
+//	@Produce      application/json
+//	@Success      200  {object}  web.Response{data=models.TaskListResp}
+//	@Router       /api/storage/v0/admin/task/dead [get]
+func DeadTaskHandler(c *gin.Context) {
+	status := utils.TaskStatusError
+	pageTask(c, models.TaskFilter{Status: &status})
+}
+
+// pageTask 解析分页参数并查询任务
+func pageTask(c *gin.Context, filter models.TaskFilter) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		web.ParamsError(c, "page参数有误")
+		return
+	}
+	size, err := strconv.Atoi(c.DefaultQuery("size", "20"))
+	if err != nil || size < 1 || size > 200 {
+		web.ParamsError(c, "size参数有误")
+		return
+	}
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	list, total, err := repo.NewTaskRepo().Page(lgDB, filter, page, size)
+	if err != nil {
+		lgLogger.WithContext(c).Error("查询任务失败，详情：", zap.Any("err", err.Error()))
+		web.InternalError(c, "内部异常")
+		return
+	}
+	web.Success(c, models.TaskListResp{Total: total, List: list})
+}
+
+// TaskDetailHandler    查询任务
+//
+//	@Summary      查询任务
+//	@Description  查询任务及全部执行日志
+//	@Tags         管理
+//	@Accept       application/json
+//	@Param        id  query  string  true  "任务ID"
+//	@Produce      application/json
+//	@Success      200  {object}  web.Response{data=models.TaskDetailResp}
+//	@Router       /api/storage/v0/admin/task/detail [get]
+func TaskDetailHandler(c *gin.Context) {
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	task, ok := getTask(c, lgDB)
+	if !ok {
+		return
+	}
+	logs, err := repo.TaskLogRepo.ListByTaskID(lgDB, task.ID)
+	if err != nil {
+		lgLogger.WithContext(c).Error("查询任务执行日志失败，详情：", zap.Any("err", err.Error()))
+		web.InternalError(c, "内部异常")
+		return
+	}
+	web.Success(c, models.TaskDetailResp{Task: *task, Logs: logs})
+}
+
+// RequeueTaskHandler    重新执行任务
+//
+//	@Summary      重新执行任务
+//	@Description  失败或取消的任务重新执行，执行次数重新计算；等待重试的任务立即执行
+//	@Tags         管理
+//	@Accept       application/json
+//	@Param        id  query  string  true  "任务ID"
+//	@Produce      application/json
+//	@Success      200  {object}  web.Response
+//	@Router       /api/storage/v0/admin/task/requeue [put]
+func RequeueTaskHandler(c *gin.Context) {
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	task, ok := getTask(c, lgDB)
+	if !ok {
+		return
+	}
+	if repo.NewTaskRepo().RequeueTaskByID(lgDB, task.ID) == 0 {
+		web.ParamsError(c, "只有未执行、失败或取消的任务可以重新执行")
+		return
+	}
+	lgLogger.WithContext(c).Info("重新执行任务", zap.Int64("taskId", task.ID))
+	web.Success(c, "")
+}
+
+// CancelTaskHandler    取消任务
+//
+//	@Summary      取消任务
+//	@Description  取消未执行的任务，执行中的任务不能取消
+//	@Tags         管理
+//	@Accept       application/json
+//	@Param        id  query  string  true  "任务ID"
+//	@Produce      application/json
+//	@Success      200  {object}  web.Response
+//	@Router       /api/storage/v0/admin/task/cancel [put]
+func CancelTaskHandler(c *gin.Context) {
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	task, ok := getTask(c, lgDB)
+	if !ok {
+		return
+	}
+	if repo.NewTaskRepo().CancelTaskByID(lgDB, task.ID) == 0 {
+		web.ParamsError(c, "只有未执行的任务可以取消")
+		return
+	}
+	lgLogger.WithContext(c).Info("取消任务", zap.Int64("taskId", task.ID))
+	web.Success(c, "")
+}
+
+// TaskQueueHandler    查询任务队列
+//
+//	@Summary      查询任务队列
+//	@Description  按任务类型统计待执行、等待重试、执行中及死信任务数量，以及当前服务worker池的占用情况
+//	@Tags         管理
+//	@Accept       application/json
+//	@Produce      application/json
+//	@Success      200  {object}  web.Response{data=[]models.TaskQueueResp}
+//	@Router       /api/storage/v0/admin/task/queue [get]
+func TaskQueueHandler(c *gin.Context) {
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	counts, err := repo.NewTaskRepo().CountByStatus(lgDB)
+	if err != nil {
+		lgLogger.WithContext(c).Error("统计任务数量失败，详情：", zap.Any("err", err.Error()))
+		web.InternalError(c, "内部异常")
+		return
+	}
+	runnable, err := repo.NewTaskRepo().CountRunnable(lgDB)
+	if err != nil {
+		lgLogger.WithContext(c).Error("统计待执行任务数量失败，详情：", zap.Any("err", err.Error()))
+		web.InternalError(c, "内部异常")
+		return
+	}
+
+	// 已注册的任务类型在前，按优先级排列
+	queues := map[string]*models.TaskQueueResp{}
+	var resp []*models.TaskQueueResp
+	queueOf := func(t string) *models.TaskQueueResp {
+		if q, ok := queues[t]; ok {
+			return q
+		}
+		q := &models.TaskQueueResp{TaskType: t}
+		queues[t] = q
+		resp = append(resp, q)
+		return q
+	}
+	handlers := event.NewEventsHandler()
+	for _, t := range handlers.Types() {
+		handler := handlers.GetHandler(t)
+		q := queueOf(t)
+		q.Workers, q.Busy = handler.Workers(), handler.Busy()
+	}
+	for _, i := range runnable {
+		queueOf(i.TaskType).Runnable = i.Count
+	}
+	for _, i := range counts {
+		q := queueOf(i.TaskType)
+		switch i.Status {
+		case utils.TaskStatusUndo:
+			q.Delayed = i.Count - q.Runnable
+		case utils.TaskStatusRunning:
+			q.Running = i.Count
+		case utils.TaskStatusError:
+			q.Dead = i.Count
+		}
+	}
+	web.Success(c, resp)
+}
+
+// MergeStatusHandler    查询合并状态
+//
+//	@Summary      查询合并状态
+//	@Description  分片上传完成后轮询合并任务的状态
+//	@Tags         上传
+//	@Accept       application/json
+//	@Param        uid        query  string  true  "文件uid"
+//	@Param        date       query  string  true  "链接生成时间"
+//	@Param        expire     query  string  true  "过期时间"
+//	@Param        signature  query  string  true  "签名"
+//	@Produce      application/json
+//	@Success      200  {object}  web.Response{data=models.MergeStatusResp}
+//	@Router       /api/storage/v0/upload/merge [get]
+func MergeStatusHandler(c *gin.Context) {
+	uid, ok := checkSessionSignature(c)
+	if !ok {
+		return
+	}
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	resp := models.MergeStatusResp{Uid: strconv.FormatInt(uid, 10)}
+	task, err := repo.NewTaskRepo().GetLatestByRef(lgDB, utils.TaskPartMerge, uid)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			lgLogger.WithContext(c).Error("查询合并任务失败，详情：", zap.Any("err", err.Error()))
+			web.InternalError(c, "内部异常")
+			return
+		}
+		// 没有合并任务时以元数据为准，单文件上传及本地合并不会创建任务
+		metaData, err := repo.NewMetaDataInfoRepo().GetByUid(lgDB, uid)
+		if err != nil || metaData.MultiPart {
+			web.NotFoundResource(c, "合并任务不存在")
+			return
+		}
+		resp.Status = utils.MergeStatusFinished
+		web.Success(c, resp)
+		return
+	}
+
+	resp.TaskID = task.ID
+	resp.ExecuteTime = task.ExecuteTime
+	switch task.Status {
+	case utils.TaskStatusUndo:
+		resp.Status = utils.MergeStatusPending
+	case utils.TaskStatusRunning:
+		resp.Status = utils.MergeStatusRunning
+	case utils.TaskStatusFinish:
+		resp.Status = utils.MergeStatusFinished
+	case utils.TaskStatusCancel:
+		resp.Status = utils.MergeStatusCanceled
+	default:
+		resp.Status = utils.MergeStatusFailed
+	}
+	// 失败或等待重试时返回最近一次失败的原因
+	if task.Status == utils.TaskStatusError || (task.Status == utils.TaskStatusUndo && task.TaskLogID != 0) {
+		if taskLog, err := repo.TaskLogRepo.GetByID(lgDB, int64(task.TaskLogID)); err == nil {
+			resp.ErrorInfo = taskLog.ErrorInfo
+		}
+	}
+	web.Success(c, resp)
+}
+
+// getTask 按id查询任务，不存在时响应404
+func getTask(c *gin.Context, db *gorm.DB) (*models.TaskInfo, bool) {
+	id, err := strconv.ParseInt(c.Query("id"), 10, 64)
+	if err != nil {
+		web.ParamsError(c, "id参数有误")
+		return nil, false
+	}
+	task, err := repo.NewTaskRepo().GetByID(db, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			web.NotFoundResource(c, "任务不存在")
+			return nil, false
+		}
+		lgLogger.WithContext(c).Error("查询任务失败，详情：", zap.Any("err", err.Error()))
+		web.InternalError(c, "内部异常")
+		return nil, false
+	}
+	return task, true
+}
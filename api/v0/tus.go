@@ -0,0 +1,479 @@
+package v0
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qinguoyi/osproxy/app/models"
+	"github.com/qinguoyi/osproxy/app/pkg/base"
+	"github.com/qinguoyi/osproxy/app/pkg/repo"
+	"github.com/qinguoyi/osproxy/app/pkg/staging"
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/bootstrap/plugins"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+/*
+tus 1.0 断点续传协议，支持creation、termination、checksum扩展
+上传链接复用/link/upload生成的uid及签名，每次PATCH的数据保存为一个分片，上传完成后走合并任务
+*/
+
+// StatusChecksumMismatch tus checksum扩展定义的校验失败状态码
+const StatusChecksumMismatch = 460
+
+// TusOptionsHandler    tus协议能力查询
+//
+//	@Summary      tus协议能力查询
+//	@Description  返回支持的tus版本及扩展
+//	@Tags         tus
+//	@Success      204
+//	@Router       /api/storage/v0/tus [options]
+func TusOptionsHandler(c *gin.Context) {
+	c.Header("Tus-Version", utils.TusResumable)
+	c.Header("Tus-Extension", utils.TusExtension)
+	c.Header("Tus-Checksum-Algorithm", utils.TusChecksumAlgorithm)
+	c.Status(http.StatusNoContent)
+}
+
+// TusCreateHandler    tus创建上传
+//
+//	@Summary      tus创建上传
+//	@Description  使用上传链接的uid及签名创建tus上传，返回的Location用于后续HEAD、PATCH、DELETE
+//	@Tags         tus
+//	@Param        uid              query   string  true   "文件uid"
+//	@Param        date             query   string  true   "链接生成时间"
+//	@Param        expire           query   string  true   "过期时间"
+//	@Param        signature        query   string  true   "签名"
+//	@Param        Upload-Length    header  string  true   "文件总大小"
+//	@Param        Upload-Metadata  header  string  false  "文件元数据"
+//	@Success      201
+//	@Router       /api/storage/v0/tus [post]
+func TusCreateHandler(c *gin.Context) {
+	uidStr := c.Query("uid")
+	uid, ok := checkTusSignature(c, uidStr)
+	if !ok {
+		return
+	}
+	if c.GetHeader("Upload-Defer-Length") != "" {
+		c.String(http.StatusBadRequest, "不支持Upload-Defer-Length")
+		return
+	}
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		c.String(http.StatusBadRequest, "Upload-Length参数有误")
+		return
+	}
+	uploadMeta, err := base.ParseUploadMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	metaData, err := repo.NewMetaDataInfoRepo().GetByUid(lgDB, uid)
+	if err != nil {
+		c.String(http.StatusNotFound, "当前上传链接无效，uid不存在")
+		return
+	}
+	if metaData.Status == 1 {
+		c.String(http.StatusConflict, "文件已上传")
+		return
+	}
+	if forwardToOwner(c, metaData) {
+		return
+	}
+	parts, err := repo.NewMultiPartInfoRepo().GetUploadedPartsByUid(lgDB, uid)
+	if err != nil {
+		lgLogger.WithContext(c).Error("tus创建上传，查询分片信息失败")
+		c.String(http.StatusInternalServerError, "内部异常")
+		return
+	}
+	if len(parts) != 0 {
+		c.String(http.StatusConflict, "上传已开始，不能重复创建")
+		return
+	}
+
+	now := time.Now()
+	columns := map[string]interface{}{
+		"storage_size": length,
+		"multi_part":   true,
+		"updated_at":   &now,
+	}
+	if fileType, ok := uploadMeta["filetype"]; ok && fileType != "" {
+		columns["content_type"] = fileType
+	}
+	if err := repo.NewMetaDataInfoRepo().Updates(lgDB, uid, columns); err != nil {
+		lgLogger.WithContext(c).Error("tus创建上传，更新元数据失败", zap.Any("err", err.Error()))
+		c.String(http.StatusInternalServerError, "内部异常")
+		return
+	}
+	c.Header("Location", fmt.Sprintf("/api/storage/v0/tus/%s?%s", uidStr, c.Request.URL.RawQuery))
+	c.Status(http.StatusCreated)
+}
+
+// TusHeadHandler    tus查询上传进度
+//
+//	@Summary      tus查询上传进度
+//	@Description  返回当前已上传的偏移量
+//	@Tags         tus
+//	@Param        uid        path   string  true  "文件uid"
+//	@Param        date       query  string  true  "链接生成时间"
+//	@Param        expire     query  string  true  "过期时间"
+//	@Param        signature  query  string  true  "签名"
+//	@Success      200
+//	@Router       /api/storage/v0/tus/{uid} [head]
+func TusHeadHandler(c *gin.Context) {
+	uidStr := c.Param("uid")
+	uid, ok := checkTusSignature(c, uidStr)
+	if !ok {
+		return
+	}
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	metaData, ok := getTusMeta(c, lgDB, uid)
+	if !ok {
+		return
+	}
+	if metaData.Status != 1 && forwardToOwner(c, metaData) {
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Length", strconv.FormatInt(metaData.StorageSize, 10))
+	if metaData.Status == 1 {
+		c.Header("Upload-Offset", strconv.FormatInt(metaData.StorageSize, 10))
+		c.Status(http.StatusOK)
+		return
+	}
+	parts, err := repo.NewMultiPartInfoRepo().GetUploadedPartsByUid(lgDB, uid)
+	if err != nil {
+		lgLogger.WithContext(c).Error("tus查询上传进度，查询分片信息失败")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(sumPartSize(parts), 10))
+	c.Status(http.StatusOK)
+}
+
+// TusPatchHandler    tus上传数据
+//
+//	@Summary      tus上传数据
+//	@Description  从Upload-Offset处追加数据，数据上传完成后创建合并任务
+//	@Tags         tus
+//	@Accept       application/offset+octet-stream
+//	@Param        uid              path    string  true   "文件uid"
+//	@Param        date             query   string  true   "链接生成时间"
+//	@Param        expire           query   string  true   "过期时间"
+//	@Param        signature        query   string  true   "签名"
+//	@Param        Upload-Offset    header  string  true   "当前偏移量"
+//	@Param        Upload-Checksum  header  string  false  "数据校验和"
+//	@Success      204
+//	@Router       /api/storage/v0/tus/{uid} [patch]
+func TusPatchHandler(c *gin.Context) {
+	uidStr := c.Param("uid")
+	uid, ok := checkTusSignature(c, uidStr)
+	if !ok {
+		return
+	}
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.String(http.StatusUnsupportedMediaType, "Content-Type必须为application/offset+octet-stream")
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.String(http.StatusBadRequest, "Upload-Offset参数有误")
+		return
+	}
+	var checksum hash.Hash
+	var expectSum []byte
+	if header := c.GetHeader("Upload-Checksum"); header != "" {
+		checksum, expectSum, err = base.ParseUploadChecksum(header)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	metaData, ok := getTusMeta(c, lgDB, uid)
+	if !ok {
+		return
+	}
+	if metaData.Status == 1 {
+		c.Header("Upload-Offset", strconv.FormatInt(metaData.StorageSize, 10))
+		c.String(http.StatusConflict, "文件已上传完成")
+		return
+	}
+	if forwardToOwner(c, metaData) {
+		return
+	}
+
+	// 同一个上传同时只允许一个PATCH
+	lgRedis := new(plugins.LangGoRedis).NewRedis()
+	ctx := c.Request.Context()
+	lock := base.NewRedisLock(&ctx, lgRedis, fmt.Sprintf("tus-%d", uid))
+	if flag, err := lock.Acquire(); err != nil || !flag {
+		c.String(http.StatusLocked, "当前上传正在写入")
+		return
+	}
+	defer func() {
+		_, _ = lock.Release()
+	}()
+
+	parts, err := repo.NewMultiPartInfoRepo().GetUploadedPartsByUid(lgDB, uid)
+	if err != nil {
+		lgLogger.WithContext(c).Error("tus上传数据，查询分片信息失败")
+		c.String(http.StatusInternalServerError, "内部异常")
+		return
+	}
+	current := sumPartSize(parts)
+	if offset != current {
+		c.Header("Upload-Offset", strconv.FormatInt(current, 10))
+		c.String(http.StatusConflict, fmt.Sprintf("Upload-Offset不一致，当前偏移量:%d", current))
+		return
+	}
+
+	// 每次PATCH的数据保存为一个分片
+	chunkNum := len(parts) + 1
+	partName := staging.NewStaging().PartName(uid, chunkNum)
+	dirName, err := staging.NewStaging().Dir(uid)
+	if err != nil {
+		lgLogger.WithContext(c).Error("创建本地目录失败")
+		c.String(http.StatusInternalServerError, "创建本地目录失败")
+		return
+	}
+	partFileName := fmt.Sprintf("%d_%d", uid, chunkNum)
+	fileName := path.Join(dirName, partFileName)
+	out, err := os.Create(fileName)
+	if err != nil {
+		lgLogger.WithContext(c).Error("本地创建文件失败")
+		c.String(http.StatusInternalServerError, "本地创建文件失败")
+		return
+	}
+	md5Hash := md5.New()
+	writers := []io.Writer{out, md5Hash}
+	if checksum != nil {
+		writers = append(writers, checksum)
+	}
+	remaining := metaData.StorageSize - current
+	written, copyErr := io.Copy(io.MultiWriter(writers...), io.LimitReader(c.Request.Body, remaining+1))
+	_ = out.Close()
+	if written > remaining {
+		_ = os.Remove(fileName)
+		c.String(http.StatusRequestEntityTooLarge, "数据超出Upload-Length")
+		return
+	}
+	if checksum != nil && (copyErr != nil || !bytes.Equal(checksum.Sum(nil), expectSum)) {
+		_ = os.Remove(fileName)
+		c.String(StatusChecksumMismatch, "Checksum Mismatch")
+		return
+	}
+	// 连接中断时保留已收到的数据，客户端可从新的偏移量继续上传
+	if written == 0 {
+		_ = os.Remove(fileName)
+		c.Header("Upload-Offset", strconv.FormatInt(current, 10))
+		c.Status(http.StatusNoContent)
+		return
+	}
+	if copyErr != nil {
+		lgLogger.WithContext(c).Warn("tus上传数据，请求体读取中断", zap.Any("err", copyErr.Error()))
+	}
+
+	if err := staging.NewStaging().PutPart(metaData.Bucket, partName, fileName); err != nil {
+		_ = os.Remove(fileName)
+		lgLogger.WithContext(c).Error("上传到minio失败")
+		c.String(http.StatusInternalServerError, "上传到minio失败")
+		return
+	}
+	now := time.Now()
+	if err := repo.NewMultiPartInfoRepo().Create(lgDB, &models.MultiPartInfo{
+		StorageUid:   uid,
+		ChunkNum:     chunkNum,
+		Bucket:       metaData.Bucket,
+		StorageName:  partName,
+		StorageSize:  written,
+		PartFileName: partFileName,
+		PartMd5:      hex.EncodeToString(md5Hash.Sum(nil)),
+		Status:       1,
+		CreatedAt:    &now,
+		UpdatedAt:    &now,
+	}); err != nil {
+		_ = os.Remove(fileName)
+		lgLogger.WithContext(c).Error("上传完更新数据失败")
+		c.String(http.StatusInternalServerError, "上传完更新数据失败")
+		return
+	}
+
+	newOffset := current + written
+	if newOffset == metaData.StorageSize {
+		if err := finishTusUpload(metaData, chunkNum); err != nil {
+			lgLogger.WithContext(c).Error("tus上传完成，创建合并任务失败", zap.Any("err", err.Error()))
+			c.String(http.StatusInternalServerError, "创建合并任务失败")
+			return
+		}
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	c.Status(http.StatusNoContent)
+}
+
+// TusDeleteHandler    tus终止上传
+//
+//	@Summary      tus终止上传
+//	@Description  终止未完成的上传，清理已上传的分片
+//	@Tags         tus
+//	@Param        uid        path   string  true  "文件uid"
+//	@Param        date       query  string  true  "链接生成时间"
+//	@Param        expire     query  string  true  "过期时间"
+//	@Param        signature  query  string  true  "签名"
+//	@Success      204
+//	@Router       /api/storage/v0/tus/{uid} [delete]
+func TusDeleteHandler(c *gin.Context) {
+	uidStr := c.Param("uid")
+	uid, ok := checkTusSignature(c, uidStr)
+	if !ok {
+		return
+	}
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	metaData, ok := getTusMeta(c, lgDB, uid)
+	if !ok {
+		return
+	}
+	if metaData.Status == 1 {
+		c.String(http.StatusConflict, "文件已上传完成，不能终止")
+		return
+	}
+	if forwardToOwner(c, metaData) {
+		return
+	}
+
+	if err := staging.NewStaging().Remove(uid); err != nil {
+		lgLogger.WithContext(c).Error(fmt.Sprintf("删除目录失败，详情%s", err.Error()))
+		c.String(http.StatusInternalServerError, "删除目录失败")
+		return
+	}
+	now := time.Now()
+	if err := repo.NewMetaDataInfoRepo().UpdatesWithEvent(lgDB, uid, map[string]interface{}{
+		"storage_size": 0,
+		"multi_part":   false,
+		"updated_at":   &now,
+	}, utils.EventObjectDeleted); err != nil {
+		lgLogger.WithContext(c).Error("tus终止上传，更新元数据失败", zap.Any("err", err.Error()))
+		c.String(http.StatusInternalServerError, "内部异常")
+		return
+	}
+	// 对象存储中的分片交给删除任务清理
+	b, err := json.Marshal(models.MergeInfo{StorageUid: uid})
+	if err != nil {
+		lgLogger.WithContext(c).Error("消息struct转成json字符串失败", zap.Any("err", err.Error()))
+		c.String(http.StatusInternalServerError, "创建删除任务失败")
+		return
+	}
+	if err := repo.NewTaskRepo().Create(lgDB, &models.TaskInfo{
+		Status:    utils.TaskStatusUndo,
+		TaskType:  utils.TaskPartDelete,
+		ExtraData: string(b),
+		RefId:     uid,
+	}); err != nil {
+		lgLogger.WithContext(c).Error("tus终止上传，创建删除任务失败", zap.Any("err", err.Error()))
+		c.String(http.StatusInternalServerError, "创建删除任务失败")
+		return
+	}
+	publishObject(c, lgDB, utils.EventObjectDeleted, uid)
+	c.Status(http.StatusNoContent)
+}
+
+// checkTusSignature 校验上传链接的签名，失败时直接响应
+func checkTusSignature(c *gin.Context, uidStr string) (int64, bool) {
+	date := c.Query("date")
+	expireStr := c.Query("expire")
+	uid, err, errorInfo := base.CheckValid(uidStr, date, expireStr)
+	if err != nil {
+		c.String(http.StatusBadRequest, errorInfo)
+		return 0, false
+	}
+	if !base.CheckUploadSignature(date, expireStr, c.Query("signature")) {
+		c.String(http.StatusForbidden, "签名校验失败")
+		return 0, false
+	}
+	return uid, true
+}
+
+// getTusMeta 查询通过tus创建的上传，未创建时响应404
+func getTusMeta(c *gin.Context, db *gorm.DB, uid int64) (*models.MetaDataInfo, bool) {
+	metaData, err := repo.NewMetaDataInfoRepo().GetByUid(db, uid)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.Status(http.StatusNotFound)
+			return nil, false
+		}
+		lgLogger.WithContext(c).Error("tus查询元数据信息失败")
+		c.Status(http.StatusInternalServerError)
+		return nil, false
+	}
+	if !metaData.MultiPart || metaData.StorageSize <= 0 {
+		c.Status(http.StatusNotFound)
+		return nil, false
+	}
+	return metaData, true
+}
+
+// finishTusUpload 数据上传完成，更新元数据并创建合并任务，md5在合并时计算
+func finishTusUpload(metaData *models.MetaDataInfo, chunkSum int) error {
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	now := time.Now()
+	columns := map[string]interface{}{
+		"part_num":   chunkSum,
+		"md5":        "",
+		"status":     1,
+		"updated_at": &now,
+	}
+	if metaData.ContentType == "" {
+		firstPart, err := repo.NewMultiPartInfoRepo().GetUploadedPartByChunkNum(lgDB, metaData.UID, 1)
+		if err != nil {
+			return err
+		}
+		if len(firstPart) == 0 {
+			return errors.New("首个分片不存在")
+		}
+		contentType, err := detectPartContentType(firstPart[0])
+		if err != nil {
+			return err
+		}
+		columns["content_type"] = contentType
+	}
+	if err := repo.NewMetaDataInfoRepo().Updates(lgDB, metaData.UID, markScanPending(columns)); err != nil {
+		return err
+	}
+	b, err := json.Marshal(models.MergeInfo{
+		StorageUid: metaData.UID,
+		ChunkSum:   int64(chunkSum),
+	})
+	if err != nil {
+		return err
+	}
+	return repo.NewTaskRepo().Create(lgDB, &models.TaskInfo{
+		Status:    utils.TaskStatusUndo,
+		TaskType:  utils.TaskPartMerge,
+		ExtraData: string(b),
+		RefId:     metaData.UID,
+	})
+}
+
+func sumPartSize(parts []models.MultiPartInfo) int64 {
+	var size int64
+	for _, part := range parts {
+		size += part.StorageSize
+	}
+	return size
+}
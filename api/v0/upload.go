@@ -3,25 +3,27 @@ package v0
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path"
 	"strconv"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/qinguoyi/osproxy/app/models"
 	"github.com/qinguoyi/osproxy/app/pkg/base"
 	"github.com/qinguoyi/osproxy/app/pkg/repo"
+	"github.com/qinguoyi/osproxy/app/pkg/staging"
 	"github.com/qinguoyi/osproxy/app/pkg/storage"
-	"github.com/qinguoyi/osproxy/app/pkg/thirdparty"
 	"github.com/qinguoyi/osproxy/app/pkg/utils"
 	"github.com/qinguoyi/osproxy/app/pkg/web"
+	"github.com/qinguoyi/osproxy/app/pkg/webhook"
 	"github.com/qinguoyi/osproxy/bootstrap"
 	"github.com/qinguoyi/osproxy/bootstrap/plugins"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 /*
@@ -44,6 +46,29 @@ import (
 //	@Success      200  {object}  web.Response
 //	@Router       /api/storage/v0/upload [put]
 func UploadSingleHandler(c *gin.Context) {
+	uploadSingle(c, false)
+}
+
+// UploadSingleRawHandler    以请求体上传单个文件
+//
+//	@Summary      以请求体上传单个文件
+//	@Description  请求体即文件内容，无需multipart/form-data，必须携带Content-Length
+//	@Tags         上传
+//	@Accept       application/octet-stream
+//	@Param        uid        query  string  true  "文件uid"
+//	@Param        md5        query  string  true  "md5"
+//	@Param        date       query  string  true  "链接生成时间"
+//	@Param        expire     query  string  true  "过期时间"
+//	@Param        signature  query  string  true  "签名"
+//	@Produce      application/json
+//	@Success      200  {object}  web.Response
+//	@Router       /api/storage/v0/upload/raw [put]
+func UploadSingleRawHandler(c *gin.Context) {
+	uploadSingle(c, true)
+}
+
+// uploadSingle 上传单个文件，raw为true时直接读取请求体
+func uploadSingle(c *gin.Context, raw bool) {
 	uidStr := c.Query("uid")
 	md5 := c.Query("md5")
 	date := c.Query("date")
@@ -61,13 +86,6 @@ func UploadSingleHandler(c *gin.Context) {
 		return
 	}
 
-	file, err := c.FormFile("file") // FormFile()函数用于获取上传的文件
-	// 具体点说，FormFile()函数用于获取表单数据项，表单数据项是指表单中的一个数据项，比如<input type="file" name="file" />，这里的name就是表单数据项
-	if err != nil {
-		web.ParamsError(c, fmt.Sprintf("解析文件参数失败，详情：%s", err))
-		return
-	}
-
 	// 判断记录是否存在
 	// 为什么上传文件的时候
 	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
@@ -77,7 +95,6 @@ func UploadSingleHandler(c *gin.Context) {
 		return
 	}
 
-	dirName := path.Join(utils.LocalStore, uidStr)
 	// 判断是否上传过，md5
 	resumeInfo, err := repo.NewMetaDataInfoRepo().GetResumeByMd5(lgDB, []string{md5})
 	// GetResumeByMd5()函数用于根据md5获取秒传数据，返回值是一个切片，切片的元素是MetaDataInfo类型
@@ -88,7 +105,7 @@ func UploadSingleHandler(c *gin.Context) {
 	}
 	if len(resumeInfo) != 0 {
 		now := time.Now()
-		if err := repo.NewMetaDataInfoRepo().Updates(lgDB, uid, map[string]interface{}{
+		if err := repo.NewMetaDataInfoRepo().UpdatesWithEvent(lgDB, uid, map[string]interface{}{
 			// Updates()函数用于更新元数据信息，元数据信息是指文件的元数据信息，比如文件的md5、文件的大小、文件的类型等
 			"bucket":       resumeInfo[0].Bucket,
 			"storage_name": resumeInfo[0].StorageName,
@@ -99,7 +116,10 @@ func UploadSingleHandler(c *gin.Context) {
 			"status":       1,
 			"updated_at":   &now,
 			"content_type": resumeInfo[0].ContentType,
-		}); err != nil {
+			"scan_status":  resumeInfo[0].ScanStatus,
+			"scan_result":  resumeInfo[0].ScanResult,
+			"scanned_at":   resumeInfo[0].ScannedAt,
+		}, utils.EventObjectUploaded); err != nil {
 			lgLogger.WithContext(c).Error("上传完更新数据失败")
 			web.InternalError(c, "上传完更新数据失败")
 			return
@@ -108,7 +128,7 @@ func UploadSingleHandler(c *gin.Context) {
 		// 上传文件的过程是：1.创建一个目录；2.将文件存储到目录中；3.将目录中的文件上传到minio中；4.删除目录
 		// 创建目录是指在本地创建一个目录，将文件存储到目录中，这里的目录是uidStr
 		// minio 是一个对象存储服务器，它的作用是存储对象，对象是指文件，比如图片、视频、音频等
-		if err := os.RemoveAll(dirName); err != nil {
+		if err := staging.NewStaging().Remove(uid); err != nil {
 			lgLogger.WithContext(c).Error(fmt.Sprintf("删除目录失败，详情%s", err.Error()))
 			web.InternalError(c, fmt.Sprintf("删除目录失败，详情%s", err.Error()))
 			return
@@ -131,6 +151,7 @@ func UploadSingleHandler(c *gin.Context) {
 		// SetNX()函数用于向redis中写入数据 SetNX()函数的第一个参数是上下文，第二个参数是key，第三个参数是value，第四个参数是过期时间
 		// context.Background()函数用于创建一个上下文，上下文是gin的上下文，它包含了请求和响应的信息，比如请求头、请求体、响应头、响应体等
 
+		publishObject(c, lgDB, utils.EventObjectUploaded, uid)
 		web.Success(c, "")
 		return
 	}
@@ -141,80 +162,39 @@ func UploadSingleHandler(c *gin.Context) {
 	// 判断是否在本地，什么叫本地呢？本地是指本地服务器，本地服务器是指部署了osproxy的服务器
 	// 分布式系统是指多台服务器组成的系统，分布式系统的特点是：1.多台服务器之间是对等的，没有主从之分；2.多台服务器之间是通过网络进行通信的
 	// 在这个项目中，分布式系统是指多台部署了osproxy的服务器组成的系统，这些服务器之间是对等的，没有主从之分，这些服务器之间是通过网络进行通信的
-	if _, err := os.Stat(dirName); os.IsNotExist(err) { // Stat()函数用于获取文件信息，IsNotExist()函数用于判断文件是否存在
-		// 不在本地，询问集群内其他服务并转发
-		serviceList, err := base.NewServiceRegister().Discovery() // Discovery()函数用于发现其他服务
-		if err != nil || serviceList == nil {
-			lgLogger.WithContext(c).Error("发现其他服务失败")
-			web.InternalError(c, "发现其他服务失败")
-			return
-		}
-		var wg sync.WaitGroup
-		var ipList []string //
-		ipChan := make(chan string, len(serviceList))
-		for _, service := range serviceList {
-			wg.Add(1)
-			go func(ip string, port string, ipChan chan string, wg *sync.WaitGroup) {
-				// 这里的匿名函数的任务是：
-				// 1.调用thirdparty.NewStorageService().Locate()函数，这个函数的作用是定位其他服务；2.将定位的结果写入ipChan中；
-				// 3.将wg的值减1
-				defer wg.Done()
-				res, err := thirdparty.NewStorageService().Locate(utils.Scheme, ip, port, uidStr) // Locate()函数用于定位其他服务
-				if err != nil {
-					fmt.Print(err.Error())
-					return
-				}
-				ipChan <- res
-			}(service.IP, service.Port, ipChan, &wg)
-		}
-		wg.Wait()
-		close(ipChan)
-		for re := range ipChan {
-			ipList = append(ipList, re)
-		}
-		if len(ipList) == 0 {
-			lgLogger.WithContext(c).Error("发现其他服务失败")
-			web.InternalError(c, "发现其他服务失败")
-			return
-		}
-		proxyIP := ipList[0] // 为什么是ipList[0]作为代理ip呢？
-		// 找到其他服务器上的后,转发
-		_, _, _, err = thirdparty.NewStorageService().UploadForward(c, utils.Scheme, proxyIP,
-			bootstrap.NewConfig("").App.Port, uidStr, true) // UploadForward()函数用于上传文件，这里的上传是指将文件上传到云端
-		if err != nil {
-			lgLogger.WithContext(c).Error("上传单文件，转发失败")
-			web.InternalError(c, err.Error())
-			return
-		}
-		web.Success(c, "")
+	// 共享暂存时任意服务都可以处理，不在本地时优先一跳转发到上传目录所在服务，请求体以流的方式透传
+	if forwardToOwner(c, metaData) {
 		return
 	}
-	// 在本地
-	fileName := path.Join(utils.LocalStore, uidStr, metaData.StorageName)
-	out, err := os.Create(fileName)
+	src, size, err := openUploadBody(c, raw) // 表单上传时FormFile()函数用于获取上传的文件
+	// 具体点说，FormFile()函数用于获取表单数据项，表单数据项是指表单中的一个数据项，比如<input type="file" name="file" />，这里的name就是表单数据项
 	if err != nil {
-		lgLogger.WithContext(c).Error("本地创建文件失败")
-		web.InternalError(c, "本地创建文件失败")
+		web.ParamsError(c, fmt.Sprintf("解析文件参数失败，详情：%s", err))
 		return
 	}
-	src, err := file.Open()
+	defer func() {
+		_ = src.Close()
+	}()
+
+	// 在本地，写入文件的同时计算md5
+	dirName, err := staging.NewStaging().Dir(uid)
 	if err != nil {
-		lgLogger.WithContext(c).Error("打开本地文件失败")
-		web.InternalError(c, "打开本地文件失败")
+		lgLogger.WithContext(c).Error("创建本地目录失败")
+		web.InternalError(c, "创建本地目录失败")
 		return
 	}
-	if _, err = io.Copy(out, src); err != nil {
+	fileName := path.Join(dirName, metaData.StorageName)
+	written, md5Str, err := base.SaveFileWithMd5(fileName, src)
+	if err != nil {
 		lgLogger.WithContext(c).Error("请求数据存储到文件失败")
 		web.InternalError(c, "请求数据存储到文件失败")
 		return
 	}
-	// 校验md5
-	md5Str, err := base.CalculateFileMd5(fileName)
-	if err != nil {
-		lgLogger.WithContext(c).Error(fmt.Sprintf("生成md5失败，详情%s", err.Error()))
-		web.InternalError(c, err.Error())
+	if written != size {
+		web.ParamsError(c, fmt.Sprintf("文件大小不一致，实际:%d, 参数:%d", written, size))
 		return
 	}
+	// 校验md5
 	if md5Str != md5 {
 		web.ParamsError(c, fmt.Sprintf("校验md5失败，计算结果:%s, 参数:%s", md5Str, md5))
 		return
@@ -233,22 +213,19 @@ func UploadSingleHandler(c *gin.Context) {
 	}
 	// 更新元数据，元数据存储在数据库中
 	now := time.Now()
-	fileInfo, _ := os.Stat(fileName)
-	if err := repo.NewMetaDataInfoRepo().Updates(lgDB, metaData.UID, map[string]interface{}{
+	if err := repo.NewMetaDataInfoRepo().UpdatesWithEvent(lgDB, metaData.UID, map[string]interface{}{
 		"md5":          md5Str,
-		"storage_size": fileInfo.Size(),
+		"storage_size": written,
 		"multi_part":   false,
 		"status":       1,
 		"updated_at":   &now,
 		"content_type": contentType,
-	}); err != nil {
+	}, utils.EventObjectUploaded); err != nil {
 		lgLogger.WithContext(c).Error("上传完更新数据失败")
 		web.InternalError(c, "上传完更新数据失败")
 		return
 	}
-	_, _ = out.Close(), src.Close()
-
-	if err := os.RemoveAll(dirName); err != nil {
+	if err := staging.NewStaging().Remove(uid); err != nil {
 		lgLogger.WithContext(c).Error(fmt.Sprintf("删除目录失败，详情%s", err.Error()))
 		web.InternalError(c, fmt.Sprintf("删除目录失败，详情%s", err.Error()))
 		return
@@ -270,6 +247,7 @@ func UploadSingleHandler(c *gin.Context) {
 	// setNX是否自带锁？
 	// SetNX是原子操作的，这里的SetNX是指向redis中写入数据，如果写入成功，那么就返回true，否则返回false
 
+	publishObject(c, lgDB, utils.EventObjectUploaded, uid)
 	web.Success(c, "")
 	return
 }
@@ -291,6 +269,30 @@ func UploadSingleHandler(c *gin.Context) {
 //	@Success      200  {object}  web.Response
 //	@Router       /api/storage/v0/upload/multi [put]
 func UploadMultiPartHandler(c *gin.Context) {
+	uploadMultiPart(c, false)
+}
+
+// UploadMultiPartRawHandler    以请求体上传分片文件
+//
+//	@Summary      以请求体上传分片文件
+//	@Description  请求体即分片内容，无需multipart/form-data，必须携带Content-Length
+//	@Tags         上传
+//	@Accept       application/octet-stream
+//	@Param        uid        query  string  true  "文件uid"
+//	@Param        md5        query  string  true  "md5"
+//	@Param        chunkNum   query  string  true  "当前分片id"
+//	@Param        date       query  string  true  "链接生成时间"
+//	@Param        expire     query  string  true  "过期时间"
+//	@Param        signature  query  string  true  "签名"
+//	@Produce      application/json
+//	@Success      200  {object}  web.Response
+//	@Router       /api/storage/v0/upload/multi/raw [put]
+func UploadMultiPartRawHandler(c *gin.Context) {
+	uploadMultiPart(c, true)
+}
+
+// uploadMultiPart 上传分片文件，raw为true时直接读取请求体
+func uploadMultiPart(c *gin.Context, raw bool) {
 	// 整个这个处理函数只在分片上传的时候调用，调用的时候，用了goroutine。分片上传的时候，涉及锁的操作是redis，在这里不再需要调用goroutine
 	// 相当于一个请求，一个goroutine，一个goroutine，一个请求
 	uidStr := c.Query("uid")
@@ -317,12 +319,6 @@ func UploadMultiPartHandler(c *gin.Context) {
 		return
 	}
 
-	file, err := c.FormFile("file")
-	if err != nil {
-		web.ParamsError(c, fmt.Sprintf("解析文件参数失败，详情：%s", err))
-		return
-	}
-
 	// 判断记录是否存在
 	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
 	metaData, err := repo.NewMetaDataInfoRepo().GetByUid(lgDB, uid)
@@ -330,16 +326,42 @@ func UploadMultiPartHandler(c *gin.Context) {
 		web.NotFoundResource(c, "当前上传链接无效，uid不存在")
 		return
 	}
-	// 判断当前分片是否已上传
+	// 判断是否在本地，不在本地时转发，请求体以流的方式透传
+	if forwardToOwner(c, metaData) {
+		return
+	}
+	src, size, err := openUploadBody(c, raw)
+	if err != nil {
+		web.ParamsError(c, fmt.Sprintf("解析文件参数失败，详情：%s", err))
+		return
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+
+	// 判断当前分片是否已上传，同一序号同时只有一个请求写入，锁持有到元数据落库
 	var lgRedis = new(plugins.LangGoRedis).NewRedis()
-	ctx := context.Background()
-	createLock := base.NewRedisLock(&ctx, lgRedis, fmt.Sprintf("multi-part-%d-%d-%s", uid, chunkNum, md5))
+	ctx := c.Request.Context()
+	createLock := base.NewRedisLock(&ctx, lgRedis, fmt.Sprintf("multi-part-%d-%d", uid, chunkNum))
 	// NewRedisLock()函数用于创建一个redis锁，redis锁是一种分布式锁，分布式锁是指多个goroutine之间的锁，这些goroutine之间是通过网络进行通信的
-	if flag, err := createLock.Acquire(); err != nil || !flag { // Acquire()函数用于获取锁
+	lockCtx, cancel := context.WithTimeout(ctx, utils.LockAcquireTimeout)
+	defer cancel()
+	if flag, err := createLock.AcquireWithTimeout(lockCtx); err != nil || !flag { // 等待其他请求写完同一分片
+		// 等待超时说明同一分片仍在写入，由客户端稍后重试
+		if errors.Is(err, context.DeadlineExceeded) {
+			lgLogger.WithContext(c).Warn("上传多文件，同一分片正在写入")
+			web.TooManyRequests(c, "同一分片正在上传，请稍后重试")
+			return
+		}
 		lgLogger.WithContext(c).Error("上传多文件抢锁失败")
 		web.InternalError(c, "上传多文件抢锁失败")
 		return
 	}
+	defer func() {
+		if released, _ := createLock.Release(); !released { // Release()函数用于释放锁
+			lgLogger.WithContext(c).Warn("上传多文件，释放前锁已失效")
+		}
+	}()
 	partInfo, err := repo.NewMultiPartInfoRepo().GetPartInfo(lgDB, uid, chunkNum, md5)
 	// GetPartInfo()函数用于获取分片信息
 	// 在这里，查询是否成功，如果成功，那么就说明当前分片已上传，那么就不再上传，如果不成功，那么就说明当前分片没有上传，那么就继续上传
@@ -352,108 +374,72 @@ func UploadMultiPartHandler(c *gin.Context) {
 		web.Success(c, "")
 		return
 	}
-	_, _ = createLock.Release() // Release()函数用于释放锁
 
-	// 判断是否在本地
-	dirName := path.Join(utils.LocalStore, uidStr)
-	if _, err := os.Stat(dirName); os.IsNotExist(err) {
-		// 不在本地，询问集群内其他服务并转发
-		serviceList, err := base.NewServiceRegister().Discovery()
-		if err != nil || serviceList == nil {
-			lgLogger.WithContext(c).Error("发现其他服务失败")
-			web.InternalError(c, "发现其他服务失败")
-			return
-		}
-		var wg sync.WaitGroup
-		var ipList []string
-		ipChan := make(chan string, len(serviceList))
-		for _, service := range serviceList {
-			wg.Add(1)
-			go func(ip string, port string, ipChan chan string, wg *sync.WaitGroup) {
-				defer wg.Done()
-				res, err := thirdparty.NewStorageService().Locate(utils.Scheme, ip, port, uidStr)
-				if err != nil {
-					fmt.Print(err.Error())
-					return
-				}
-				ipChan <- res
-			}(service.IP, service.Port, ipChan, &wg)
-		}
-		wg.Wait()
-		close(ipChan)
-		for re := range ipChan {
-			ipList = append(ipList, re)
-		}
-		if len(ipList) == 0 {
-			lgLogger.WithContext(c).Error("发现其他服务失败")
-			web.InternalError(c, "发现其他服务失败")
-			return
-		}
-		proxyIP := ipList[0]
-		_, _, _, err = thirdparty.NewStorageService().UploadForward(c, utils.Scheme, proxyIP,
-			bootstrap.NewConfig("").App.Port, uidStr, false)
-		if err != nil {
-			lgLogger.WithContext(c).Error("多文件上传，转发失败")
-			web.InternalError(c, err.Error())
-			return
-		}
-		web.Success(c, "")
+	// 存在上传会话时校验分片序号及大小
+	if err := base.CheckSessionChunk(uid, int(chunkNum), size); err != nil {
+		web.ParamsError(c, err.Error())
 		return
 	}
 
-	// 在本地
-	fileName := path.Join(utils.LocalStore, uidStr, fmt.Sprintf("%d_%d", uid, chunkNum))
-	out, err := os.Create(fileName)
+	// 在本地，写入文件的同时计算md5
+	dirName, err := staging.NewStaging().Dir(uid)
 	if err != nil {
-		lgLogger.WithContext(c).Error("本地创建文件失败")
-		web.InternalError(c, "本地创建文件失败")
-		return
-	}
-	defer func(out *os.File) {
-		_ = out.Close()
-	}(out)
-	src, err := file.Open()
+		lgLogger.WithContext(c).Error("创建本地目录失败")
+		web.InternalError(c, "创建本地目录失败")
+		return
+	}
+	partFileName := fmt.Sprintf("%d_%d", uid, chunkNum)
+	fileName := path.Join(dirName, partFileName)
+	// 先写入临时文件，校验通过后再替换，重新上传失败时不破坏已上传的分片
+	tmpFileName := fileName + ".uploading"
+	defer func() {
+		_ = os.Remove(tmpFileName)
+	}()
+	written, md5Str, err := base.SaveFileWithMd5(tmpFileName, src)
 	if err != nil {
-		lgLogger.WithContext(c).Error("打开本地文件失败")
-		web.InternalError(c, "打开本地文件失败")
-		return
-	}
-	if _, err = io.Copy(out, src); err != nil {
 		lgLogger.WithContext(c).Error("请求数据存储到文件失败")
 		web.InternalError(c, "请求数据存储到文件失败")
 		return
 	}
-	// 校验md5
-	md5Str, err := base.CalculateFileMd5(fileName)
-	if err != nil {
-		lgLogger.WithContext(c).Error(fmt.Sprintf("生成md5失败，详情%s", err.Error()))
-		web.InternalError(c, err.Error())
+	if written != size {
+		web.ParamsError(c, fmt.Sprintf("分片大小不一致，实际:%d, 参数:%d", written, size))
 		return
 	}
+	// 校验md5
 	if md5Str != md5 {
 		lgLogger.WithContext(c).Error(fmt.Sprintf("校验md5失败，计算结果:%s, 参数:%s", md5Str, md5))
 		web.ParamsError(c, fmt.Sprintf("校验md5失败，计算结果:%s, 参数:%s", md5Str, md5))
 		return
 	}
-	// 上传到minio
-	contentType := "application/octet-stream"
-	if err := storage.NewStorage().Storage.PutObject(metaData.Bucket, fmt.Sprintf("%d_%d", uid, chunkNum),
-		fileName, contentType); err != nil {
+	if err := os.Rename(tmpFileName, fileName); err != nil {
+		lgLogger.WithContext(c).Error("请求数据存储到文件失败")
+		web.InternalError(c, "请求数据存储到文件失败")
+		return
+	}
+	// 保存到暂存区
+	partName := staging.NewStaging().PartName(uid, int(chunkNum))
+	if err := staging.NewStaging().PutPart(metaData.Bucket, partName, fileName); err != nil {
 		lgLogger.WithContext(c).Error("上传到minio失败")
 		web.InternalError(c, "上传到minio失败")
 		return
 	}
 
-	// 创建元数据
+	// 锁已失效时其他请求可能在写入同一分片，放弃落库
+	if createLock.Lost() {
+		lgLogger.WithContext(c).Error("上传多文件，分片锁已失效")
+		web.InternalError(c, "上传多文件，分片锁已失效")
+		return
+	}
+
+	// 创建元数据，同一序号md5不同的旧分片置为无效
 	now := time.Now()
-	fileInfo, _ := os.Stat(fileName)
-	if err := repo.NewMultiPartInfoRepo().Create(lgDB, &models.MultiPartInfo{ // Create()函数用于创建分片信息
+	if err := repo.NewMultiPartInfoRepo().ReplacePart(lgDB, &models.MultiPartInfo{ // ReplacePart()函数用于创建分片信息
 		StorageUid:   uid,
 		ChunkNum:     int(chunkNum),
 		Bucket:       metaData.Bucket,
-		StorageName:  fmt.Sprintf("%d_%d", uid, chunkNum),
-		StorageSize:  fileInfo.Size(),
-		PartFileName: fmt.Sprintf("%d_%d", uid, chunkNum),
+		StorageName:  partName,
+		StorageSize:  written,
+		PartFileName: partFileName,
 		PartMd5:      md5Str,
 		Status:       1,
 		CreatedAt:    &now,
@@ -488,7 +474,7 @@ func UploadMergeHandler(c *gin.Context) {
 	uidStr := c.Query("uid")
 	md5 := c.Query("md5")
 	numStr := c.Query("num")
-	size := c.Query("size")
+	sizeStr := c.Query("size")
 	date := c.Query("date")
 	expireStr := c.Query("expire")
 	signature := c.Query("signature")
@@ -504,6 +490,15 @@ func UploadMergeHandler(c *gin.Context) {
 		web.ParamsError(c, errorInfo)
 		return
 	}
+	if num <= 0 {
+		web.ParamsError(c, "num参数有误")
+		return
+	}
+	size, err := strconv.ParseInt(sizeStr, 10, 64)
+	if err != nil {
+		web.ParamsError(c, "size参数有误")
+		return
+	}
 
 	if !base.CheckUploadSignature(date, expireStr, signature) {
 		web.ParamsError(c, "签名校验失败")
@@ -544,6 +539,7 @@ func UploadMergeHandler(c *gin.Context) {
 			Status:    utils.TaskStatusUndo,
 			TaskType:  utils.TaskPartDelete,
 			ExtraData: string(b),
+			RefId:     uid,
 		}
 		if err := repo.NewTaskRepo().Create(lgDB, &newModelTask); err != nil {
 			lgLogger.WithContext(c).Error("分片数量和整体数量不一致，创建删除任务失败", zap.Any("err", err.Error()))
@@ -555,54 +551,27 @@ func UploadMergeHandler(c *gin.Context) {
 	}
 
 	// 判断是否在本地
-	dirName := path.Join(utils.LocalStore, uidStr)
-	if _, err := os.Stat(dirName); os.IsNotExist(err) {
-		// 不在本地，询问集群内其他服务并转发
-		serviceList, err := base.NewServiceRegister().Discovery()
-		if err != nil || serviceList == nil {
-			lgLogger.WithContext(c).Error("发现其他服务失败")
-			web.InternalError(c, "发现其他服务失败")
-			return
-		}
-		var wg sync.WaitGroup
-		var ipList []string
-		ipChan := make(chan string, len(serviceList))
-		for _, service := range serviceList {
-			wg.Add(1)
-			go func(ip string, port string, ipChan chan string, wg *sync.WaitGroup) {
-				defer wg.Done()
-				res, err := thirdparty.NewStorageService().Locate(utils.Scheme, ip, port, uidStr)
-				if err != nil {
-					return
-				}
-				ipChan <- res
-			}(service.IP, service.Port, ipChan, &wg)
-		}
-		wg.Wait()
-		close(ipChan)
-		for re := range ipChan {
-			ipList = append(ipList, re)
-		}
-		if len(ipList) == 0 {
-			lgLogger.WithContext(c).Error("发现其他服务失败")
-			web.InternalError(c, "发现其他服务失败")
-			return
-		}
-		proxyIP := ipList[0]
-		_, _, _, err = thirdparty.NewStorageService().MergeForward(c, utils.Scheme, proxyIP,
-			bootstrap.NewConfig("").App.Port, uidStr)
-		if err != nil {
-			lgLogger.WithContext(c).Error("合并文件，转发失败")
-			web.InternalError(c, err.Error())
-			return
-		}
-		web.Success(c, "")
+	if forwardToOwner(c, metaData) {
+		return
+	}
+	// 文件总大小以已上传的分片为准
+	if partSize := sumPartSize(multiPartInfoList); partSize != size {
+		web.ParamsError(c, fmt.Sprintf("文件大小不一致，分片合计:%d, 参数:%d", partSize, size))
 		return
 	}
+	mergeLocalParts(c, metaData, multiPartInfoList, md5)
+}
+
+// mergeLocalParts 分片在当前服务可用，更新元数据并创建合并任务
+func mergeLocalParts(c *gin.Context, metaData *models.MetaDataInfo, multiPartInfoList []models.MultiPartInfo, md5 string) {
+	uid := metaData.UID
+	uidStr := strconv.FormatInt(uid, 10)
+	num := int64(len(multiPartInfoList))
+	size := sumPartSize(multiPartInfoList)
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+
 	// 获取文件的content-type
-	firstPart := multiPartInfoList[0]
-	partName := path.Join(utils.LocalStore, fmt.Sprintf("%d", uid), firstPart.PartFileName)
-	contentType, err := base.DetectContentType(partName)
+	contentType, err := detectPartContentType(multiPartInfoList[0])
 	if err != nil {
 		lgLogger.WithContext(c).Error("判断文件content-type失败")
 		web.InternalError(c, "判断文件content-type失败")
@@ -611,7 +580,7 @@ func UploadMergeHandler(c *gin.Context) {
 
 	// 更新metadata的数据
 	now := time.Now()
-	if err := repo.NewMetaDataInfoRepo().Updates(lgDB, metaData.UID, map[string]interface{}{
+	if err := repo.NewMetaDataInfoRepo().Updates(lgDB, metaData.UID, markScanPending(map[string]interface{}{
 		"part_num":     int(num),
 		"md5":          md5,
 		"storage_size": size,
@@ -619,7 +588,7 @@ func UploadMergeHandler(c *gin.Context) {
 		"status":       1,
 		"updated_at":   &now,
 		"content_type": contentType,
-	}); err != nil {
+	})); err != nil {
 		lgLogger.WithContext(c).Error("上传完更新数据失败")
 		web.InternalError(c, "上传完更新数据失败")
 		return
@@ -639,6 +608,7 @@ func UploadMergeHandler(c *gin.Context) {
 		Status:    utils.TaskStatusUndo,
 		TaskType:  utils.TaskPartMerge,
 		ExtraData: string(b),
+		RefId:     uid,
 	}
 	if err := repo.NewTaskRepo().Create(lgDB, &newModelTask); err != nil {
 		lgLogger.WithContext(c).Error("创建合并任务失败", zap.Any("err", err.Error()))
@@ -674,6 +644,160 @@ func UploadMergeHandler(c *gin.Context) {
 	}
 	lgRedis.SetNX(context.Background(), fmt.Sprintf("%s-multiPart", uidStr), b, 5*60*time.Second)
 
+	// 存在上传会话时标记为已完成
+	if err := repo.NewUploadSessionRepo().Updates(lgDB, uid, map[string]interface{}{
+		"status":     utils.SessionStatusComplete,
+		"updated_at": &now,
+	}); err != nil {
+		lgLogger.WithContext(c).Warn("合并文件，更新上传会话失败", zap.Any("err", err.Error()))
+	}
 	web.Success(c, "")
-	return
+}
+
+// markScanPending 开启内容扫描时，提交合并即标记为待扫描，合并完成前不能下载未扫描的分片数据
+// 合并完成后创建扫描任务，扫描通过后才能下载
+func markScanPending(columns map[string]interface{}) map[string]interface{} {
+	if bootstrap.NewConfig("").Scan.Mode != "" {
+		columns["scan_status"] = utils.ScanStatusPending
+	}
+	return columns
+}
+
+// openUploadBody 获取上传数据及大小，raw为true时直接使用请求体，否则读取表单的file字段
+func openUploadBody(c *gin.Context, raw bool) (io.ReadCloser, int64, error) {
+	if raw {
+		if c.Request.ContentLength < 0 {
+			return nil, 0, errors.New("缺少Content-Length")
+		}
+		return c.Request.Body, c.Request.ContentLength, nil
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		return nil, 0, err
+	}
+	src, err := file.Open()
+	if err != nil {
+		return nil, 0, err
+	}
+	return src, file.Size, nil
+}
+
+// UploadCompleteHandler     直传完成回调
+//
+//	@Summary      直传完成回调
+//	@Description  客户端直传对象存储后调用，校验对象存在并标记为已上传，分片直传时先完成分片上传
+//	@Tags         上传
+//	@Accept       application/json
+//	@Param        uid        query  string  true   "文件uid"
+//	@Param        md5        query  string  false  "单个对象直传时校验的md5，分片直传时忽略"
+//	@Param        date       query  string  true   "链接生成时间"
+//	@Param        expire     query  string  true   "过期时间"
+//	@Param        signature  query  string  true   "签名"
+//	@Produce      application/json
+//	@Success      200  {object}  web.Response
+//	@Router       /api/storage/v0/upload/complete [put]
+func UploadCompleteHandler(c *gin.Context) {
+	uidStr := c.Query("uid")
+	md5 := c.Query("md5")
+	date := c.Query("date")
+	expireStr := c.Query("expire")
+	signature := c.Query("signature")
+
+	uid, err, errorInfo := base.CheckValid(uidStr, date, expireStr)
+	if err != nil {
+		web.ParamsError(c, errorInfo)
+		return
+	}
+	if !base.CheckUploadSignature(date, expireStr, signature) {
+		web.ParamsError(c, "签名校验失败")
+		return
+	}
+	sto, ok := storage.NewStorage().Presign()
+	if !ok {
+		web.ParamsError(c, "当前存储不支持直传")
+		return
+	}
+
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	metaData, err := repo.NewMetaDataInfoRepo().GetByUid(lgDB, uid)
+	if err != nil {
+		web.NotFoundResource(c, "当前上传链接无效，uid不存在")
+		return
+	}
+	if metaData.Status == 1 {
+		web.Success(c, "")
+		return
+	}
+
+	// 分片直传，由服务端查询已上传的分片并完成合并
+	stagingName := base.DirectStagingName(metaData.StorageName)
+	if metaData.UploadID != "" {
+		if err := sto.CompleteMultipartUpload(metaData.Bucket, stagingName, metaData.UploadID); err != nil {
+			lgLogger.WithContext(c).Error("直传完成，合并分片失败", zap.Any("err", err.Error()))
+			web.ParamsError(c, fmt.Sprintf("合并分片失败，详情：%s", err))
+			return
+		}
+	}
+	// 预签名链接在过期前仍可写入暂存对象，复制到客户端没有链接的正式对象后再校验，避免校验后被覆盖
+	if _, err := sto.StatObject(metaData.Bucket, stagingName); err != nil {
+		web.ParamsError(c, "对象不存在，请先上传")
+		return
+	}
+	if err := sto.CopyObject(metaData.Bucket, stagingName, metaData.StorageName); err != nil {
+		lgLogger.WithContext(c).Error("直传完成，复制暂存对象失败", zap.Any("err", err.Error()))
+		web.InternalError(c, "复制暂存对象失败")
+		return
+	}
+	stat, err := sto.StatObject(metaData.Bucket, metaData.StorageName)
+	if err != nil {
+		lgLogger.WithContext(c).Error("直传完成，查询对象失败", zap.Any("err", err.Error()))
+		web.InternalError(c, "查询对象失败")
+		return
+	}
+	if err := storage.NewStorage().Storage.DeleteObject(metaData.Bucket, stagingName); err != nil {
+		lgLogger.WithContext(c).Warn("直传完成，删除暂存对象失败", zap.Any("err", err.Error()))
+	}
+	// 单个对象直传时ETag即为md5；分片直传无法校验，不记录md5，避免客户端伪造md5污染秒传
+	verifiedMd5 := ""
+	if metaData.UploadID == "" && len(stat.ETag) == 32 {
+		if md5 != "" && md5 != stat.ETag {
+			web.ParamsError(c, fmt.Sprintf("校验md5失败，计算结果:%s, 参数:%s", stat.ETag, md5))
+			return
+		}
+		verifiedMd5 = stat.ETag
+	}
+
+	now := time.Now()
+	if err := repo.NewMetaDataInfoRepo().UpdatesWithEvent(lgDB, metaData.UID, map[string]interface{}{
+		"md5":          verifiedMd5,
+		"storage_size": stat.Size,
+		"multi_part":   false,
+		"status":       1,
+		"upload_id":    "",
+		"updated_at":   &now,
+		"content_type": base.DirectContentType(metaData.StorageName, stat.ContentType),
+	}, utils.EventObjectUploaded); err != nil {
+		lgLogger.WithContext(c).Error("上传完更新数据失败")
+		web.InternalError(c, "上传完更新数据失败")
+		return
+	}
+	publishObject(c, lgDB, utils.EventObjectUploaded, metaData.UID)
+	web.Success(c, "")
+}
+
+// publishObject 推送对象事件，推送任务创建失败只记录日志
+func publishObject(c *gin.Context, db *gorm.DB, eventType string, uid int64) {
+	if err := webhook.PublishObject(db, eventType, uid); err != nil {
+		lgLogger.WithContext(c).Warn("创建webhook推送任务失败", zap.Any("err", err.Error()))
+	}
+}
+
+// detectPartContentType 根据首个分片判断文件的content-type
+func detectPartContentType(part models.MultiPartInfo) (string, error) {
+	src, err := staging.NewStaging().OpenPart(part)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	return base.DetectReaderContentType(src)
 }
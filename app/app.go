@@ -11,6 +11,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/qinguoyi/osproxy/app/pkg/base"
+	"github.com/qinguoyi/osproxy/app/pkg/drain"
 	"github.com/qinguoyi/osproxy/app/pkg/event/dispatch"
 	"github.com/qinguoyi/osproxy/config"
 	"go.uber.org/zap"
@@ -28,8 +29,9 @@ func NewHttpServer(
 	router *gin.Engine,
 ) *http.Server { // Server是http的核心结构体，包含了路由、中间件等信息
 	return &http.Server{
-		Addr:    ":" + conf.App.Port, // Addr是http服务的地址，这里的地址是从配置文件中获取的
-		Handler: router,              // Handler是http服务的路由，这里的路由是gin的实例
+		Addr:      ":" + conf.App.Port,    // Addr是http服务的地址，这里的地址是从配置文件中获取的
+		Handler:   router,                 // Handler是http服务的路由，这里的路由是gin的实例
+		TLSConfig: base.ServerTLSConfig(), // 启用双向TLS时的配置
 	}
 }
 
@@ -54,17 +56,37 @@ func (a *App) RunServer() {
 	}
 
 	// service register
-	go base.NewServiceRegister().HeartBeat()
+	go base.NewDiscovery().HeartBeat()
+
+	// 选主，主节点执行集群内只需运行一次的任务
+	go base.NewLeader().Run()
 
 	// 启动 任务
 	a.logger.Info("start task ...")
 	p, consumers := dispatch.RunTask() // RunTask()函数用于启动任务，返回值是一个生产者和一个消费者的切片
 
 	// 等待中断信号以优雅地关闭应用
-	quit := make(chan os.Signal) // make()函数用于创建一个信号通道,channel是一种数据结构，它的特点是：1.先进先出；2.线程安全；3.可以用于多个goroutine之间的数据传递
+	quit := make(chan os.Signal, 1) // make()函数用于创建一个信号通道,channel是一种数据结构，它的特点是：1.先进先出；2.线程安全；3.可以用于多个goroutine之间的数据传递
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	// SIGUSR1 触发排空
+	drainSig := make(chan os.Signal, 1)
+	signal.Notify(drainSig, syscall.SIGUSR1)
+	go func() {
+		<-drainSig
+		drain.Start()
+	}()
 	<-quit
 
+	// 排空进行中时等待完成后再退出
+	if drain.Draining() {
+		log.Printf("wait drain ...")
+		<-drain.Done()
+	}
+
+	// 退出选主
+	base.NewLeader().Resign()
+
 	// 关闭任务
 	log.Printf("stop task ...")
 	dispatch.StopTask(p, consumers)
@@ -84,7 +106,14 @@ func (a *App) RunServer() {
 func (a *App) Run() error {
 	// 启动 http server
 	go func() {
-		if err := a.httpSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed { // ListenAndServe()函数用于启动http服务
+		var err error
+		if a.httpSrv.TLSConfig != nil {
+			// 证书已加载到TLSConfig
+			err = a.httpSrv.ListenAndServeTLS("", "")
+		} else {
+			err = a.httpSrv.ListenAndServe() // ListenAndServe()函数用于启动http服务
+		}
+		if err != nil && err != http.ErrServerClosed {
 			panic(err)
 		}
 
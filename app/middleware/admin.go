@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/app/pkg/web"
+)
+
+/*
+管理接口只接受本机或集群内请求
+*/
+
+// Admin _
+type Admin struct {
+}
+
+// NewAdmin _
+func NewAdmin() *Admin {
+	return &Admin{}
+}
+
+// Handler 需要在Peer之后执行
+func (a *Admin) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !c.GetBool(utils.PeerContextKey) && !isLoopback(c.Request.RemoteAddr) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, web.Response{
+				Message: "只接受本机或集群内请求",
+				Data:    "",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// isLoopback 请求是否来自本机，直接使用连接地址，不信任X-Forwarded-For
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -27,7 +27,8 @@ func (c *Cors) Handler() gin.HandlerFunc {
 		c.Header("Access-Control-Expose-Headers", "*")
 		c.Header("Access-Control-Allow-Credentials", "true")
 
-		if c.Request.Method == "OPTIONS" {
+		// 只拦截浏览器预检请求，tus等协议的OPTIONS请求交给路由处理
+		if c.Request.Method == "OPTIONS" && c.GetHeader("Access-Control-Request-Method") != "" {
 			c.AbortWithStatus(http.StatusNoContent)
 			return
 		}
@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/qinguoyi/osproxy/app/pkg/drain"
+)
+
+/*
+统计进行中的请求，排空时等待其完成
+*/
+
+// Drain _
+type Drain struct {
+}
+
+// NewDrain _
+func NewDrain() *Drain {
+	return &Drain{}
+}
+
+// Handler .
+func (d *Drain) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		drain.Acquire()
+		defer drain.Release()
+		c.Next()
+	}
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qinguoyi/osproxy/app/pkg/base"
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/app/pkg/web"
+)
+
+/*
+集群内请求校验
+*/
+
+// defaultMultipartMemory 与gin默认的MaxMultipartMemory一致
+const defaultMultipartMemory = 32 << 20
+
+// Peer _
+type Peer struct {
+	required bool
+}
+
+// NewPeer required为true时只接受集群内请求
+func NewPeer(required bool) *Peer {
+	return &Peer{
+		required: required,
+	}
+}
+
+// Handler 校验通过的请求标记为集群内请求，签名有误时拒绝；未配置集群校验时不限制
+func (p *Peer) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 全局及路由上都注册时只校验一次，请求体只能包装一次
+		if v, ok := c.Get(utils.PeerContextKey); ok {
+			p.check(c, v.(bool))
+			return
+		}
+		peer := base.VerifyPeerRequest(c.Request)
+		if !peer && c.GetHeader(utils.PeerSignatureHeader) != "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, web.Response{
+				Message: "集群内请求签名校验失败",
+				Data:    "",
+			})
+			return
+		}
+		// 请求体签名在读完请求体时才校验，读完并校验通过后才标记为集群内请求
+		// multipart解析到结束分隔符即停止，不一定读到请求体结尾，解析后读完剩余的请求体
+		if peer && base.PeerBodyUnverified(c.Request) {
+			var err error
+			if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+				err = base.ReadPeerForm(c.Request, defaultMultipartMemory)
+			} else {
+				var cleanup func()
+				cleanup, err = base.ReadPeerBody(c.Request, defaultMultipartMemory)
+				defer cleanup()
+			}
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, web.Response{
+					Message: "集群内请求体签名校验失败",
+					Data:    "",
+				})
+				return
+			}
+		}
+		c.Set(utils.PeerContextKey, peer)
+		p.check(c, peer)
+	}
+}
+
+func (p *Peer) check(c *gin.Context, peer bool) {
+	if p.required && !peer && base.PeerAuthEnabled() {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, web.Response{
+			Message: "只接受集群内请求",
+			Data:    "",
+		})
+		return
+	}
+	c.Next()
+}
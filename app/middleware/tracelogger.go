@@ -26,6 +26,8 @@ func (t *TraceLog) Handler() gin.HandlerFunc {
 		traceId := c.GetHeader("request-id")
 		if traceId == "" {
 			traceId = uuid.New().String()
+			// 写回请求头，转发到集群内其他服务时沿用同一个traceId
+			c.Request.Header.Set("request-id", traceId)
 		}
 		t.Logger.NewContext(c, zap.String("traceId", traceId))
 
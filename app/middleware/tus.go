@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"net/http"
+)
+
+/*
+tus协议版本协商
+*/
+
+// Tus _
+type Tus struct {
+}
+
+// NewTus _
+func NewTus() *Tus {
+	return &Tus{}
+}
+
+// Handler 所有响应携带Tus-Resumable，除OPTIONS外的请求必须声明支持的协议版本
+func (t *Tus) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Tus-Resumable", utils.TusResumable)
+		if c.Request.Method != http.MethodOptions && c.GetHeader("Tus-Resumable") != utils.TusResumable {
+			c.Header("Tus-Version", utils.TusResumable)
+			c.AbortWithStatus(http.StatusPreconditionFailed)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// DownloadLink 下载链接表，用于链接吊销及下载次数限制
+type DownloadLink struct {
+	ID            int64      `gorm:"column:id;primaryKey;not null;autoIncrement;comment:自增ID"`
+	LinkID        string     `json:"linkId" gorm:"column:link_id;not null;type:varchar(64);uniqueIndex:idx_link_id"` // 链接ID
+	UID           int64      `json:"uid" gorm:"column:uid;not null;index:idx_link_uid"`                              // 文件uid
+	MaxDownload   int        `json:"maxDownload" gorm:"column:max_download;not null;default:0"`                      // 最大下载次数，0表示不限制
+	DownloadCount int        `json:"downloadCount" gorm:"column:download_count;not null;default:0"`                  // 已下载次数
+	Revoked       bool       `json:"revoked" gorm:"column:revoked;not null;default:false"`                           // 是否已吊销
+	ExpireAt      *time.Time `json:"expireAt" gorm:"column:expire_at;comment:过期时间"`
+	CreatedAt     *time.Time `gorm:"column:created_at;not null;comment:创建时间"`
+	UpdatedAt     *time.Time `gorm:"column:updated_at;not null;comment:更新时间"`
+}
+
+// RevokeLink 吊销下载链接请求体，uid和linkId至少填一个
+type RevokeLink struct {
+	Uid    []string `json:"uid"`    // 吊销uid下的全部下载链接
+	LinkId []string `json:"linkId"` // 吊销指定的下载链接
+}
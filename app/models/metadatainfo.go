@@ -23,6 +23,11 @@ type MetaDataInfo struct {
 	Status      int        `gorm:"column:status;comment:是否上传"`
 	ContentType string     `gorm:"column:content_type;comment:文件类型"`
 	CompressUid int64      `gorm:"column:compress_uid;comment:压缩文件ID"`
+	UploadID    string     `gorm:"column:upload_id;comment:直传分片上传ID"`
+	OwnerNode   string     `gorm:"column:owner_node;comment:本地目录所在服务"`
+	ScanStatus  string     `gorm:"column:scan_status;type:varchar(32);default:'';comment:扫描状态"`
+	ScanResult  string     `gorm:"column:scan_result;comment:扫描结果，如病毒名称或失败原因"`
+	ScannedAt   *time.Time `gorm:"column:scanned_at;comment:扫描时间"`
 	CreatedAt   *time.Time `gorm:"column:created_at;not null;comment:创建时间"`
 	UpdatedAt   *time.Time `gorm:"column:updated_at;not null;comment:更新时间"`
 }
@@ -31,6 +36,8 @@ type MetaDataInfo struct {
 type GenUpload struct {
 	FilePath []string `json:"filePath" binding:"required"` // 文件路径
 	Expire   int      `json:"expire"`                      // 过期时间
+	Direct   bool     `json:"direct"`                      // 是否直传对象存储，本地存储不支持
+	PartNum  int      `json:"partNum"`                     // 直传的分片数量，0表示不分片
 }
 
 // MultiUrlResult .
@@ -40,9 +47,18 @@ type MultiUrlResult struct {
 	Merge  string `json:"merge"`
 }
 
+// DirectUrlResult 直传对象存储的预签名链接，上传完成后调用complete
+type DirectUrlResult struct {
+	Single   string   `json:"single,omitempty"`   // 整个对象的预签名上传链接
+	UploadId string   `json:"uploadId,omitempty"` // 分片上传ID
+	Parts    []string `json:"parts,omitempty"`    // 分片的预签名上传链接，第i个对应分片i+1
+	Complete string   `json:"complete"`           // 上传完成回调
+}
+
 type UrlResult struct {
-	Single string          `json:"single"`
-	Multi  *MultiUrlResult `json:"multi"`
+	Single string           `json:"single"`
+	Multi  *MultiUrlResult  `json:"multi"`
+	Direct *DirectUrlResult `json:"direct,omitempty"`
 }
 
 type GenUploadResp struct {
@@ -53,8 +69,9 @@ type GenUploadResp struct {
 
 // GenDownload 下载链接请求体
 type GenDownload struct {
-	Uid    []string `json:"uid" binding:"required"`    // 文件路径
-	Expire int      `json:"expire" binding:"required"` // 过期时间
+	Uid         []string `json:"uid" binding:"required"`    // 文件路径
+	Expire      int      `json:"expire" binding:"required"` // 过期时间
+	MaxDownload int      `json:"maxDownload"`               // 最大下载次数，0表示不限制
 }
 
 type MetaInfo struct {
@@ -67,9 +84,10 @@ type MetaInfo struct {
 }
 
 type GenDownloadResp struct {
-	Uid  string   `json:"uid"`
-	Url  string   `json:"url"`
-	Meta MetaInfo `json:"meta"`
+	Uid    string   `json:"uid"`
+	LinkId string   `json:"linkId"`
+	Url    string   `json:"url"`
+	Meta   MetaInfo `json:"meta"`
 }
 
 type MD5Name struct {
@@ -81,6 +99,16 @@ type ResumeReq struct {
 	Data []MD5Name `json:"data"` // Data是一个切片，切片的元素是MD5Name类型 这里的`json:"data"`是结构体标签，用于指定结构体成员变量在json中的名称
 }
 
+// UploadStatusResp 上传状态
+type UploadStatusResp struct {
+	Uid            string `json:"uid"`
+	Status         string `json:"status"`                // pending、uploading、merging、scanning、ready、failed
+	UploadedChunks int    `json:"uploadedChunks"`        // 已上传的分片数量
+	TotalChunks    int    `json:"totalChunks,omitempty"` // 分片总量，未创建上传会话、非直传且未提交合并时未知，不返回
+	Percent        int    `json:"percent"`               // 合并进度，0-100
+	Reason         string `json:"reason"`                // 失败原因，合并等待重试时为最近一次失败的原因
+}
+
 type ResumeResp struct {
 	Md5 string `json:"md5"`
 	Uid string `json:"uid"`
@@ -23,6 +23,11 @@ type PartInfo struct {
 	MaxChunk   int   `json:"max_chunk"`
 }
 
+// ScanInfo 扫描任务
+type ScanInfo struct {
+	StorageUid int64 `json:"storageUid"`
+}
+
 // MergeInfo .
 type MergeInfo struct {
 	StorageUid int64 `json:"storageUid"`
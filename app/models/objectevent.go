@@ -0,0 +1,47 @@
+package models
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// ObjectEvent 对象事件，推送到webhook及消息队列
+type ObjectEvent struct {
+	ID   string          `json:"id"`   // 事件ID，重复推送时不变，接收方据此去重
+	Type string          `json:"type"` // object.uploaded、merge.failed、object.deleted
+	Uid  string          `json:"uid"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data"`
+}
+
+// ObjectEventData 对象事件的数据
+type ObjectEventData struct {
+	Uid         string `json:"uid"`
+	Bucket      string `json:"bucket"`
+	Name        string `json:"name"`
+	StorageName string `json:"storageName"`
+	Size        int64  `json:"size"`
+	Md5         string `json:"md5"`
+	ContentType string `json:"contentType"`
+}
+
+// MergeFailedEventData 合并失败事件的数据
+type MergeFailedEventData struct {
+	ObjectEventData
+	TaskID int64  `json:"taskId"`
+	Error  string `json:"error"`
+}
+
+// NewObjectEventData 根据元数据生成对象事件的数据
+func NewObjectEventData(metaData *MetaDataInfo) ObjectEventData {
+	return ObjectEventData{
+		Uid:         strconv.FormatInt(metaData.UID, 10),
+		Bucket:      metaData.Bucket,
+		Name:        metaData.Name,
+		StorageName: metaData.StorageName,
+		Size:        metaData.StorageSize,
+		Md5:         metaData.Md5,
+		ContentType: metaData.ContentType,
+	}
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Outbox 对象事件发件箱，和元数据在同一事务内写入，由主节点按ID顺序发布到消息队列
+type Outbox struct {
+	ID          int64      `gorm:"column:id;primaryKey;not null;autoIncrement;comment:自增ID，即事件ID"`
+	EventType   string     `gorm:"column:event_type;not null;type:varchar(64);comment:事件类型"`
+	Uid         int64      `gorm:"column:uid;not null;comment:文件uid"`
+	Payload     string     `gorm:"column:payload;type:text;comment:事件数据"`
+	Published   bool       `gorm:"column:published;not null;default:false;index:idx_outbox_published;comment:是否已发布"`
+	PublishedAt *time.Time `gorm:"column:published_at;comment:发布时间"`
+	CreatedAt   *time.Time `gorm:"column:created_at;not null;comment:创建时间"`
+}
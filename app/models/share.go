@@ -0,0 +1,58 @@
+package models
+
+import "time"
+
+// Share 分享表，一个分享码对应一个或多个uid
+type Share struct {
+	ID            int64      `gorm:"column:id;primaryKey;not null;autoIncrement;comment:自增ID"`
+	Code          string     `json:"code" gorm:"column:code;not null;type:varchar(32);uniqueIndex:idx_share_code"` // 分享码
+	Uids          string     `json:"uids" gorm:"column:uids;not null;type:text"`                                   // 分享的uid，逗号分隔
+	Owner         string     `json:"owner" gorm:"column:owner;not null;type:varchar(255);index:idx_share_owner"`   // 分享者
+	PasswordHash  string     `json:"-" gorm:"column:password_hash;not null;type:varchar(255)"`                     // 密码哈希，为空表示无需密码
+	MaxDownload   int        `json:"maxDownload" gorm:"column:max_download;not null;default:0"`                    // 最大访问次数，0表示不限制
+	DownloadCount int        `json:"downloadCount" gorm:"column:download_count;not null;default:0"`                // 已访问次数
+	ExpireAt      *time.Time `json:"expireAt" gorm:"column:expire_at;comment:过期时间"`
+	CreatedAt     *time.Time `gorm:"column:created_at;not null;comment:创建时间"`
+	UpdatedAt     *time.Time `gorm:"column:updated_at;not null;comment:更新时间"`
+}
+
+// ShareAccessLog 分享访问日志表
+type ShareAccessLog struct {
+	ID        int64      `json:"-" gorm:"column:id;primaryKey;not null;autoIncrement;comment:自增ID"`
+	ShareCode string     `json:"shareCode" gorm:"column:share_code;not null;type:varchar(32);index:idx_share_log_code"` // 分享码
+	UID       string     `json:"uid" gorm:"column:uid;not null;type:varchar(32)"`                                       // 访问的uid，为空表示访问整个分享
+	IP        string     `json:"ip" gorm:"column:ip;not null;type:varchar(64)"`                                         // 访问者IP
+	UserAgent string     `json:"userAgent" gorm:"column:user_agent;not null;type:varchar(512)"`                         // 访问者UA
+	Result    string     `json:"result" gorm:"column:result;not null;type:varchar(64)"`                                 // 访问结果
+	CreatedAt *time.Time `json:"createdAt" gorm:"column:created_at;not null;comment:访问时间"`
+}
+
+// GenShare 创建分享请求体
+type GenShare struct {
+	Uid         []string `json:"uid" binding:"required"`    // 分享的uid
+	Expire      int      `json:"expire" binding:"required"` // 过期时间，单位秒
+	Owner       string   `json:"owner"`                     // 分享者
+	Password    string   `json:"password"`                  // 访问密码，为空表示无需密码
+	MaxDownload int      `json:"maxDownload"`               // 最大访问次数，0表示不限制
+}
+
+// GenShareResp 创建分享返回体
+type GenShareResp struct {
+	Code         string     `json:"code"`
+	Url          string     `json:"url"`
+	ExpireAt     *time.Time `json:"expireAt"`
+	LogSignature string     `json:"logSignature"` // 查询访问日志的签名，只返回给分享者
+}
+
+// ShareResp 分享解析结果
+type ShareResp struct {
+	Code     string            `json:"code"`
+	ExpireAt *time.Time        `json:"expireAt"`
+	Files    []GenDownloadResp `json:"files"`
+}
+
+// ShareLogResp 分享访问日志分页结果
+type ShareLogResp struct {
+	Total int64            `json:"total"`
+	List  []ShareAccessLog `json:"list"`
+}
@@ -5,13 +5,16 @@ import "time"
 // TaskInfo .
 type TaskInfo struct {
 	ID          int64      `gorm:"column:id;primaryKey;not null;autoIncrement;comment:自增ID"`
-	Status      int        `json:"Status" gorm:"column:status;not null;index:idx_task_status"`       // 任务状态 0 未执行 1 执行中 2 执行完成 99 执行失败
+	Status      int        `json:"Status" gorm:"column:status;not null;index:idx_task_status"`       // 任务状态 0 未执行 1 执行中 2 执行完成 3 已取消 99 执行失败
 	TaskType    string     `json:"taskType" gorm:"column:task_type;not null;type:varchar(255)"`      // 任务类型
 	UserID      string     `json:"userId" gorm:"column:user_id;type:varchar(255)"`                   // 任务触发者
 	ExtraData   string     `json:"extraData" gorm:"column:extra_data;type:text"`                     // 任务补充信息
+	RefId       int64      `json:"refId" gorm:"column:ref_id;index:idx_ref_id"`                      // 关联的业务ID，分片合并及清理任务为文件uid
 	NodeId      string     `json:"nodeId" gorm:"column:node_id;type:varchar(255);index:idx_node_id"` // 任务运行节点ID
 	TaskLogID   int        `json:"taskLogId" gorm:"column:task_log_id"`                              // 任务日志ID，只展示最新的任务日志ID
 	ExecuteTime int        `json:"executeTime" gorm:"column:execute_time;comment:任务执行次数;default:1"`
+	NextRunAt   *time.Time `json:"nextRunAt" gorm:"column:next_run_at;comment:下次执行时间，为空时立即执行"`
+	LeaseUntil  *time.Time `json:"leaseUntil" gorm:"column:lease_until;comment:执行中任务的租约到期时间"`
 	CreatedAt   *time.Time `gorm:"column:created_at;not null;comment:创建时间"`
 	UpdatedAt   *time.Time `gorm:"column:updated_at;not null;comment:更新时间"`
 }
@@ -25,3 +28,51 @@ type TaskLog struct {
 	CreatedAt *time.Time `gorm:"column:created_at;not null;comment:创建时间"`
 	UpdatedAt *time.Time `gorm:"column:updated_at;not null;comment:更新时间"`
 }
+
+// TaskListResp 任务分页结果
+type TaskListResp struct {
+	Total int64      `json:"total"`
+	List  []TaskInfo `json:"list"`
+}
+
+// TaskDetailResp 任务及执行日志
+type TaskDetailResp struct {
+	Task TaskInfo  `json:"task"`
+	Logs []TaskLog `json:"logs"`
+}
+
+// TaskFilter 任务查询条件，零值表示不过滤
+type TaskFilter struct {
+	TaskType string
+	Status   *int
+	NodeId   string
+	Start    *time.Time // 创建时间起
+	End      *time.Time // 创建时间止
+}
+
+// TaskQueueResp 任务类型的队列情况
+type TaskQueueResp struct {
+	TaskType string `json:"taskType"`
+	Runnable int64  `json:"runnable"` // 已到执行时间的未执行任务
+	Delayed  int64  `json:"delayed"`  // 等待重试的任务
+	Running  int64  `json:"running"`  // 执行中的任务
+	Dead     int64  `json:"dead"`     // 死信任务
+	Workers  int    `json:"workers"`  // 当前服务的worker数
+	Busy     int    `json:"busy"`     // 当前服务已占用的worker数
+}
+
+// TaskCount 按任务类型及状态统计的数量
+type TaskCount struct {
+	TaskType string
+	Status   int
+	Count    int64
+}
+
+// MergeStatusResp 分片合并状态
+type MergeStatusResp struct {
+	Uid         string `json:"uid"`
+	Status      string `json:"status"` // pending、running、finished、failed、canceled
+	TaskID      int64  `json:"taskId"`
+	ExecuteTime int    `json:"executeTime"` // 已执行次数
+	ErrorInfo   string `json:"errorInfo"`   // 最近一次失败的原因
+}
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// UploadSession 分片上传会话，记录总大小及分片大小，用于校验分片及查询上传进度
+type UploadSession struct {
+	ID        int64      `gorm:"column:id;primaryKey;not null;autoIncrement;comment:自增ID"`
+	UID       int64      `gorm:"column:uid;not null;uniqueIndex:idx_session_uid;comment:文件uid"`
+	TotalSize int64      `gorm:"column:total_size;not null;comment:文件总大小"`
+	ChunkSize int64      `gorm:"column:chunk_size;not null;comment:分片大小，最后一个分片可以更小"`
+	ChunkSum  int        `gorm:"column:chunk_sum;not null;comment:分片总量"`
+	Md5       string     `gorm:"column:md5;comment:整体md5，为空时以合并结果为准"`
+	Status    int        `gorm:"column:status;not null;comment:会话状态"`
+	ExpireAt  *time.Time `gorm:"column:expire_at;not null;comment:过期时间"`
+	CreatedAt *time.Time `gorm:"column:created_at;not null;comment:创建时间"`
+	UpdatedAt *time.Time `gorm:"column:updated_at;not null;comment:更新时间"`
+}
+
+// InitSession 创建上传会话请求体
+type InitSession struct {
+	TotalSize int64  `json:"totalSize" binding:"required"` // 文件总大小
+	ChunkSize int64  `json:"chunkSize" binding:"required"` // 分片大小
+	Md5       string `json:"md5"`                          // 整体md5，可选
+	Expire    int    `json:"expire"`                       // 会话有效期，单位秒，默认24小时
+}
+
+// SessionResp 上传会话状态
+type SessionResp struct {
+	Uid          string     `json:"uid"`
+	TotalSize    int64      `json:"totalSize"`
+	ChunkSize    int64      `json:"chunkSize"`
+	ChunkSum     int        `json:"chunkSum"`
+	Md5          string     `json:"md5"`
+	Status       int        `json:"status"`
+	ExpireAt     *time.Time `json:"expireAt"`
+	Bitmap       string     `json:"bitmap"` // 第i位为1表示分片i+1已上传
+	UploadedNum  int        `json:"uploadedNum"`
+	UploadedSize int64      `json:"uploadedSize"`
+}
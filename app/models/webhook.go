@@ -0,0 +1,7 @@
+package models
+
+// WebhookInfo webhook推送任务
+type WebhookInfo struct {
+	Url   string      `json:"url"`
+	Event ObjectEvent `json:"event"`
+}
@@ -0,0 +1,278 @@
+package base
+
+/*
+集群内通信：请求签名及双向TLS，接收方据此区分集群内请求与外部请求
+签名包含请求体摘要：没有请求体时摘要为空内容的摘要；有请求体时请求以chunked的方式边发送边计算摘要，
+请求头签名中摘要为占位，请求体之后在trailer中发送对实际摘要的签名，接收方读完请求体后校验
+签名包含随机nonce，接收方在redis中记录签名有效期内出现过的nonce，拒绝重放的请求
+*/
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/bootstrap"
+	"github.com/qinguoyi/osproxy/bootstrap/plugins"
+	"github.com/qinguoyi/osproxy/config"
+	"go.uber.org/zap"
+)
+
+var (
+	serverTLSConfig *tls.Config
+)
+
+// InitCluster 初始化集群内通信，启用双向TLS时客户端校验对端证书并携带自身证书
+func InitCluster(conf *config.Configuration) {
+	if conf.Cluster.Secret == "" && !conf.Cluster.Tls {
+		bootstrap.NewLogger().Logger.Warn("未配置集群密钥及双向TLS，集群内请求不做校验")
+		return
+	}
+	if !conf.Cluster.Tls {
+		return
+	}
+	caPem, err := os.ReadFile(conf.Cluster.CaFile)
+	if err != nil {
+		panic(err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPem) {
+		panic("加载集群CA证书失败")
+	}
+	cert, err := tls.LoadX509KeyPair(conf.Cluster.CertFile, conf.Cluster.KeyFile)
+	if err != nil {
+		panic(err)
+	}
+	Client.Transport.(*http.Transport).TLSClientConfig = &tls.Config{
+		RootCAs:      pool,
+		Certificates: []tls.Certificate{cert},
+	}
+	// 外部客户端不需要证书，携带CA签发证书的请求视为集群内请求
+	serverTLSConfig = &tls.Config{
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		Certificates: []tls.Certificate{cert},
+	}
+}
+
+// ServerTLSConfig 启用双向TLS时http服务使用的配置，未启用时为nil
+func ServerTLSConfig() *tls.Config {
+	return serverTLSConfig
+}
+
+// PeerScheme 集群内请求使用的协议
+func PeerScheme() string {
+	if bootstrap.NewConfig("").Cluster.Tls {
+		return "https"
+	}
+	return utils.Scheme
+}
+
+// PeerAuthEnabled 是否配置了集群内请求校验
+func PeerAuthEnabled() bool {
+	conf := bootstrap.NewConfig("").Cluster
+	return conf.Secret != "" || conf.Tls
+}
+
+// emptyBodyHash 空请求体的摘要
+var emptyBodyHash = hex.EncodeToString(sha256.New().Sum(nil))
+
+// SignPeerRequest 集群内请求携带时间戳及签名，需要在请求体设置之后调用
+func SignPeerRequest(r *http.Request) {
+	secret := bootstrap.NewConfig("").Cluster.Secret
+	if secret == "" {
+		return
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	r.Header.Set(utils.PeerTimestampHeader, ts)
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	nonce := hex.EncodeToString(b)
+	r.Header.Set(utils.PeerNonceHeader, nonce)
+	// 长度未知的请求体ContentLength同样为0，只以Body判断
+	if r.Body == nil || r.Body == http.NoBody {
+		r.Header.Set(utils.PeerSignatureHeader, peerSignature(secret, r.Method, r.URL.RequestURI(), ts, nonce,
+			emptyBodyHash))
+		return
+	}
+	r.Header.Set(utils.PeerSignatureHeader, peerSignature(secret, r.Method, r.URL.RequestURI(), ts, nonce,
+		utils.PeerStreamingBody))
+	// 发送trailer需要chunked编码，原始长度放在请求头中由接收方恢复
+	if r.ContentLength > 0 {
+		r.Header.Set(utils.PeerContentLengthHeader, strconv.FormatInt(r.ContentLength, 10))
+	}
+	r.ContentLength = -1
+	r.Trailer = http.Header{utils.PeerBodySignatureHeader: nil}
+	r.Body = &peerBody{ReadCloser: r.Body, hash: sha256.New(), onEOF: func(sum string) error {
+		r.Trailer.Set(utils.PeerBodySignatureHeader, peerSignature(secret, r.Method, r.URL.RequestURI(), ts, nonce,
+			sum))
+		return nil
+	}}
+}
+
+// VerifyPeerRequest 是否为集群内请求：携带集群CA签发的客户端证书，或签名校验通过、未过期且nonce未出现过
+// 有请求体时替换为校验摘要的请求体，读完请求体时trailer中的签名不一致则返回错误，需要读完请求体后才能信任
+func VerifyPeerRequest(r *http.Request) bool {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	nonce, ok := VerifyPeerSignature(r)
+	if !ok {
+		return false
+	}
+	lgRedis := new(plugins.LangGoRedis).NewRedis()
+	if err := claimPeerNonce(context.Background(), lgRedis, nonce); err != nil {
+		bootstrap.NewLogger().Logger.Warn("集群内请求nonce校验失败", zap.Any("err", err.Error()))
+		return false
+	}
+	return true
+}
+
+// VerifyPeerSignature 只校验请求签名及时间戳，返回签名中的nonce，不检查是否重放
+func VerifyPeerSignature(r *http.Request) (string, bool) {
+	secret := bootstrap.NewConfig("").Cluster.Secret
+	ts := r.Header.Get(utils.PeerTimestampHeader)
+	nonce := r.Header.Get(utils.PeerNonceHeader)
+	signature := r.Header.Get(utils.PeerSignatureHeader)
+	if secret == "" || ts == "" || nonce == "" || signature == "" {
+		return "", false
+	}
+	t, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "", false
+	}
+	if d := time.Now().Unix() - t; d > utils.PeerSignatureExpire || d < -utils.PeerSignatureExpire {
+		return "", false
+	}
+	method, uri := r.Method, r.URL.RequestURI()
+	if hmac.Equal([]byte(signature), []byte(peerSignature(secret, method, uri, ts, nonce, emptyBodyHash))) {
+		// 签名时没有请求体，不接受任何请求体
+		r.Body = http.NoBody
+		r.ContentLength = 0
+		return nonce, true
+	}
+	if !hmac.Equal([]byte(signature), []byte(peerSignature(secret, method, uri, ts, nonce,
+		utils.PeerStreamingBody))) {
+		return "", false
+	}
+	if n, err := strconv.ParseInt(r.Header.Get(utils.PeerContentLengthHeader), 10, 64); err == nil && n > 0 {
+		r.ContentLength = n
+	}
+	r.Body = &peerBody{ReadCloser: r.Body, hash: sha256.New(), onEOF: func(sum string) error {
+		expect := peerSignature(secret, method, uri, ts, nonce, sum)
+		if !hmac.Equal([]byte(r.Trailer.Get(utils.PeerBodySignatureHeader)), []byte(expect)) {
+			return errors.New("集群内请求体签名校验失败")
+		}
+		return nil
+	}}
+	return nonce, true
+}
+
+// claimPeerNonce 记录nonce，时间戳前后的有效期内出现过的nonce视为重放
+func claimPeerNonce(ctx context.Context, lgRedis *redis.Client, nonce string) error {
+	ok, err := lgRedis.SetNX(ctx, fmt.Sprintf("%s-peerNonce", nonce), "1",
+		2*utils.PeerSignatureExpire*time.Second).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("重放的集群内请求")
+	}
+	return nil
+}
+
+// PeerBodyUnverified 请求体签名是否尚未校验，需要读完请求体才能确认是集群内请求
+func PeerBodyUnverified(r *http.Request) bool {
+	_, ok := r.Body.(*peerBody)
+	return ok
+}
+
+// ReadPeerBody 读完集群内请求的请求体以校验签名，请求体暂存在内存中，超过maxMemory时暂存到临时文件
+// 返回的函数用于删除临时文件，需要在处理完请求后调用
+func ReadPeerBody(r *http.Request, maxMemory int64) (func(), error) {
+	buf := &bytes.Buffer{}
+	n, err := io.CopyN(buf, r.Body, maxMemory+1)
+	if err != nil && err != io.EOF {
+		return func() {}, err
+	}
+	if n <= maxMemory {
+		r.Body = io.NopCloser(buf)
+		return func() {}, nil
+	}
+	f, err := os.CreateTemp("", "osproxy-peer-")
+	if err != nil {
+		return func() {}, err
+	}
+	cleanup := func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}
+	if _, err := io.Copy(f, io.MultiReader(buf, r.Body)); err != nil {
+		cleanup()
+		return func() {}, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return func() {}, err
+	}
+	r.Body = f
+	return cleanup, nil
+}
+
+// ReadPeerForm 解析集群内的multipart请求并读完剩余的请求体，请求体签名不一致时返回错误
+func ReadPeerForm(r *http.Request, maxMemory int64) error {
+	if err := r.ParseMultipartForm(maxMemory); err != nil {
+		return err
+	}
+	_, err := io.Copy(io.Discard, r.Body)
+	return err
+}
+
+// peerSignature 对请求方法、路径及查询参数、时间戳、nonce及请求体摘要签名
+func peerSignature(secret, method, uri, ts, nonce, bodyHash string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + uri + "\n" + ts + "\n" + nonce + "\n" + bodyHash))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// peerBody 读取请求体的同时计算摘要，读完时回调
+type peerBody struct {
+	io.ReadCloser
+	hash  hash.Hash
+	onEOF func(sum string) error
+	done  bool
+	err   error
+}
+
+func (b *peerBody) Read(p []byte) (int, error) {
+	if b.done {
+		if b.err != nil {
+			return 0, b.err
+		}
+		return 0, io.EOF
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.hash.Write(p[:n])
+	if err == io.EOF {
+		b.done = true
+		if b.err = b.onEOF(hex.EncodeToString(b.hash.Sum(nil))); b.err != nil {
+			return n, b.err
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,191 @@
+package base
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/bootstrap"
+	"github.com/qinguoyi/osproxy/config"
+)
+
+// testConfig 测试用的配置，配置全局只加载一次，各测试按需修改
+func testConfig(t *testing.T) *config.Configuration {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("cluster:\n  secret: \"\"\n"), 0644); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	return bootstrap.NewConfig(path)
+}
+
+func TestPeerRequestBodySignature(t *testing.T) {
+	conf := testConfig(t)
+	conf.Cluster.Secret = "test-secret"
+	defer func() { conf.Cluster.Secret = "" }()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := VerifyPeerSignature(r); !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.ContentLength != int64(len(body)) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	send := func(r *http.Request) (int, string) {
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	newRequest := func(method string, body io.Reader) *http.Request {
+		r, err := http.NewRequest(method, server.URL+"/peer?uid=1", body)
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		return r
+	}
+
+	r := newRequest(http.MethodGet, nil)
+	SignPeerRequest(r)
+	if code, _ := send(r); code != http.StatusOK {
+		t.Errorf("Expected 200 for request without body, but got %d", code)
+	}
+	r = newRequest(http.MethodPut, strings.NewReader("part data"))
+	SignPeerRequest(r)
+	if code, body := send(r); code != http.StatusOK || body != "part data" {
+		t.Errorf("Expected 200 with body for signed body, but got %d %q", code, body)
+	}
+
+	// 沿用签名及trailer替换请求体
+	signed := newRequest(http.MethodPut, strings.NewReader("part data"))
+	SignPeerRequest(signed)
+	if _, err := io.ReadAll(signed.Body); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	r = newRequest(http.MethodPut, io.NopCloser(strings.NewReader("evil data")))
+	r.Header = signed.Header.Clone()
+	r.Trailer = signed.Trailer.Clone()
+	r.ContentLength = -1
+	if code, _ := send(r); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for tampered body, but got %d", code)
+	}
+
+	// 无请求体的签名不接受请求体
+	signed = newRequest(http.MethodPut, nil)
+	SignPeerRequest(signed)
+	r = newRequest(http.MethodPut, strings.NewReader("evil data"))
+	r.Header = signed.Header.Clone()
+	if code, body := send(r); code != http.StatusOK || body != "" {
+		t.Errorf("Expected body to be dropped, but got %d %q", code, body)
+	}
+
+	// 未签名的请求不视为集群内请求
+	r = httptest.NewRequest(http.MethodGet, "/peer", nil)
+	if _, ok := VerifyPeerSignature(r); ok {
+		t.Errorf("Expected unsigned request to be rejected")
+	}
+}
+
+func TestClaimPeerNonce(t *testing.T) {
+	conf := testConfig(t)
+	conf.Cluster.Secret = "test-secret"
+	defer func() { conf.Cluster.Secret = "" }()
+	_, client := newFakeRedis(t)
+	ctx := context.Background()
+
+	signed := httptest.NewRequest(http.MethodGet, "/peer?uid=1", nil)
+	SignPeerRequest(signed)
+	nonce, ok := VerifyPeerSignature(signed)
+	if !ok {
+		t.Fatalf("Expected signed request to be verified")
+	}
+	if err := claimPeerNonce(ctx, client, nonce); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	// 原样重放的请求签名有效，但nonce已出现过
+	replay := httptest.NewRequest(http.MethodGet, "/peer?uid=1", nil)
+	replay.Header = signed.Header.Clone()
+	nonce, ok = VerifyPeerSignature(replay)
+	if !ok {
+		t.Fatalf("Expected replayed signature to be valid")
+	}
+	if err := claimPeerNonce(ctx, client, nonce); err == nil {
+		t.Errorf("Expected replayed request to be rejected")
+	}
+
+	// 更换nonce后签名失效
+	replay.Header.Set(utils.PeerNonceHeader, "another-nonce")
+	if _, ok := VerifyPeerSignature(replay); ok {
+		t.Errorf("Expected signature with changed nonce to be rejected")
+	}
+
+	// 每个请求的nonce不同
+	next := httptest.NewRequest(http.MethodGet, "/peer?uid=1", nil)
+	SignPeerRequest(next)
+	nonce, _ = VerifyPeerSignature(next)
+	if err := claimPeerNonce(ctx, client, nonce); err != nil {
+		t.Errorf("Expected new request to be accepted, but got %v", err)
+	}
+}
+
+func TestReadPeerBody(t *testing.T) {
+	conf := testConfig(t)
+	conf.Cluster.Secret = "test-secret"
+	defer func() { conf.Cluster.Secret = "" }()
+
+	for _, maxMemory := range []int64{1 << 20, 4} {
+		signed := httptest.NewRequest(http.MethodPut, "/peer?uid=1", nil)
+		signed.Body = io.NopCloser(strings.NewReader("part data"))
+		SignPeerRequest(signed)
+		if _, err := io.ReadAll(signed.Body); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+
+		r := httptest.NewRequest(http.MethodPut, "/peer?uid=1", strings.NewReader("part data"))
+		r.Header = signed.Header.Clone()
+		r.Trailer = signed.Trailer.Clone()
+		if _, ok := VerifyPeerSignature(r); !ok || !PeerBodyUnverified(r) {
+			t.Fatalf("Expected body to be verified after reading")
+		}
+		cleanup, err := ReadPeerBody(r, maxMemory)
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		body, _ := io.ReadAll(r.Body)
+		cleanup()
+		if string(body) != "part data" {
+			t.Errorf("Expected body to be kept, but got %q", body)
+		}
+
+		// 替换请求体时读完即校验失败
+		r = httptest.NewRequest(http.MethodPut, "/peer?uid=1", strings.NewReader("evil data"))
+		r.Header = signed.Header.Clone()
+		r.Trailer = signed.Trailer.Clone()
+		if _, ok := VerifyPeerSignature(r); !ok {
+			t.Fatalf("Expected header signature to be valid")
+		}
+		if _, err := ReadPeerBody(r, maxMemory); err == nil {
+			t.Errorf("Expected tampered body to be rejected")
+		}
+	}
+}
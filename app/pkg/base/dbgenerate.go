@@ -1,15 +1,20 @@
 package base
 
 /*
-数据库生成uid
+数据库生成uid，号段模式
 */
 
 import (
 	"errors"
+	"fmt"
+	"time"
+
+	"github.com/qinguoyi/osproxy/app/models"
 	"github.com/qinguoyi/osproxy/app/pkg/repo"
+	"github.com/qinguoyi/osproxy/bootstrap"
 	"github.com/qinguoyi/osproxy/bootstrap/plugins"
+	"go.uber.org/zap"
 	"gorm.io/gorm"
-	"time"
 )
 
 // Uid 每个业务独立的Uid发号器
@@ -19,6 +24,34 @@ type Uid struct {
 	min, max   int64
 }
 
+// InitUidBiz 业务ID不存在时按步长初始化号段
+func InitUidBiz(bizId string, step int64) error {
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	if _, err := repo.NewUidRepo().GetByBusinessID(lgDB, bizId); err == nil {
+		return nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if step <= 0 {
+		return fmt.Errorf("业务%s的号段步长有误", bizId)
+	}
+	now := time.Now()
+	err := repo.NewUidRepo().Create(lgDB, &models.Uid{
+		BusinessId: bizId,
+		MaxId:      0,
+		Step:       step,
+		CreatedAt:  &now,
+		UpdatedAt:  &now,
+	})
+	if err != nil {
+		// 其他服务同时初始化时唯一索引冲突，以已存在的记录为准
+		if _, getErr := repo.NewUidRepo().GetByBusinessID(lgDB, bizId); getErr == nil {
+			return nil
+		}
+	}
+	return err
+}
+
 // NewUid 生成新的发号器
 func NewUid(bizId string) (*Uid, error) {
 	percent := 0.5
@@ -50,60 +83,54 @@ func (u *Uid) NextId() (int64, error) {
 
 // producer 生产者
 func (u *Uid) producer(bizId string) {
-	err := u.reload(bizId)
-	if err != nil {
-		return
-	}
+	u.reload(bizId)
 
 	// 一直往ch中增加数据，如果ch满了就会阻塞，如果没满就会继续加，如果min>max了，就去db获取数据；相当于ch缓存了len的数据，不会等到号段耗尽采取拿
 	for {
 		if u.min >= u.max {
-			err := u.reload(bizId)
-			if err != nil {
-				return
-			}
+			u.reload(bizId)
 		}
 		u.min++
 		u.ch <- u.min
 	}
 }
 
-// reload 从db中获取号段
-func (u *Uid) reload(bizId string) error {
+// reload 从db中获取号段，失败时重试直到成功
+func (u *Uid) reload(bizId string) {
 	for {
 		err := u.getData(bizId)
-		if err != nil {
-			return err
+		if err == nil {
+			return
 		}
+		bootstrap.NewLogger().Logger.Error(fmt.Sprintf("获取业务%s的号段失败", bizId), zap.Any("err", err.Error()))
 		time.Sleep(time.Second)
 	}
 }
 
 // getData 获取数据，这里事务用于分布式部署生成，每个生成器使用独立的号段来生成，服务重启直接取新号段，不管之前的号码消费完没
+// 先加步长再读取，号段的分配由数据库行锁保证不重复
 func (u *Uid) getData(bizId string) error {
 	var maxId int64
 	var step int64
 	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
 	err := lgDB.Transaction(
 		func(tx *gorm.DB) error {
+			// 更新号段
+			if err := repo.NewUidRepo().IncrMaxId(tx, bizId); err != nil {
+				return err
+			}
 			// 获取业务ID对应的步进数据
-			uidInfo, err := repo.NewUidRepo().GetByBusinessID(lgDB, bizId)
+			uidInfo, err := repo.NewUidRepo().GetByBusinessID(tx, bizId)
 			if err != nil {
 				return err
 			}
 			maxId, step = uidInfo.MaxId, uidInfo.Step
-			// 更新号段
-			if err = repo.NewUidRepo().Updates(tx, bizId, map[string]interface{}{
-				"max_id": maxId + step,
-			}); err != nil {
-				return err
-			}
 			return nil
 		})
 	if err != nil {
 		return err
 	}
-	u.min = maxId
-	u.max = maxId + step
+	u.min = maxId - step
+	u.max = maxId
 	return nil
 }
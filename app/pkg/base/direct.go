@@ -0,0 +1,72 @@
+package base
+
+/*
+预签名直传，上传数据不经过osproxy
+*/
+
+import (
+	"errors"
+	"mime"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/qinguoyi/osproxy/app/models"
+	"github.com/qinguoyi/osproxy/app/pkg/storage"
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+)
+
+// GenDirectUpload 生成直传对象存储的预签名链接，分片直传时记录uploadId
+func GenDirectUpload(meta *models.MetaDataInfo, resp *models.GenUploadResp, partNum int, expire int) error {
+	sto, ok := storage.NewStorage().Presign()
+	if !ok {
+		return errors.New("当前存储不支持直传")
+	}
+	duration := time.Duration(expire) * time.Second
+	_, query, _ := strings.Cut(resp.Url.Single, "?")
+	direct := &models.DirectUrlResult{
+		Complete: "/api/storage/v0/upload/complete?" + query,
+	}
+	// 预签名链接指向暂存对象，过期前客户端可以反复写入，完成回调时复制到正式对象后再校验和记录
+	stagingName := DirectStagingName(meta.StorageName)
+	if partNum == 0 {
+		single, err := sto.PresignPutObject(meta.Bucket, stagingName, duration)
+		if err != nil {
+			return err
+		}
+		direct.Single = single
+	} else {
+		uploadId, err := sto.NewMultipartUpload(meta.Bucket, stagingName, DirectContentType(meta.StorageName, ""))
+		if err != nil {
+			return err
+		}
+		for i := 1; i <= partNum; i++ {
+			part, err := sto.PresignUploadPart(meta.Bucket, stagingName, uploadId, i, duration)
+			if err != nil {
+				return err
+			}
+			direct.Parts = append(direct.Parts, part)
+		}
+		direct.UploadId = uploadId
+		meta.UploadID = uploadId
+		meta.PartNum = partNum
+	}
+	resp.Url.Direct = direct
+	return nil
+}
+
+// DirectStagingName 直传暂存对象的名称
+func DirectStagingName(storageName string) string {
+	return utils.DirectStagingPrefix + storageName
+}
+
+// DirectContentType 直传的对象以存储返回的类型为准，缺省时按后缀推断
+func DirectContentType(objectName, contentType string) string {
+	if contentType != "" && contentType != "application/octet-stream" {
+		return contentType
+	}
+	if t := mime.TypeByExtension(filepath.Ext(objectName)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
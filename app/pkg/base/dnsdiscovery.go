@@ -0,0 +1,72 @@
+package base
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qinguoyi/osproxy/bootstrap"
+)
+
+// dnsDiscovery 通过dns查询服务，适用于k8s headless service或SRV记录
+type dnsDiscovery struct {
+	name string
+	srv  bool
+}
+
+func NewDnsDiscovery(name string, srv bool) *dnsDiscovery {
+	return &dnsDiscovery{
+		name: name,
+		srv:  srv,
+	}
+}
+
+// HeartBeat 由dns维护服务列表，不需要注册
+func (s *dnsDiscovery) HeartBeat() {}
+
+// Deregister 服务列表不由当前服务维护，排空期间健康检查返回503，由外部摘除
+func (s *dnsDiscovery) Deregister() error {
+	return nil
+}
+
+// Discovery 查询SRV记录时使用记录中的端口，查询A记录时使用当前服务端口
+// SRV记录的目标为主机名，解析为ip，与元数据中记录的节点ip一致
+func (s *dnsDiscovery) Discovery() ([]*Service, error) {
+	now := time.Now().Unix()
+	if s.srv {
+		_, addrs, err := net.LookupSRV("", "", s.name)
+		if err != nil {
+			return nil, err
+		}
+		resp := make([]*Service, 0, len(addrs))
+		for _, addr := range addrs {
+			ips, err := net.LookupHost(strings.TrimSuffix(addr.Target, "."))
+			if err != nil {
+				return nil, err
+			}
+			for _, ip := range ips {
+				resp = append(resp, &Service{
+					IP:        ip,
+					Port:      strconv.Itoa(int(addr.Port)),
+					CreatedAt: now,
+				})
+			}
+		}
+		return resp, nil
+	}
+	hosts, err := net.LookupHost(s.name)
+	if err != nil {
+		return nil, err
+	}
+	resp := make([]*Service, 0, len(hosts))
+	for _, host := range hosts {
+		resp = append(resp, &Service{IP: host, Port: bootstrap.NewConfig("").App.Port, CreatedAt: now})
+	}
+	return resp, nil
+}
+
+// Get .
+func (s *dnsDiscovery) Get(ip string) (*Service, error) {
+	return findService(s, ip)
+}
@@ -0,0 +1,225 @@
+package base
+
+/*
+下载链接吊销及下载次数限制
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/qinguoyi/osproxy/app/models"
+	"github.com/qinguoyi/osproxy/app/pkg/repo"
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/bootstrap/plugins"
+	"gorm.io/gorm"
+)
+
+// GenDownloadLinks 并发生成下载链接，链接信息落库后返回
+func GenDownloadLinks(metaList []models.MetaDataInfo, expire string, maxDownload int) ([]models.GenDownloadResp, error) {
+	respChan := make(chan models.GenDownloadResp, len(metaList))
+	linkChan := make(chan models.DownloadLink, len(metaList))
+	errChan := make(chan error, len(metaList))
+	var wg sync.WaitGroup
+	for _, meta := range metaList {
+		wg.Add(1)
+		go GenDownloadSingle(meta, expire, maxDownload, respChan, linkChan, errChan, &wg)
+	}
+	wg.Wait()
+	close(respChan)
+	close(linkChan)
+	close(errChan)
+	// 任一链接生成失败则整体失败，不落库
+	if err, ok := <-errChan; ok {
+		return nil, err
+	}
+
+	var linkList []models.DownloadLink
+	for re := range linkChan {
+		linkList = append(linkList, re)
+	}
+	if len(linkList) != 0 {
+		lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+		if err := repo.NewDownloadLinkRepo().BatchCreate(lgDB, &linkList); err != nil {
+			return nil, err
+		}
+	}
+	var resp []models.GenDownloadResp
+	for re := range respChan {
+		resp = append(resp, re)
+	}
+	return resp, nil
+}
+
+// CacheDownloadLink 缓存不限次数的下载链接，相同uid和过期时间直接复用
+func CacheDownloadLink(resp models.GenDownloadResp, expire string) {
+	key := fmt.Sprintf("%s-downloadLink", resp.Uid)
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	lgRedis := new(plugins.LangGoRedis).NewRedis()
+	lgRedis.HSetNX(context.Background(), key, expire, b)
+	lgRedis.Expire(context.Background(), key, 5*60*time.Second)
+}
+
+// GetCachedDownloadLink 查询缓存的下载链接，不存在时返回nil
+func GetCachedDownloadLink(uid int64, expire string) (*models.GenDownloadResp, error) {
+	lgRedis := new(plugins.LangGoRedis).NewRedis()
+	val, err := lgRedis.HGet(context.Background(), fmt.Sprintf("%d-downloadLink", uid), expire).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var msg models.GenDownloadResp
+	if err := json.Unmarshal([]byte(val), &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// getDownloadLink 查询链接信息，优先读redis
+func getDownloadLink(linkId string) (*models.DownloadLink, error) {
+	key := fmt.Sprintf("%s-link", linkId)
+	lgRedis := new(plugins.LangGoRedis).NewRedis()
+	val, err := lgRedis.Get(context.Background(), key).Result()
+	if err == nil {
+		var msg models.DownloadLink
+		if err := json.Unmarshal([]byte(val), &msg); err == nil {
+			return &msg, nil
+		}
+	} else if err != redis.Nil {
+		return nil, err
+	}
+
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	link, err := repo.NewDownloadLinkRepo().GetByLinkID(lgDB, linkId)
+	if err != nil {
+		return nil, err
+	}
+	// 吊销时写入已吊销的链接而不是删除缓存，这里查询到的旧值不会覆盖
+	if b, err := json.Marshal(link); err == nil {
+		lgRedis.SetNX(context.Background(), key, b, 5*60*time.Second)
+	}
+	return link, nil
+}
+
+// cacheRevokedLinks 覆盖写入已吊销的链接缓存
+func cacheRevokedLinks(ctx context.Context, lgRedis *redis.Client, links []models.DownloadLink) error {
+	for _, link := range links {
+		link.Revoked = true
+		b, err := json.Marshal(link)
+		if err != nil {
+			return err
+		}
+		if err := lgRedis.Set(ctx, fmt.Sprintf("%s-link", link.LinkID), b, 5*60*time.Second).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckDownloadLink 校验链接是否被吊销，限制次数的链接原子扣减一次下载次数
+// client为客户端标识，resume为true表示Range不从0开始；只有扣减后窗口内同一客户端的断点续传不再扣减
+// 没有linkId的链接无法吊销，不再接受
+func CheckDownloadLink(uid int64, linkId, client string, resume bool) (bool, string) {
+	if linkId == "" {
+		return false, "下载链接缺少linkId"
+	}
+	link, err := getDownloadLink(linkId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, "下载链接不存在"
+		}
+		return false, "查询下载链接失败"
+	}
+	if link.UID != uid {
+		return false, "下载链接与uid不匹配"
+	}
+	if link.Revoked {
+		return false, "下载链接已被吊销"
+	}
+	if link.MaxDownload <= 0 {
+		return true, ""
+	}
+	lgRedis := new(plugins.LangGoRedis).NewRedis()
+	return chargeDownloadLink(context.Background(), lgRedis, linkId, client, resume, func() bool {
+		// 限制次数的链接以数据库为准，条件更新保证并发下不会超发
+		lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+		return repo.NewDownloadLinkRepo().IncrDownloadCount(lgDB, linkId) != 0
+	})
+}
+
+// chargeDownloadLink 扣减下载次数，扣减成功后记录续传窗口；窗口不随续传延长，窗口外的请求都重新扣减
+func chargeDownloadLink(ctx context.Context, lgRedis *redis.Client, linkId, client string, resume bool,
+	incr func() bool) (bool, string) {
+	key := fmt.Sprintf("%s-%s-downloadResume", linkId, client)
+	if resume {
+		n, err := lgRedis.Exists(ctx, key).Result()
+		if err != nil {
+			return false, "查询下载记录失败"
+		}
+		if n == 1 {
+			return true, ""
+		}
+	}
+	if !incr() {
+		return false, "下载次数已用完"
+	}
+	lgRedis.Set(ctx, key, "1", utils.DownloadResumeWindow)
+	return true, ""
+}
+
+// RevokeDownloadLinks 吊销下载链接，并清理redis中的链接缓存
+func RevokeDownloadLinks(uidList []int64, linkIdList []string) error {
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	lgRedis := new(plugins.LangGoRedis).NewRedis()
+	ctx := context.Background()
+
+	var keys []string
+	if len(uidList) != 0 {
+		links, err := repo.NewDownloadLinkRepo().GetActiveByUidList(lgDB, uidList)
+		if err != nil {
+			return err
+		}
+		if err := repo.NewDownloadLinkRepo().RevokeByUidList(lgDB, uidList); err != nil {
+			return err
+		}
+		if err := cacheRevokedLinks(ctx, lgRedis, links); err != nil {
+			return err
+		}
+		for _, uid := range uidList {
+			keys = append(keys, fmt.Sprintf("%d-downloadLink", uid))
+		}
+	}
+	if len(linkIdList) != 0 {
+		if err := repo.NewDownloadLinkRepo().RevokeByLinkIDList(lgDB, linkIdList); err != nil {
+			return err
+		}
+		for _, linkId := range linkIdList {
+			link, err := repo.NewDownloadLinkRepo().GetByLinkID(lgDB, linkId)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := cacheRevokedLinks(ctx, lgRedis, []models.DownloadLink{*link}); err != nil {
+				return err
+			}
+			// 被吊销的链接可能还在uid的链接缓存中
+			keys = append(keys, fmt.Sprintf("%d-downloadLink", link.UID))
+		}
+	}
+	if len(keys) != 0 {
+		return lgRedis.Del(ctx, keys...).Err()
+	}
+	return nil
+}
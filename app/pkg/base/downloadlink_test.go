@@ -0,0 +1,41 @@
+package base
+
+import (
+	"context"
+	"testing"
+)
+
+func TestChargeDownloadLink(t *testing.T) {
+	_, client := newFakeRedis(t)
+	ctx := context.Background()
+
+	// 只能下载一次的链接
+	remain := 1
+	incr := func() bool {
+		if remain == 0 {
+			return false
+		}
+		remain--
+		return true
+	}
+
+	if ok, msg := chargeDownloadLink(ctx, client, "link", "1.1.1.1", false, incr); !ok {
+		t.Fatalf("Expected first download to be charged, but got %s", msg)
+	}
+	// 同一客户端的断点续传不扣减
+	if ok, msg := chargeDownloadLink(ctx, client, "link", "1.1.1.1", true, incr); !ok {
+		t.Errorf("Expected resume to be allowed, but got %s", msg)
+	}
+	// 次数用完后，其他客户端的Range请求不能绕过扣减
+	if ok, _ := chargeDownloadLink(ctx, client, "link", "2.2.2.2", true, incr); ok {
+		t.Errorf("Expected non-zero range without charged download to be rejected")
+	}
+	// 从头下载需要扣减
+	if ok, _ := chargeDownloadLink(ctx, client, "link", "1.1.1.1", false, incr); ok {
+		t.Errorf("Expected download from offset 0 to be rejected after limit is used up")
+	}
+	// 其他链接的扣减记录不能复用
+	if ok, _ := chargeDownloadLink(ctx, client, "other", "1.1.1.1", true, incr); ok {
+		t.Errorf("Expected resume window to be scoped to the link")
+	}
+}
@@ -39,21 +39,30 @@ func CheckUploadSignature(date, expire, signature string) bool {
 }
 
 // GenDownloadSignature . GenDownloadSignature()函数用于生成下载签名
-func GenDownloadSignature(uid int64, srcName, bucket, objectName, expire, date, signature string) string {
+func GenDownloadSignature(uid int64, srcName, bucket, objectName, expire, date, linkId, signature string) string {
 	standardizedQueryString := fmt.Sprintf(
-		"uid=%d&name=%s&date=%s&expire=%s&bucket=%s&object=%s&signature=%s",
+		"uid=%d&name=%s&date=%s&expire=%s&bucket=%s&object=%s&linkId=%s&signature=%s",
 		uid,
 		srcName,
 		date,
 		expire,
 		bucket,
 		objectName,
+		linkId,
 		signature,
 	)
 	return standardizedQueryString
 }
 
-func CheckDownloadSignature(date, expire, bucket, objectName, signature string) bool {
-	decodeRes := decode(fmt.Sprintf("%s-%s-%s-%s", date, expire, bucket, objectName))
+// downloadSignMessage 下载签名原文，linkId参与签名，避免被篡改或去掉；历史链接没有linkId
+func downloadSignMessage(date, expire, bucket, objectName, linkId string) string {
+	if linkId == "" {
+		return fmt.Sprintf("%s-%s-%s-%s", date, expire, bucket, objectName)
+	}
+	return fmt.Sprintf("%s-%s-%s-%s-%s", date, expire, bucket, objectName, linkId)
+}
+
+func CheckDownloadSignature(date, expire, bucket, objectName, linkId, signature string) bool {
+	decodeRes := decode(downloadSignMessage(date, expire, bucket, objectName, linkId))
 	return decodeRes == signature
 }
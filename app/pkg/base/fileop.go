@@ -71,6 +71,23 @@ func CalculateFileMd5(filename string) (string, error) {
 	return md5Str, nil
 }
 
+// SaveFileWithMd5 流式写入文件，写入的同时计算md5，避免落盘后再读一遍
+func SaveFileWithMd5(filename string, src io.Reader) (int64, string, error) {
+	out, err := os.Create(filename)
+	if err != nil {
+		return 0, "", err
+	}
+	defer func(out *os.File) {
+		_ = out.Close()
+	}(out)
+	hash := md5.New()
+	written, err := io.Copy(out, io.TeeReader(src, hash))
+	if err != nil {
+		return written, "", err
+	}
+	return written, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
 func DetectContentType(fileName string) (string, error) {
 	// 打开文件
 	file, err := os.Open(fileName)
@@ -80,11 +97,14 @@ func DetectContentType(fileName string) (string, error) {
 	defer func(file *os.File) {
 		_ = file.Close()
 	}(file)
+	return DetectReaderContentType(file)
+}
 
-	// 读取文件头部信息
+// DetectReaderContentType 根据数据头部信息判断content-type
+func DetectReaderContentType(src io.Reader) (string, error) {
 	buf := make([]byte, 512)
-	_, err = file.Read(buf)
-	if err != nil {
+	_, err := io.ReadFull(src, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
 		return "", err
 	}
 	contentType := http.DetectContentType(buf)
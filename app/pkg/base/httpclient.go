@@ -29,6 +29,7 @@ type Request struct {
 	HeaderSet map[string]string
 	Method    string
 	Params    map[string]string
+	Peer      bool // 是否为集群内请求，集群内请求携带签名
 }
 
 func init() {
@@ -85,6 +86,9 @@ func Ask(requester Request) (respStatusCode int, respBytes *Response, respHeader
 		}
 		request.URL.RawQuery = params.Encode()
 	}
+	if requester.Peer {
+		SignPeerRequest(request)
+	}
 
 	resp, err := Client.Do(request) // Do()函数用于发送请求
 	// Do()函数的作用是：1.发送请求；2.返回响应 3.返回错误 4.关闭响应的Body
@@ -132,6 +136,9 @@ func AskFile(requester Request) (respStatusCode int, respBytes io.ReadCloser, re
 		}
 		request.URL.RawQuery = params.Encode()
 	}
+	if requester.Peer {
+		SignPeerRequest(request)
+	}
 
 	resp, err := Client.Do(request)
 	if err != nil {
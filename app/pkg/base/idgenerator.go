@@ -0,0 +1,45 @@
+package base
+
+/*
+发号器，支持雪花算法及数据库号段，由配置选择
+*/
+
+import (
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/bootstrap"
+	"github.com/qinguoyi/osproxy/config"
+)
+
+// IdGenerator 发号器
+type IdGenerator interface {
+	NextId() (int64, error)
+}
+
+var idGenerator IdGenerator
+
+// InitIdGenerator 根据配置初始化发号器
+func InitIdGenerator(conf *config.Configuration) {
+	switch conf.Id.Mode {
+	case utils.IdSegment:
+		if err := InitUidBiz(conf.Id.BizId, conf.Id.Step); err != nil {
+			panic(err)
+		}
+		uid, err := NewUid(conf.Id.BizId)
+		if err != nil {
+			panic(err)
+		}
+		idGenerator = uid
+		bootstrap.NewLogger().Logger.Info("当前使用的发号方式：号段")
+	default:
+		idGenerator = InitSnowFlake()
+		bootstrap.NewLogger().Logger.Info("当前使用的发号方式：雪花算法")
+	}
+}
+
+// NewIdGenerator 未初始化时使用默认的雪花算法
+func NewIdGenerator() IdGenerator {
+	if idGenerator == nil {
+		return NewSnowFlake()
+	}
+	return idGenerator
+}
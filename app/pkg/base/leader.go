@@ -0,0 +1,178 @@
+package base
+
+/*
+基于redis锁的选主，主节点执行集群内只需运行一次的任务
+主节点定期续期，宕机后锁过期由其他服务接替；每次当选生成递增的fencing token，
+任务写入前校验token，防止失去主节点身份的服务继续写入
+*/
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/bootstrap"
+	"github.com/qinguoyi/osproxy/bootstrap/plugins"
+	"go.uber.org/zap"
+)
+
+// SingletonJob 只在主节点运行的任务
+type SingletonJob struct {
+	Name     string
+	Interval time.Duration
+	// Run 主节点身份失去时ctx取消，token用于写入前校验
+	Run func(ctx context.Context, token int64) error
+}
+
+var (
+	singletonJobs []SingletonJob
+	leaderOnce    sync.Once
+	leader        *Leader
+)
+
+// RegSingleton 注册只在主节点运行的任务，需要在选主启动前注册
+func RegSingleton(job SingletonJob) {
+	singletonJobs = append(singletonJobs, job)
+}
+
+// Leader 选主
+type Leader struct {
+	ctx     context.Context
+	store   *redis.Client
+	lock    *RedisLock
+	token   int64 // 当选时的fencing token，不是主节点时为0
+	cancel  context.CancelFunc
+	jobWg   sync.WaitGroup
+	resign  chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	running int32
+}
+
+// NewLeader 当前服务的选主，全局只有一个
+func NewLeader() *Leader {
+	leaderOnce.Do(func() {
+		ctx := context.Background()
+		store := new(plugins.LangGoRedis).NewRedis()
+		lock := NewRedisLock(&ctx, store, utils.LeaderRedisKey)
+		lock.SetExpire(utils.LeaderLease)
+		leader = &Leader{
+			ctx:    ctx,
+			store:  store,
+			lock:   lock,
+			resign: make(chan struct{}),
+			done:   make(chan struct{}),
+		}
+	})
+	return leader
+}
+
+// IsLeader 当前服务是否为主节点
+func (l *Leader) IsLeader() bool {
+	return atomic.LoadInt64(&l.token) != 0
+}
+
+// Token 当前服务当选时的fencing token，不是主节点时为0
+func (l *Leader) Token() int64 {
+	return atomic.LoadInt64(&l.token)
+}
+
+// Run 竞选主节点，已是主节点时续期，直到Resign
+func (l *Leader) Run() {
+	atomic.StoreInt32(&l.running, 1)
+	defer close(l.done)
+	ticker := time.NewTicker(utils.LeaderRenewInterval)
+	defer ticker.Stop()
+	for {
+		l.campaign()
+		select {
+		case <-l.resign:
+			l.stepDown("主动退出")
+			_, _ = l.lock.Release()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Resign 退出选主，是主节点时停止任务并释放锁
+func (l *Leader) Resign() {
+	l.once.Do(func() {
+		close(l.resign)
+	})
+	if atomic.LoadInt32(&l.running) == 1 {
+		<-l.done
+	}
+}
+
+// campaign 加锁成功即当选或续期成功，失败时主节点退位
+func (l *Leader) campaign() {
+	ok, err := l.lock.Acquire()
+	if err != nil || !ok {
+		if l.IsLeader() {
+			l.stepDown("续期失败")
+		}
+		return
+	}
+	if l.IsLeader() {
+		return
+	}
+	token, err := l.store.Incr(l.ctx, utils.LeaderTokenRedisKey).Result()
+	if err != nil {
+		bootstrap.NewLogger().Logger.Error("生成fencing token失败", zap.Any("err", err.Error()))
+		_, _ = l.lock.Release()
+		return
+	}
+	atomic.StoreInt64(&l.token, token)
+	bootstrap.NewLogger().Logger.Info(fmt.Sprintf("当前服务当选主节点，token:%d", token))
+
+	ctx, cancel := context.WithCancel(l.ctx)
+	l.cancel = cancel
+	for _, job := range singletonJobs {
+		l.jobWg.Add(1)
+		go l.runJob(ctx, job, token)
+	}
+}
+
+// stepDown 停止主节点任务并等待退出
+func (l *Leader) stepDown(reason string) {
+	if !l.IsLeader() {
+		return
+	}
+	atomic.StoreInt64(&l.token, 0)
+	l.cancel()
+	l.jobWg.Wait()
+	bootstrap.NewLogger().Logger.Info(fmt.Sprintf("当前服务不再是主节点，原因：%s", reason))
+}
+
+func (l *Leader) runJob(ctx context.Context, job SingletonJob, token int64) {
+	defer l.jobWg.Done()
+	timer := time.NewTimer(1 * time.Nanosecond)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if err := job.Run(ctx, token); err != nil {
+				bootstrap.NewLogger().Logger.Error(fmt.Sprintf("主节点任务%s执行失败", job.Name),
+					zap.Any("err", err.Error()))
+			}
+			timer.Reset(job.Interval)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// CheckFencing 校验token是否为最新一次当选生成，过期的主节点不能继续写入
+func CheckFencing(ctx context.Context, store *redis.Client, token int64) (bool, error) {
+	current, err := store.Get(ctx, utils.LeaderTokenRedisKey).Result()
+	if err != nil {
+		return false, err
+	}
+	return current == strconv.FormatInt(token, 10), nil
+}
@@ -0,0 +1,41 @@
+package base
+
+/*
+合并进度：合并任务按已合并的分片更新进度，查询上传状态时读取
+*/
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/bootstrap/plugins"
+)
+
+func mergeProgressKey(uid int64) string {
+	return fmt.Sprintf("%d-mergeProgress", uid)
+}
+
+// SetMergeProgress 更新合并进度，0-100
+func SetMergeProgress(uid int64, percent int) {
+	lgRedis := new(plugins.LangGoRedis).NewRedis()
+	lgRedis.Set(context.Background(), mergeProgressKey(uid), percent, utils.MergeProgressExpire)
+}
+
+// GetMergeProgress 未开始合并或查询失败时返回0
+func GetMergeProgress(uid int64) int {
+	lgRedis := new(plugins.LangGoRedis).NewRedis()
+	val, err := lgRedis.Get(context.Background(), mergeProgressKey(uid)).Result()
+	if err != nil {
+		return 0
+	}
+	percent, _ := strconv.Atoi(val)
+	return percent
+}
+
+// DelMergeProgress 合并完成后删除
+func DelMergeProgress(uid int64) {
+	lgRedis := new(plugins.LangGoRedis).NewRedis()
+	lgRedis.Del(context.Background(), mergeProgressKey(uid))
+}
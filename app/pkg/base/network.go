@@ -2,9 +2,7 @@ package base
 
 import (
 	"errors"
-	"io/ioutil"
 	"net"
-	"net/http"
 )
 
 // GetClientIp 获取本地网卡ip
@@ -27,28 +25,3 @@ func GetClientIp() (string, error) {
 
 	return "", errors.New("can not find the client ip address")
 }
-
-// GetOutBoundIP 获取外网ip
-func GetOutBoundIP() (string, error) {
-	//向查询IP的网站发送GET请求
-	resp, err := http.Get("http://myexternalip.com/raw")
-	if err != nil {
-		panic(err)
-	}
-	defer resp.Body.Close()
-
-	//读取响应的内容
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		panic(err)
-	}
-	return string(body), nil
-
-	//conn, err := net.Dial("udp", "8.8.8.8:53")
-	//if err != nil {
-	//	return "", err
-	//}
-	//localAddr := conn.LocalAddr().(*net.UDPAddr)
-	//ip := strings.Split(localAddr.String(), ":")[0]
-	//return ip, nil
-}
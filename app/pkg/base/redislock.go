@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"math/rand"
 	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -28,10 +29,19 @@ const (
                   else
                       return 0
                   end` // lua脚本，用于释放锁
+	renewCommand = `if redis.call("GET", KEYS[1]) == ARGV[1] then
+                      return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+                  else
+                      return 0
+                  end` // lua脚本，用于续期
 	randomLen = 16
-	// 默认超时时间，防止死锁
+	// 未调用SetExpire时的过期时间，持有期间自动续期
+	defaultExpire   = 30  // seconds
 	tolerance       = 500 // milliseconds
 	millisPerSecond = 1000
+	// 阻塞加锁的退避时间
+	minBackoff = 50 * time.Millisecond
+	maxBackoff = time.Second
 )
 
 // A RedisLock is a redis lock.
@@ -45,6 +55,13 @@ type RedisLock struct {
 	key string
 	// 锁value，防止锁被别人获取到
 	id string
+	mu sync.Mutex
+	// 续期协程的停止信号，为nil时没有续期
+	stop chan struct{}
+	// 续期时发现锁已过期或被别人获取
+	lost int32
+	// 最近一次成功加锁或续期发出请求的时间，unix纳秒，续期持续失败时据此判断锁已过期
+	renewedAt int64
 }
 
 func init() {
@@ -65,13 +82,42 @@ func NewRedisLock(c *context.Context, store *redis.Client, key string) *RedisLoc
 }
 
 // Acquire acquires the lock.
-// 加锁
+// 非阻塞加锁，成功后后台自动续期，直到Release或ctx取消
 func (rl *RedisLock) Acquire() (bool, error) {
-	// 获取过期时间
-	seconds := atomic.LoadUint32(&rl.seconds)
-	// 默认锁过期时间为500ms，防止死锁
+	ok, err := rl.acquire()
+	if ok {
+		rl.startRenew()
+	}
+	return ok, err
+}
+
+// AcquireWithTimeout 阻塞加锁，按指数退避重试直到成功或ctx超时取消，成功后后台自动续期
+// 超时或取消时返回ctx.Err()，调用方据此区分锁被占用与其他错误
+func (rl *RedisLock) AcquireWithTimeout(ctx context.Context) (bool, error) {
+	backoff := minBackoff
+	for {
+		ok, _ := rl.acquire()
+		if ok {
+			rl.startRenew()
+			return true, nil
+		}
+		wait := backoff + time.Duration(rand.Int63n(int64(backoff)))
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (rl *RedisLock) acquire() (bool, error) {
+	start := time.Now()
 	res := rl.store.Eval(*rl.ctx, lockCommand, []string{rl.key}, []string{ // Eval()函数用于执行lua脚本
-		rl.id, strconv.Itoa(int(seconds)*millisPerSecond + tolerance),
+		rl.id, strconv.FormatInt(rl.expire().Milliseconds(), 10),
 	})
 	resp, err := res.Result()
 	if err == redis.Nil {
@@ -86,6 +132,7 @@ func (rl *RedisLock) Acquire() (bool, error) {
 
 	reply, ok := (resp).(string)
 	if ok && reply == "OK" {
+		atomic.StoreInt64(&rl.renewedAt, start.UnixNano())
 		return true, nil
 	} else {
 		fmt.Printf("Unknown reply when acquiring lock for %s: %v", rl.key, resp)
@@ -93,10 +140,81 @@ func (rl *RedisLock) Acquire() (bool, error) {
 	}
 }
 
+// startRenew 启动续期协程，已在续期时只重置失效标记
+func (rl *RedisLock) startRenew() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	atomic.StoreInt32(&rl.lost, 0)
+	if rl.stop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	rl.stop = stop
+	go rl.renew(stop)
+}
+
+// renew 每隔三分之一过期时间续期一次，锁已失去或ctx取消时退出
+func (rl *RedisLock) renew(stop chan struct{}) {
+	defer func() {
+		rl.mu.Lock()
+		if rl.stop == stop {
+			rl.stop = nil
+		}
+		rl.mu.Unlock()
+	}()
+	ticker := time.NewTicker(rl.expire() / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-(*rl.ctx).Done():
+			return
+		case <-ticker.C:
+			start := time.Now()
+			resp, err := rl.store.Eval(*rl.ctx, renewCommand, []string{rl.key}, []string{
+				rl.id, strconv.FormatInt(rl.expire().Milliseconds(), 10),
+			}).Result()
+			if err != nil {
+				// 网络异常时等待下次续期，过期前仍有机会续上
+				continue
+			}
+			if reply, ok := resp.(int64); !ok || reply != 1 {
+				atomic.StoreInt32(&rl.lost, 1)
+				return
+			}
+			atomic.StoreInt64(&rl.renewedAt, start.UnixNano())
+		}
+	}
+}
+
+// Lost 持有期间锁是否已过期或被别人获取，写入前可据此放弃
+func (rl *RedisLock) Lost() bool {
+	return atomic.LoadInt32(&rl.lost) == 1
+}
+
+// Held 锁是否仍然有效：未失去，且距最近一次成功加锁或续期未超过过期时间
+// 续期因网络异常持续失败时Lost仍为false，锁在redis中可能已过期，需要据此判断
+func (rl *RedisLock) Held() bool {
+	if rl.Lost() {
+		return false
+	}
+	renewedAt := atomic.LoadInt64(&rl.renewedAt)
+	return renewedAt != 0 && time.Since(time.Unix(0, renewedAt)) < rl.expire()-tolerance*time.Millisecond
+}
+
 // Release releases the lock.
-// 释放锁
+// 释放锁并停止续期，返回false表示释放前锁已过期或被别人获取
 func (rl *RedisLock) Release() (bool, error) {
-	res := rl.store.Eval(*rl.ctx, delCommand, []string{rl.key}, []string{rl.id})
+	rl.mu.Lock()
+	if rl.stop != nil {
+		close(rl.stop)
+		rl.stop = nil
+	}
+	rl.mu.Unlock()
+
+	// 请求取消后仍需释放，不使用加锁时的ctx
+	res := rl.store.Eval(context.Background(), delCommand, []string{rl.key}, []string{rl.id})
 	resp, err := res.Result()
 	if err != nil {
 		return false, err
@@ -106,17 +224,26 @@ func (rl *RedisLock) Release() (bool, error) {
 	if !ok {
 		return false, nil
 	} else {
-		return reply == 1, nil
+		return reply == 1 && !rl.Lost(), nil
 	}
 }
 
 // SetExpire sets the expire.
 // 需要注意的是需要在Acquire()之前调用
-// 不然默认为500ms自动释放
+// 不然默认为30s，持有期间自动续期
 func (rl *RedisLock) SetExpire(seconds int) {
 	atomic.StoreUint32(&rl.seconds, uint32(seconds))
 }
 
+// expire 锁的过期时间
+func (rl *RedisLock) expire() time.Duration {
+	seconds := atomic.LoadUint32(&rl.seconds)
+	if seconds == 0 {
+		seconds = defaultExpire
+	}
+	return time.Duration(int(seconds)*millisPerSecond+tolerance) * time.Millisecond
+}
+
 func randomStr(n int) string {
 	b := make([]byte, n)
 	for i := range b {
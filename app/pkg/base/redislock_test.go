@@ -0,0 +1,277 @@
+package base
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// fakeRedis 只支持锁使用的三个lua脚本及SET、EXISTS
+type fakeRedis struct {
+	mu       sync.Mutex
+	values   map[string]string
+	expireAt map[string]time.Time
+	// 为1时所有命令返回错误，模拟网络异常
+	down int32
+}
+
+func newFakeRedis(t *testing.T) (*fakeRedis, *redis.Client) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &fakeRedis{values: map[string]string{}, expireAt: map[string]time.Time{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	client := redis.NewClient(&redis.Options{Addr: ln.Addr().String()})
+	t.Cleanup(func() { client.Close() })
+	return s, client
+}
+
+func (s *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		if _, err := conn.Write([]byte(s.exec(args))); err != nil {
+			return
+		}
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	var n int
+	if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		var size int
+		if _, err := fmt.Fscanf(r, "$%d\r\n", &size); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+// exec EVAL的参数依次为EVAL、脚本、key数量、key、value、过期时间
+func (s *fakeRedis) exec(args []string) string {
+	if atomic.LoadInt32(&s.down) == 1 {
+		return "-ERR unavailable\r\n"
+	}
+	switch strings.ToLower(args[0]) {
+	case "set":
+		// SET key value ex seconds [nx]
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.get(args[1]); ok && len(args) > 5 && strings.ToLower(args[5]) == "nx" {
+			return "$-1\r\n"
+		}
+		seconds, _ := strconv.Atoi(args[4])
+		s.set(args[1], args[2], strconv.Itoa(seconds*1000))
+		return "+OK\r\n"
+	case "exists":
+		if _, ok := s.Get(args[1]); ok {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	}
+	if len(args) < 5 || strings.ToLower(args[0]) != "eval" {
+		return "-ERR unsupported\r\n"
+	}
+	script, key, id := args[1], args[3], args[4]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.get(key)
+	owned := ok && value == id
+	switch script {
+	case lockCommand:
+		if ok && !owned {
+			return "$-1\r\n"
+		}
+		s.set(key, id, args[5])
+		return "+OK\r\n"
+	case renewCommand:
+		if !owned {
+			return ":0\r\n"
+		}
+		s.set(key, id, args[5])
+		return ":1\r\n"
+	case delCommand:
+		if !owned {
+			return ":0\r\n"
+		}
+		delete(s.values, key)
+		return ":1\r\n"
+	}
+	return "-ERR unknown script\r\n"
+}
+
+func (s *fakeRedis) get(key string) (string, bool) {
+	value, ok := s.values[key]
+	if ok && time.Now().After(s.expireAt[key]) {
+		delete(s.values, key)
+		return "", false
+	}
+	return value, ok
+}
+
+func (s *fakeRedis) set(key, value, px string) {
+	ms, _ := strconv.Atoi(px)
+	s.values[key] = value
+	s.expireAt[key] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+}
+
+// Put 模拟锁过期后被别人获取
+func (s *fakeRedis) Put(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set(key, value, "60000")
+}
+
+func (s *fakeRedis) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(key)
+}
+
+func TestRedisLockRenew(t *testing.T) {
+	store, client := newFakeRedis(t)
+	ctx := context.Background()
+
+	lock := NewRedisLock(&ctx, client, "renew-lock")
+	lock.SetExpire(1)
+	ok, err := lock.Acquire()
+	if err != nil || !ok {
+		t.Fatalf("Expected lock to be acquired, but got %v %v", ok, err)
+	}
+
+	// 超过过期时间后仍持有
+	time.Sleep(2500 * time.Millisecond)
+	if value, ok := store.Get("renew-lock"); !ok || value != lock.id {
+		t.Fatalf("Expected lock to be renewed, but got %q %v", value, ok)
+	}
+	if lock.Lost() {
+		t.Errorf("Expected lock not to be lost")
+	}
+	other := NewRedisLock(&ctx, client, "renew-lock")
+	if ok, _ := other.Acquire(); ok {
+		t.Errorf("Expected renewed lock to block others")
+	}
+
+	if ok, err := lock.Release(); err != nil || !ok {
+		t.Errorf("Expected release to succeed, but got %v %v", ok, err)
+	}
+	if _, ok := store.Get("renew-lock"); ok {
+		t.Errorf("Expected lock to be deleted after release")
+	}
+}
+
+func TestRedisLockLost(t *testing.T) {
+	store, client := newFakeRedis(t)
+	ctx := context.Background()
+
+	lock := NewRedisLock(&ctx, client, "lost-lock")
+	lock.SetExpire(1)
+	if ok, err := lock.Acquire(); err != nil || !ok {
+		t.Fatalf("Expected lock to be acquired, but got %v %v", ok, err)
+	}
+
+	store.Put("lost-lock", "someone-else")
+	time.Sleep(time.Second)
+	if !lock.Lost() {
+		t.Fatalf("Expected lock to be lost after another owner took it")
+	}
+	if ok, err := lock.Release(); err != nil || ok {
+		t.Errorf("Expected release to report lost lock, but got %v %v", ok, err)
+	}
+	if value, _ := store.Get("lost-lock"); value != "someone-else" {
+		t.Errorf("Expected other owner's lock to be kept, but got %q", value)
+	}
+
+	// 重新获取后清除失效标记
+	store.mu.Lock()
+	delete(store.values, "lost-lock")
+	store.mu.Unlock()
+	if ok, _ := lock.Acquire(); !ok || lock.Lost() {
+		t.Errorf("Expected reacquired lock to be held")
+	}
+	lock.Release()
+}
+
+func TestRedisLockAcquireTimeout(t *testing.T) {
+	store, client := newFakeRedis(t)
+	ctx := context.Background()
+	store.Put("busy-lock", "someone-else")
+
+	lock := NewRedisLock(&ctx, client, "busy-lock")
+	timeout, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	ok, err := lock.AcquireWithTimeout(timeout)
+	if ok || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected busy lock to time out, but got %v %v", ok, err)
+	}
+}
+
+func TestRedisLockRenewFailing(t *testing.T) {
+	store, client := newFakeRedis(t)
+	ctx := context.Background()
+
+	lock := NewRedisLock(&ctx, client, "worker-lease")
+	lock.SetExpire(1)
+	if ok, err := lock.Acquire(); err != nil || !ok {
+		t.Fatalf("Expected lock to be acquired, but got %v %v", ok, err)
+	}
+	sf, _ := newSnowFlake(1, 1)
+	sf.lease = lock
+	if _, err := sf.NextId(); err != nil {
+		t.Fatalf("Expected id while lease is held, but got %v", err)
+	}
+
+	// 续期持续失败时Lost仍为false，超过租期后不再发号
+	atomic.StoreInt32(&store.down, 1)
+	time.Sleep(1200 * time.Millisecond)
+	if lock.Lost() {
+		t.Errorf("Expected failed renewals not to mark the lock lost")
+	}
+	if lock.Held() {
+		t.Errorf("Expected lock not to be held after a lease without renewal")
+	}
+	if _, err := sf.NextId(); err == nil {
+		t.Errorf("Expected NextId to refuse with a stale lease")
+	}
+
+	// 恢复后重新租用成功即可发号
+	atomic.StoreInt32(&store.down, 0)
+	if _, err := sf.NextId(); err != nil {
+		t.Errorf("Expected id after lease is reacquired, but got %v", err)
+	}
+	lock.Release()
+}
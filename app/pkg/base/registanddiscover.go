@@ -5,7 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -16,6 +19,43 @@ import (
 
 // 这个文件的作用是：1.服务注册；2.心跳检测；3.服务发现，主打一个分布式的不同服务器的打招呼
 
+// Discovery 服务发现，支持redis心跳注册、固定服务列表及dns查询
+type Discovery interface {
+	// HeartBeat 注册当前服务并定期上报心跳，不需要注册的实现直接返回
+	HeartBeat()
+
+	// Discovery 获取存活的服务
+	Discovery() ([]*Service, error)
+
+	// Get 获取指定服务，不存在或已下线时返回nil
+	Get(string) (*Service, error)
+
+	// Deregister 当前服务进入排空，不再出现在服务列表中，已记录在当前服务的数据仍可通过Get定位
+	Deregister() error
+}
+
+func init() {
+	RegSingleton(SingletonJob{
+		Name:     "cleanStaleService",
+		Interval: utils.ServiceRedisTTl,
+		Run:      cleanStaleService,
+	})
+}
+
+// NewDiscovery 根据配置创建服务发现
+func NewDiscovery() Discovery {
+	conf := bootstrap.NewConfig("").Discovery
+	switch conf.Mode {
+	case utils.DiscoveryStatic:
+		return NewStaticDiscovery(conf.Peers)
+	case utils.DiscoveryDns:
+		return NewDnsDiscovery(conf.DnsName, conf.DnsSrv)
+	default:
+		return NewServiceRegister()
+	}
+}
+
+// serviceRegister redis服务发现，服务定期上报心跳到redis hash
 type serviceRegister struct {
 	client *redis.Client
 }
@@ -31,17 +71,73 @@ type Service struct {
 	IP        string
 	Port      string
 	CreatedAt int64 // 创建时间
+	Draining  bool  // 排空中，不再分配新的数据
+}
+
+// draining 当前服务是否已摘除注册
+var draining int32
+
+var (
+	localNodeOnce sync.Once
+	localNodeID   string
+	localNodePort string
+	localNodeErr  error
+)
+
+// LocalNode 当前服务的ip及端口，优先使用配置的advertise_addr，未配置时使用本地网卡ip，只获取一次
+// 服务发现的结果均为ip，advertise_addr为主机名时在启动时解析为ip，否则无法与发现的服务对应
+func LocalNode() (string, string, error) {
+	localNodeOnce.Do(func() {
+		conf := bootstrap.NewConfig("").App
+		localNodePort = conf.Port
+		if conf.AdvertiseAddr == "" {
+			localNodeID, localNodeErr = GetClientIp()
+			return
+		}
+		host := conf.AdvertiseAddr
+		if h, port, err := net.SplitHostPort(conf.AdvertiseAddr); err == nil {
+			host, localNodePort = h, port
+		}
+		localNodeID, localNodeErr = resolveAdvertiseHost(host)
+	})
+	return localNodeID, localNodePort, localNodeErr
+}
+
+// resolveAdvertiseHost 将advertise_addr中的主机名解析为ip，优先使用ipv4
+func resolveAdvertiseHost(host string) (string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return "", fmt.Errorf("解析advertise_addr %s失败：%w", host, err)
+	}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip.String(), nil
+		}
+	}
+	if len(ips) == 0 {
+		return "", fmt.Errorf("advertise_addr %s没有对应的ip", host)
+	}
+	return ips[0].String(), nil
+}
+
+// LocalNodeID 当前服务在注册中心的标识，即注册使用的ip
+func LocalNodeID() (string, error) {
+	ip, _, err := LocalNode()
+	return ip, err
 }
 
 // Register 服务注册
 func (s *serviceRegister) Register() {
-	ip, err := GetOutBoundIP()
+	ip, port, err := LocalNode()
 	if err != nil {
 		panic(err)
 	}
 	jsonByte, err := json.Marshal(Service{
 		IP:        ip,
-		Port:      bootstrap.NewConfig("").App.Port,
+		Port:      port,
 		CreatedAt: time.Now().Unix(),
 	})
 	if err != nil {
@@ -53,12 +149,12 @@ func (s *serviceRegister) Register() {
 	}
 }
 
-// HeartBeat 心跳检测
+// HeartBeat 心跳检测，排空后不再检查健康状态，只上报排空标记
 func (s *serviceRegister) HeartBeat() {
 	timer := time.NewTimer(1 * time.Nanosecond)
 	defer timer.Stop()
 
-	ip, err := GetClientIp()
+	ip, port, err := LocalNode()
 	bootstrap.NewLogger().Logger.Info(fmt.Sprintf("当前上报ip:%s", ip))
 	if err != nil {
 		panic(err)
@@ -66,9 +162,13 @@ func (s *serviceRegister) HeartBeat() {
 	for {
 		select {
 		case <-timer.C:
+			if atomic.LoadInt32(&draining) == 1 {
+				s.report(ip, port)
+				break
+			}
 			urlStr := "/api/storage/v0/health"
 			req := Request{
-				Url: fmt.Sprintf("%s://%s:%s%s", utils.Scheme, "127.0.0.1", bootstrap.NewConfig("").App.Port,
+				Url: fmt.Sprintf("%s://%s:%s%s", PeerScheme(), "127.0.0.1", bootstrap.NewConfig("").App.Port,
 					urlStr),
 				Body:   io.NopCloser(strings.NewReader("")),
 				Method: "GET",
@@ -76,25 +176,15 @@ func (s *serviceRegister) HeartBeat() {
 			}
 			_, _, _, err := Ask(req)
 			if err == nil {
-				jsonByte, _ := json.Marshal(Service{
-					IP:        ip,
-					Port:      bootstrap.NewConfig("").App.Port,
-					CreatedAt: time.Now().Unix(),
-				})
-				s.client.HSet(context.Background(), utils.ServiceRedisPrefix, ip, jsonByte)
+				s.report(ip, port)
 			} else {
 				bootstrap.NewLogger().Logger.Error(fmt.Sprintf("注册失败:%s", err.Error()))
-				for {
+				for atomic.LoadInt32(&draining) == 0 {
 					_, _, _, err := Ask(req)
 					if err != nil {
 						bootstrap.NewLogger().Logger.Error(fmt.Sprintf("注册失败:%s", err.Error()))
 					} else {
-						jsonByte, _ := json.Marshal(Service{
-							IP:        ip,
-							Port:      bootstrap.NewConfig("").App.Port,
-							CreatedAt: time.Now().Unix(),
-						})
-						s.client.HSet(context.Background(), utils.ServiceRedisPrefix, ip, jsonByte)
+						s.report(ip, port)
 						break
 					}
 					time.Sleep(3 * time.Second)
@@ -106,6 +196,30 @@ func (s *serviceRegister) HeartBeat() {
 	}
 }
 
+// report 上报心跳
+func (s *serviceRegister) report(ip, port string) error {
+	jsonByte, err := json.Marshal(Service{
+		IP:        ip,
+		Port:      port,
+		CreatedAt: time.Now().Unix(),
+		Draining:  atomic.LoadInt32(&draining) == 1,
+	})
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(context.Background(), utils.ServiceRedisPrefix, ip, jsonByte).Err()
+}
+
+// Deregister 上报排空标记，服务发现不再返回当前服务，心跳继续上报以便数据迁移前仍可定位
+func (s *serviceRegister) Deregister() error {
+	ip, port, err := LocalNode()
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt32(&draining, 1)
+	return s.report(ip, port)
+}
+
 // Discovery 服务发现
 func (s *serviceRegister) Discovery() ([]*Service, error) {
 	result := s.client.HGetAll(context.Background(), utils.ServiceRedisPrefix) // HGetAll()函数用于获取redis中的所有数据
@@ -120,18 +234,74 @@ func (s *serviceRegister) Discovery() ([]*Service, error) {
 		return nil, err
 	}
 	resp := make([]*Service, 0)
-	boundary := time.Now().Add(-5 * time.Minute).Unix() // 5分钟之前的数据不要
+	boundary := time.Now().Add(-staleDuration()).Unix() // 超时未上报心跳的数据不要
 	for _, value := range arr {
 		var ser *Service
 		err := json.Unmarshal([]byte(value), &ser)
 		if err != nil {
 			return nil, err
 		}
-		if ser.CreatedAt < boundary {
-			_ = s.client.HDel(context.Background(), utils.ServiceRedisPrefix, ser.IP).Err()
+		if ser.CreatedAt < boundary { // 由主节点定期清理
+			continue
+		}
+		if ser.Draining {
 			continue
 		}
 		resp = append(resp, ser)
 	}
 	return resp, nil
 }
+
+// Get 获取指定服务，不存在或已过期时返回nil
+func (s *serviceRegister) Get(ip string) (*Service, error) {
+	value, err := s.client.HGet(context.Background(), utils.ServiceRedisPrefix, ip).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ser *Service
+	if err := json.Unmarshal([]byte(value), &ser); err != nil {
+		return nil, err
+	}
+	if ser.CreatedAt < time.Now().Add(-staleDuration()).Unix() {
+		return nil, nil
+	}
+	return ser, nil
+}
+
+// staleDuration 超过该时间未上报心跳的服务视为下线，默认5分钟
+func staleDuration() time.Duration {
+	if stale := bootstrap.NewConfig("").Discovery.Stale; stale > 0 {
+		return time.Duration(stale) * time.Second
+	}
+	return 5 * time.Minute
+}
+
+// cleanStaleService 清理超时未上报心跳的服务，只在主节点执行
+func cleanStaleService(ctx context.Context, token int64) error {
+	if mode := bootstrap.NewConfig("").Discovery.Mode; mode != "" && mode != utils.DiscoveryRedis {
+		return nil
+	}
+	client := new(plugins.LangGoRedis).NewRedis()
+	arr, err := client.HGetAll(ctx, utils.ServiceRedisPrefix).Result()
+	if err != nil {
+		return err
+	}
+	boundary := time.Now().Add(-staleDuration()).Unix()
+	for ip, value := range arr {
+		var ser *Service
+		if err := json.Unmarshal([]byte(value), &ser); err != nil || ser.CreatedAt >= boundary {
+			continue
+		}
+		if ok, err := CheckFencing(ctx, client, token); err != nil || !ok {
+			return fmt.Errorf("fencing token %d已过期", token)
+		}
+		if err := client.HDel(ctx, utils.ServiceRedisPrefix, ip).Err(); err != nil {
+			return err
+		}
+		bootstrap.NewLogger().Logger.Info(fmt.Sprintf("清理下线服务:%s", ip))
+	}
+	return nil
+}
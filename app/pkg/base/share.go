@@ -0,0 +1,87 @@
+package base
+
+/*
+分享链接：分享码、访问密码、访问日志签名
+*/
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/bootstrap/plugins"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const shareCodeAlphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// GenShareCode 生成随机分享码
+func GenShareCode() (string, error) {
+	code := make([]byte, utils.ShareCodeLength)
+	max := big.NewInt(int64(len(shareCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = shareCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// HashSharePassword 密码哈希后存储，空密码表示无需密码
+func HashSharePassword(password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+	b, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// CheckSharePassword 校验访问密码
+func CheckSharePassword(passwordHash, password string) bool {
+	if passwordHash == "" {
+		return true
+	}
+	return bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) == nil
+}
+
+// GenShareLogSignature 创建分享时返回给分享者，查询访问日志时校验
+func GenShareLogSignature(code string) string {
+	return decode(fmt.Sprintf("share-log-%s", code))
+}
+
+// CheckShareLogSignature .
+func CheckShareLogSignature(code, signature string) bool {
+	return hmac.Equal([]byte(GenShareLogSignature(code)), []byte(signature))
+}
+
+func sharePasswordFailKeys(code, ip string) (string, string) {
+	return fmt.Sprintf("%s-%s-sharePasswordFail", code, ip), fmt.Sprintf("%s-sharePasswordFail", code)
+}
+
+// SharePasswordLimited 窗口内密码错误次数是否已达上限，查询失败时不限制
+func SharePasswordLimited(code, ip string) bool {
+	ipKey, codeKey := sharePasswordFailKeys(code, ip)
+	lgRedis := new(plugins.LangGoRedis).NewRedis()
+	ipCount, _ := lgRedis.Get(context.Background(), ipKey).Int()
+	codeCount, _ := lgRedis.Get(context.Background(), codeKey).Int()
+	return ipCount >= utils.SharePasswordFailPerIP || codeCount >= utils.SharePasswordFailPerCode
+}
+
+// SharePasswordFailed 记录一次密码错误，首次错误时开始计时
+func SharePasswordFailed(code, ip string) {
+	ipKey, codeKey := sharePasswordFailKeys(code, ip)
+	lgRedis := new(plugins.LangGoRedis).NewRedis()
+	for _, key := range []string{ipKey, codeKey} {
+		if n, err := lgRedis.Incr(context.Background(), key).Result(); err == nil && n == 1 {
+			lgRedis.Expire(context.Background(), key, utils.SharePasswordFailWindow)
+		}
+	}
+}
@@ -5,13 +5,15 @@ package base
 */
 
 import (
-	"context" // context包用于传递请求的上下文，它允许在处理链中传递请求作用域、取消信号和截止时间
-	"errors"  // errors包实现了创建错误值的函数
-	"strconv" // strconv包实现了基本数据类型和其字符串表示的相互转换
-	"sync"    // sync包提供了基本的同步基元，如互斥锁
-	"time"    // time包提供了时间的显示和测量用的函数，日历的计算采用的是公历
+	"context"   // context包用于传递请求的上下文，它允许在处理链中传递请求作用域、取消信号和截止时间
+	"errors"    // errors包实现了创建错误值的函数
+	"fmt"       // fmt包实现了格式化I/O
+	"math/rand" // rand包实现了伪随机数生成
+	"sync"      // sync包提供了基本的同步基元，如互斥锁
+	"time"      // time包提供了时间的显示和测量用的函数，日历的计算采用的是公历
 
 	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/bootstrap"
 	"github.com/qinguoyi/osproxy/bootstrap/plugins"
 )
 
@@ -35,50 +37,44 @@ const (
 
 type Snowflake struct {
 	mu            sync.Mutex
-	lastTimestamp int64 // 上一次生成ID的时间戳
-	workerId      int64 // 机器ID
-	datacenterId  int64 // 数据中心ID
-	sequence      int64 // 序列号
+	lastTimestamp int64      // 上一次生成ID的时间戳
+	workerId      int64      // 机器ID
+	datacenterId  int64      // 数据中心ID
+	sequence      int64      // 序列号
+	lease         *RedisLock // worker id租约，为nil时不校验
 }
 
-// InitSnowFlake .
-func InitSnowFlake() {
-	// get local ip
-	ip, err := GetClientIp() // GetClientIp()函数用于获取客户端ip
-	if err != nil {
-		panic(err)
-	}
-
-	// get workId from redis
-	var workId int64
+// InitSnowFlake 从redis租用worker id，机器ID和数据中心ID合起来共1024个
+// 租约持有期间自动续期，服务退出后过期回收，重启或换ip不会耗尽
+func InitSnowFlake() *Snowflake {
 	ctx := context.Background()                    // Background()函数用于创建一个空的context对象
 	lgRedis := new(plugins.LangGoRedis).NewRedis() // new(plugins.LangGoRedis)用于创建一个LangGoRedis对象，NewRedis()函数用于创建一个新的redis对象，返回值是一个redis对象，这个对象是一个指针
 
-	ipExist := lgRedis.Exists(ctx, ip).Val() // Exists()函数用于判断key是否存在，参数是一个context对象，返回值是一个int64类型的值
-	if ipExist == 1 {
-		curWorkId, err := lgRedis.Get(ctx, ip).Result() // Get()函数用于获取key的值，参数是一个context对象，返回值是一个string类型的值 Result()函数返回值是一个string类型的值
+	total := (maxDatacenterId + 1) * (maxWorkerId + 1)
+	start := rand.Int63n(total) // 随机起点，减少多个服务同时启动时的冲突
+	for i := int64(0); i < total; i++ {
+		n := (start + i) % total
+		lease := NewRedisLock(&ctx, lgRedis, fmt.Sprintf("%s:%d", utils.WorkID, n))
+		lease.SetExpire(utils.WorkerLease)
+		ok, err := lease.Acquire()
 		if err != nil {
 			panic(err)
 		}
-		workId, err = strconv.ParseInt(curWorkId, 10, 64) // ParseInt()函数用于将字符串转换为int64类型的值，参数是一个字符串，一个int类型的进制，一个int类型的位数
-		if err != nil {
-			panic(err)
+		if !ok {
+			continue
 		}
-	} else {
-		newWorkId, err := lgRedis.Incr(ctx, utils.WorkID).Result() // Incr()函数用于将key的值加1，参数是一个context对象，返回值是一个int64类型的值 有关reids的incr命令，参考https://www.runoob.com/redis/strings-incr.html
+		res, err := newSnowFlake(n&maxWorkerId, n>>workerIdBits)
 		if err != nil {
 			panic(err)
 		}
-		lgRedis.Set(ctx, ip, newWorkId, -1) // Set()函数用于设置key的值，参数是一个context对象，一个key，一个value，一个过期时间
-		workId = newWorkId
+		res.lease = lease
+		once.Do(func() {
+			snowFlake = res
+		})
+		bootstrap.NewLogger().Logger.Info(fmt.Sprintf("当前租用的worker id:%d", n))
+		return snowFlake
 	}
-	once.Do(func() {
-		res, err := newSnowFlake(workId, 0)
-		if err != nil {
-			panic(err)
-		}
-		snowFlake = res
-	})
+	panic("没有可用的worker id")
 }
 
 func newSnowFlake(workerId, datacenterId int64) (*Snowflake, error) {
@@ -110,15 +106,29 @@ func NewSnowFlake() *Snowflake {
 	return snowFlake
 }
 
-// NextId . 有时钟回拨问题
+// NextId . 小范围时钟回拨时等待时钟追上，超出范围时报错
 func (sf *Snowflake) NextId() (int64, error) {
 	sf.mu.Lock()
 	defer sf.mu.Unlock()
 
+	// 租约失效或超过租期未续上时worker id可能已被其他服务租用，重新租用成功前不发号
+	if sf.lease != nil && !sf.lease.Held() {
+		if ok, err := sf.lease.Acquire(); err != nil || !ok {
+			return 0, errors.New("worker id lease lost")
+		}
+	}
+
 	timestamp := time.Now().UnixNano() / 1000000 // UnixNano()函数用于获取当前时间的纳秒数，返回值是一个int64类型的值 time.Now()函数用于获取当前时间，返回值是一个time.Time类型的值
 
 	if timestamp < sf.lastTimestamp { // 如果当前时间小于上一次生成ID的时间戳，说明时钟回拨
-		return 0, errors.New("clock moved backwards")
+		offset := sf.lastTimestamp - timestamp
+		if offset > utils.ClockMaxBackwards {
+			return 0, fmt.Errorf("clock moved backwards %dms", offset)
+		}
+		time.Sleep(time.Duration(offset) * time.Millisecond)
+		for timestamp < sf.lastTimestamp {
+			timestamp = time.Now().UnixNano() / 1000000
+		}
 	}
 
 	if timestamp == sf.lastTimestamp { // 如果当前时间等于上一次生成ID的时间戳，说明在同一毫秒内
@@ -0,0 +1,33 @@
+package base
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextIdClockBackwards(t *testing.T) {
+	sf, err := newSnowFlake(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev, err := sf.NextId()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 小范围回拨等待时钟追上
+	sf.lastTimestamp = time.Now().UnixNano()/1000000 + 50
+	id, err := sf.NextId()
+	if err != nil {
+		t.Fatalf("Expected to wait for small clock regression, but got %v", err)
+	}
+	if id <= prev {
+		t.Errorf("Expected increasing id, but got %d after %d", id, prev)
+	}
+
+	// 超出范围报错
+	sf.lastTimestamp = time.Now().UnixNano()/1000000 + 10000
+	if _, err := sf.NextId(); err == nil {
+		t.Errorf("Expected error for large clock regression, but got nil")
+	}
+}
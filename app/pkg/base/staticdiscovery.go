@@ -0,0 +1,59 @@
+package base
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// staticDiscovery 固定服务列表，适用于服务数量固定的部署
+type staticDiscovery struct {
+	peers []string
+}
+
+func NewStaticDiscovery(peers []string) *staticDiscovery {
+	return &staticDiscovery{
+		peers: peers,
+	}
+}
+
+// HeartBeat 不需要注册
+func (s *staticDiscovery) HeartBeat() {}
+
+// Deregister 服务列表不由当前服务维护，排空期间健康检查返回503，由外部摘除
+func (s *staticDiscovery) Deregister() error {
+	return nil
+}
+
+// Discovery 服务列表中的地址格式为ip:port
+func (s *staticDiscovery) Discovery() ([]*Service, error) {
+	resp := make([]*Service, 0, len(s.peers))
+	now := time.Now().Unix()
+	for _, peer := range s.peers {
+		host, port, err := net.SplitHostPort(peer)
+		if err != nil {
+			return nil, fmt.Errorf("服务地址%s有误，详情：%s", peer, err)
+		}
+		resp = append(resp, &Service{IP: host, Port: port, CreatedAt: now})
+	}
+	return resp, nil
+}
+
+// Get .
+func (s *staticDiscovery) Get(ip string) (*Service, error) {
+	return findService(s, ip)
+}
+
+// findService 从服务列表中查找指定服务
+func findService(d Discovery, ip string) (*Service, error) {
+	serviceList, err := d.Discovery()
+	if err != nil {
+		return nil, err
+	}
+	for _, service := range serviceList {
+		if service.IP == ip {
+			return service, nil
+		}
+	}
+	return nil, nil
+}
@@ -1,12 +1,8 @@
 package base
 
 import (
-	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
-	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -14,9 +10,8 @@ import (
 	"time"
 
 	"github.com/qinguoyi/osproxy/app/models"
-	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/app/pkg/staging"
 	"github.com/qinguoyi/osproxy/bootstrap"
-	"github.com/qinguoyi/osproxy/bootstrap/plugins"
 )
 
 var lgLogger *bootstrap.LangGoLogger
@@ -82,7 +77,7 @@ func GenUploadSingle(filename string, expire int, respChan chan models.GenUpload
 	metaDataInfoChan chan models.MetaDataInfo, wg *sync.WaitGroup) {
 	defer wg.Done()
 	bucket := selectBucketBySuffix(filename) // selectBucketBySuffix()函数用于根据文件后缀选择bucket,将文件分类后
-	uid, err := NewSnowFlake().NextId()      // NewSnowFlake()函数用于创建一个雪花算法实例，NextId()函数用于生成一个id
+	uid, err := NewIdGenerator().NextId()    // NewIdGenerator()函数用于获取发号器，NextId()函数用于生成一个id
 	if err != nil {
 		//lgLogger.WithContext(c).Error("雪花算法生成ID失败，详情：", zap.Any("err", err.Error()))
 		return
@@ -93,8 +88,8 @@ func GenUploadSingle(filename string, expire int, respChan chan models.GenUpload
 	storageName := fmt.Sprintf("%s.%s", uidStr, GetExtension(filename)) // GetExtension()函数用于获取文件后缀
 	objectName := fmt.Sprintf("%s/%s", bucket, storageName)             // objectName是一个字符串，格式是bucket/storageName
 
-	// 在本地创建uid的目录
-	if err := os.MkdirAll(path.Join(utils.LocalStore, uidStr), 0755); err != nil {
+	// 创建uid的暂存区，本地暂存时在当前服务创建目录
+	if err := staging.NewStaging().Create(uid); err != nil {
 		//lgLogger.WithContext(c).Error("创建本地目录失败，详情：", zap.Any("err", err.Error()))
 		return
 	}
@@ -121,6 +116,8 @@ func GenUploadSingle(filename string, expire int, respChan chan models.GenUpload
 		},
 		Path: filename,
 	}
+	// 记录本地目录所在服务，后续请求落到其他服务时直接转发
+	ownerNode, _ := LocalNodeID()
 	// 生成DB信息
 	now := time.Now()
 	metaDataInfoChan <- models.MetaDataInfo{
@@ -132,29 +129,40 @@ func GenUploadSingle(filename string, expire int, respChan chan models.GenUpload
 		MultiPart:   false,
 		Status:      -1,
 		ContentType: "application/octet-stream", //先按照文件后缀占位，后面文件上传会覆盖
+		OwnerNode:   ownerNode,
 		CreatedAt:   &now,
 		UpdatedAt:   &now,
 	}
 	return
 }
 
-func GenDownloadSingle(meta models.MetaDataInfo, expire string, respChan chan models.GenDownloadResp,
-	wg *sync.WaitGroup) {
+func GenDownloadSingle(meta models.MetaDataInfo, expire string, maxDownload int, respChan chan models.GenDownloadResp,
+	linkChan chan models.DownloadLink, errChan chan error, wg *sync.WaitGroup) {
 	defer wg.Done()
 	uid := meta.UID
 	bucketName := meta.Bucket
 	srcName := meta.Name
 	objectName := meta.StorageName
 
+	// 每个下载链接独立的linkId，用于吊销及次数限制
+	linkUid, err := NewIdGenerator().NextId()
+	if err != nil {
+		errChan <- err
+		return
+	}
+	linkId := strconv.FormatInt(linkUid, 10)
+	expireSecond, _ := strconv.ParseInt(expire, 10, 64)
+
 	// 生成加密query
-	date := time.Now().Format("2006-01-02T15:04:05Z")
-	signature := decode(fmt.Sprintf("%s-%s-%s-%s", date, expire,
-		bucketName, objectName))
-	queryString := GenDownloadSignature(uid, srcName, bucketName, objectName, expire, date, signature)
+	now := time.Now()
+	date := now.Format("2006-01-02T15:04:05Z")
+	signature := decode(downloadSignMessage(date, expire, bucketName, objectName, linkId))
+	queryString := GenDownloadSignature(uid, srcName, bucketName, objectName, expire, date, linkId, signature)
 	url := fmt.Sprintf("/api/storage/v0/download?%s", queryString)
 	info := models.GenDownloadResp{
-		Uid: fmt.Sprintf("%d", uid),
-		Url: url,
+		Uid:    fmt.Sprintf("%d", uid),
+		LinkId: linkId,
+		Url:    url,
 		Meta: models.MetaInfo{
 			SrcName: srcName,
 			DstName: objectName,
@@ -164,14 +172,16 @@ func GenDownloadSingle(meta models.MetaDataInfo, expire string, respChan chan mo
 			Size:    fmt.Sprintf("%d", meta.StorageSize),
 		},
 	}
-	respChan <- info
-	// 写入redis
-	key := fmt.Sprintf("%d-%s", uid, expire)
-	lgRedis := new(plugins.LangGoRedis).NewRedis()
-	b, err := json.Marshal(info)
-	if err != nil {
+	expireAt := now.Add(time.Duration(expireSecond) * time.Second)
+	linkChan <- models.DownloadLink{
+		LinkID:      linkId,
+		UID:         uid,
+		MaxDownload: maxDownload,
+		ExpireAt:    &expireAt,
+		CreatedAt:   &now,
+		UpdatedAt:   &now,
 	}
-	lgRedis.SetNX(context.Background(), key, b, 5*60*time.Second)
+	respChan <- info
 }
 
 func GetRange(rangeHeader string, size int64) (int64, int64) {
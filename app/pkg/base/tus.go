@@ -0,0 +1,61 @@
+package base
+
+/*
+tus协议请求头解析
+*/
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"strings"
+)
+
+// ParseUploadMetadata 解析Upload-Metadata，格式为逗号分隔的 key base64(value)
+func ParseUploadMetadata(header string) (map[string]string, error) {
+	meta := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return meta, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		kv := strings.Fields(pair)
+		switch len(kv) {
+		case 1:
+			meta[kv[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(kv[1])
+			if err != nil {
+				return nil, errors.New("Upload-Metadata值不是合法的base64")
+			}
+			meta[kv[0]] = string(value)
+		default:
+			return nil, errors.New("Upload-Metadata格式有误")
+		}
+	}
+	return meta, nil
+}
+
+// ParseUploadChecksum 解析Upload-Checksum，返回对应算法的hash及期望的摘要
+func ParseUploadChecksum(header string) (hash.Hash, []byte, error) {
+	kv := strings.Fields(header)
+	if len(kv) != 2 {
+		return nil, nil, errors.New("Upload-Checksum格式有误")
+	}
+	sum, err := base64.StdEncoding.DecodeString(kv[1])
+	if err != nil {
+		return nil, nil, errors.New("Upload-Checksum摘要不是合法的base64")
+	}
+	switch kv[0] {
+	case "md5":
+		return md5.New(), sum, nil
+	case "sha1":
+		return sha1.New(), sum, nil
+	case "sha256":
+		return sha256.New(), sum, nil
+	default:
+		return nil, nil, errors.New("Upload-Checksum算法不支持")
+	}
+}
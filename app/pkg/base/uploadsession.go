@@ -0,0 +1,88 @@
+package base
+
+/*
+分片上传会话，校验分片序号及大小
+*/
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/qinguoyi/osproxy/app/models"
+	"github.com/qinguoyi/osproxy/app/pkg/repo"
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/bootstrap/plugins"
+	"gorm.io/gorm"
+)
+
+// ExpectedChunkSize 分片的预期大小，最后一个分片为剩余大小
+func ExpectedChunkSize(session *models.UploadSession, chunkNum int) int64 {
+	if chunkNum == session.ChunkSum {
+		return session.TotalSize - int64(session.ChunkSum-1)*session.ChunkSize
+	}
+	return session.ChunkSize
+}
+
+// CheckSessionActive 会话是否可以继续上传
+func CheckSessionActive(session *models.UploadSession) error {
+	switch session.Status {
+	case utils.SessionStatusComplete:
+		return errors.New("上传会话已完成")
+	case utils.SessionStatusAbort:
+		return errors.New("上传会话已终止")
+	}
+	if session.ExpireAt != nil && session.ExpireAt.Before(time.Now()) {
+		return errors.New("上传会话已过期")
+	}
+	return nil
+}
+
+// CheckSessionChunk 存在上传会话时校验分片序号及大小，没有会话的历史上传不校验
+// 同一序号重新上传时替换旧分片，不在这里拒绝
+func CheckSessionChunk(uid int64, chunkNum int, size int64) error {
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	session, err := repo.NewUploadSessionRepo().GetByUid(lgDB, uid)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return errors.New("查询上传会话失败")
+	}
+	if err := CheckSessionActive(session); err != nil {
+		return err
+	}
+	if chunkNum < 1 || chunkNum > session.ChunkSum {
+		return fmt.Errorf("分片序号超出范围，应为1-%d", session.ChunkSum)
+	}
+	if expect := ExpectedChunkSize(session, chunkNum); size != expect {
+		return fmt.Errorf("分片%d大小有误，应为%d，实际%d", chunkNum, expect, size)
+	}
+	return nil
+}
+
+// SessionBitmap 按分片序号生成上传状态位图，同时返回缺失及重复的分片
+func SessionBitmap(session *models.UploadSession, parts []models.MultiPartInfo) (string, []int, []int) {
+	count := make([]int, session.ChunkSum)
+	for _, part := range parts {
+		if part.ChunkNum >= 1 && part.ChunkNum <= session.ChunkSum {
+			count[part.ChunkNum-1]++
+		}
+	}
+	var bitmap strings.Builder
+	var missing, duplicate []int
+	for i, n := range count {
+		switch {
+		case n == 0:
+			bitmap.WriteByte('0')
+			missing = append(missing, i+1)
+		case n > 1:
+			bitmap.WriteByte('1')
+			duplicate = append(duplicate, i+1)
+		default:
+			bitmap.WriteByte('1')
+		}
+	}
+	return bitmap.String(), missing, duplicate
+}
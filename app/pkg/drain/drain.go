@@ -0,0 +1,229 @@
+package drain
+
+/*
+节点排空：摘除服务注册，拒绝新的上传链接，等待进行中的上传及合并完成，
+可选将本地暂存的分片推送到其他服务，之后再停止服务
+*/
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qinguoyi/osproxy/app/pkg/base"
+	"github.com/qinguoyi/osproxy/app/pkg/repo"
+	"github.com/qinguoyi/osproxy/app/pkg/staging"
+	"github.com/qinguoyi/osproxy/app/pkg/thirdparty"
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/bootstrap"
+	"github.com/qinguoyi/osproxy/bootstrap/plugins"
+	"go.uber.org/zap"
+)
+
+var (
+	draining  int32
+	inFlight  int64
+	startOnce sync.Once
+	finished  = make(chan struct{})
+)
+
+// Draining 是否处于排空状态
+func Draining() bool {
+	return atomic.LoadInt32(&draining) == 1
+}
+
+// Acquire 请求开始
+func Acquire() {
+	atomic.AddInt64(&inFlight, 1)
+}
+
+// Release 请求结束
+func Release() {
+	atomic.AddInt64(&inFlight, -1)
+}
+
+// Done 排空完成后关闭
+func Done() <-chan struct{} {
+	return finished
+}
+
+// Start 开始排空，重复调用只执行一次
+func Start() {
+	startOnce.Do(func() {
+		atomic.StoreInt32(&draining, 1)
+		go run()
+	})
+}
+
+func run() {
+	defer close(finished)
+	logger := bootstrap.NewLogger().Logger
+	logger.Info("开始排空当前服务")
+
+	if err := base.NewDiscovery().Deregister(); err != nil {
+		logger.Error("摘除服务注册失败", zap.Any("err", err.Error()))
+	}
+	// 主节点任务交给其他服务
+	base.NewLeader().Resign()
+
+	conf := bootstrap.NewConfig("").Drain
+	timeout := time.Duration(conf.Timeout) * time.Second
+	if conf.Timeout <= 0 {
+		timeout = utils.DrainDefaultTimeout * time.Second
+	}
+	if !wait(timeout) {
+		logger.Warn("等待进行中的上传及合并超时")
+	}
+
+	if conf.Push {
+		push()
+	}
+	logger.Info("排空完成，可以停止服务")
+}
+
+// wait 等待进行中的请求及当前服务抢占的任务完成
+func wait(timeout time.Duration) bool {
+	ip, _ := base.LocalNodeID()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if atomic.LoadInt64(&inFlight) == 0 && runningTask(ip) == 0 {
+			return true
+		}
+		time.Sleep(utils.DrainCheckInterval)
+	}
+	return false
+}
+
+// runningTask 当前服务执行中的任务数量
+func runningTask(ip string) int {
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	runningTaskList, _ := repo.NewTaskRepo().FindByStatus(lgDB, utils.TaskStatusRunning)
+	count := 0
+	for _, task := range runningTaskList {
+		if task.NodeId == ip {
+			count++
+		}
+	}
+	return count
+}
+
+// push 将本地暂存目录依次推送到存活的服务，推送成功后修改元数据中的所在服务
+func push() {
+	logger := bootstrap.NewLogger().Logger
+	// 接收方无法确认推送来自集群内，不推送
+	if !base.PeerAuthEnabled() {
+		logger.Warn("未配置集群密钥及双向TLS，暂存的分片保留在本地")
+		return
+	}
+	st := staging.NewStaging()
+	if st.Shared() {
+		logger.Info("分片暂存在对象存储，无需推送")
+		return
+	}
+	dirs, err := os.ReadDir(utils.LocalStore)
+	if err != nil {
+		logger.Error("读取本地暂存目录失败", zap.Any("err", err.Error()))
+		return
+	}
+	targets, err := liveServices()
+	if err != nil {
+		logger.Error("获取存活的服务失败", zap.Any("err", err.Error()))
+		return
+	}
+	if len(targets) == 0 {
+		logger.Warn("没有其他存活的服务，暂存的分片保留在本地")
+		return
+	}
+
+	n := 0
+	for _, dir := range dirs {
+		uid, err := strconv.ParseInt(dir.Name(), 10, 64)
+		if !dir.IsDir() || err != nil {
+			continue
+		}
+		target := targets[n%len(targets)]
+		n++
+		if err := pushDir(st, uid, target); err != nil {
+			logger.Error(fmt.Sprintf("推送暂存目录%d到%s失败", uid, target.IP), zap.Any("err", err.Error()))
+			continue
+		}
+		logger.Info(fmt.Sprintf("暂存目录%d已推送到%s", uid, target.IP))
+	}
+}
+
+// liveServices 除当前服务外的存活服务
+func liveServices() ([]*base.Service, error) {
+	ip, err := base.LocalNodeID()
+	if err != nil {
+		return nil, err
+	}
+	serviceList, err := base.NewDiscovery().Discovery()
+	if err != nil {
+		return nil, err
+	}
+	var resp []*base.Service
+	for _, service := range serviceList {
+		if service.IP != ip {
+			resp = append(resp, service)
+		}
+	}
+	return resp, nil
+}
+
+func pushDir(st staging.Staging, uid int64, target *base.Service) error {
+	if err := sendDir(st, uid, target); err != nil {
+		return err
+	}
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	if err := repo.NewMetaDataInfoRepo().Updates(lgDB, uid, map[string]interface{}{
+		"owner_node": target.IP,
+	}); err != nil {
+		return err
+	}
+	lgRedis := new(plugins.LangGoRedis).NewRedis()
+	lgRedis.Del(context.Background(), fmt.Sprintf("%d-meta", uid))
+	return st.Remove(uid)
+}
+
+// sendDir 将暂存目录中的文件推送到目标服务
+func sendDir(st staging.Staging, uid int64, target *base.Service) error {
+	dir, err := st.Dir(uid)
+	if err != nil {
+		return err
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	uidStr := strconv.FormatInt(uid, 10)
+	scheme := base.PeerScheme()
+	// 先创建目录，没有上传任何数据的目录同样需要迁移
+	if err := thirdparty.NewStorageService().PushStaging(scheme, target.IP, target.Port, uidStr, "",
+		strings.NewReader("")); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		if err := pushFile(scheme, target, uidStr, dir, file.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func pushFile(scheme string, target *base.Service, uidStr, dir, name string) error {
+	f, err := os.Open(path.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return thirdparty.NewStorageService().PushStaging(scheme, target.IP, target.Port, uidStr, name, f)
+}
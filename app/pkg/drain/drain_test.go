@@ -0,0 +1,79 @@
+package drain
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/qinguoyi/osproxy/app/pkg/base"
+	"github.com/qinguoyi/osproxy/app/pkg/staging"
+	"github.com/qinguoyi/osproxy/app/pkg/web"
+	"github.com/qinguoyi/osproxy/bootstrap"
+)
+
+func TestSendDir(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("cluster:\n  secret: test-secret\n"), 0644); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	bootstrap.NewConfig(path)
+
+	st := &staging.LocalStaging{RootPath: t.TempDir()}
+	if err := st.Create(1); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	dir, _ := st.Dir(1)
+	if err := os.WriteFile(filepath.Join(dir, "1_1"), []byte("part one"), 0644); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	var mu sync.Mutex
+	received := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := base.VerifyPeerSignature(r); !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		received[r.URL.Query().Get("uid")+"/"+r.URL.Query().Get("name")] = string(body)
+		mu.Unlock()
+		_ = json.NewEncoder(w).Encode(web.Response{})
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	host, port, _ := net.SplitHostPort(u.Host)
+
+	if err := sendDir(st, 1, &base.Service{IP: host, Port: port}); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if _, ok := received["1/"]; !ok {
+		t.Errorf("Expected directory to be created on target")
+	}
+	if received["1/1_1"] != "part one" {
+		t.Errorf("Expected part to be pushed, but got %q", received["1/1_1"])
+	}
+
+	// 目标服务拒绝时推送失败
+	reject := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_ = json.NewEncoder(w).Encode(web.Response{Message: "未配置集群密钥及双向TLS，不接收暂存分片"})
+	}))
+	defer reject.Close()
+	u, _ = url.Parse(reject.URL)
+	host, port, _ = net.SplitHostPort(u.Host)
+	if err := sendDir(st, 1, &base.Service{IP: host, Port: port}); err == nil {
+		t.Errorf("Expected error when target rejects, but got nil")
+	}
+}
@@ -3,12 +3,14 @@ package dispatch
 import (
 	"fmt"
 	"github.com/qinguoyi/osproxy/app/models"
+	"github.com/qinguoyi/osproxy/app/pkg/base"
 	"github.com/qinguoyi/osproxy/app/pkg/event"
 	"github.com/qinguoyi/osproxy/app/pkg/repo"
 	"github.com/qinguoyi/osproxy/app/pkg/utils"
 	"github.com/qinguoyi/osproxy/bootstrap/plugins"
 	"gorm.io/gorm"
 	"sync"
+	"time"
 )
 
 type Job struct {
@@ -17,22 +19,28 @@ type Job struct {
 }
 
 type Worker struct {
-	Wg *sync.WaitGroup
+	Wg    *sync.WaitGroup
+	Queue <-chan Job // 所属worker池的队列
 }
 
-func NewWorker() *Worker {
+func NewWorker(queue <-chan Job) *Worker {
 	return &Worker{
-		Wg: &sync.WaitGroup{},
+		Wg:    &sync.WaitGroup{},
+		Queue: queue,
 	}
 }
 
 func (w *Worker) Start() {
+	ip, err := base.LocalNodeID()
+	if err != nil {
+		panic(err)
+	}
 	go func() {
 		defer w.Wg.Done()
 		for {
 			select {
 			// 这里不能用协程，因为协程内部开协程，两者没有依赖关系，外部协程退出，内部协程仍然在执行
-			case job := <-JobQueue:
+			case job := <-w.Queue:
 				handler := event.NewEventsHandler().GetHandler(job.TaskType)
 				var lgDB = new(plugins.LangGoDB).Use("default").NewDB()
 				// 创建日志数据
@@ -48,7 +56,7 @@ func (w *Worker) Start() {
 					// 事务更新
 					if err := lgDB.Transaction(
 						func(tx *gorm.DB) error {
-							if repo.NewTaskRepo().ErrorTaskByID(lgDB, job.TaskID, 1) == 1 {
+							if repo.NewTaskRepo().ErrorTaskByID(lgDB, job.TaskID, ip, 1) == 1 {
 								if err := repo.TaskLogRepo.UpdateColumn(lgDB, taskLogData.ID, map[string]interface{}{
 									"status":     utils.TaskStatusError,
 									"error_info": fmt.Sprintf("不存在对应消息的handler%v\n", job.TaskType),
@@ -60,14 +68,31 @@ func (w *Worker) Start() {
 						}); err != nil {
 					}
 				} else {
-					// 开始执行
-					err := handler(job.TaskID)
-					tkInfo, _ := repo.NewTaskRepo().GetByID(lgDB, job.TaskID)
+					// 查询任务失败时没有执行次数等信息，不计入执行次数，重置后重新抢占
+					tkInfo, err := repo.NewTaskRepo().GetByID(lgDB, job.TaskID)
+					if err != nil {
+						handler.Release()
+						_ = lgDB.Transaction(
+							func(tx *gorm.DB) error {
+								if repo.NewTaskRepo().ResetTaskByID(tx, job.TaskID, ip) == 1 {
+									return repo.TaskLogRepo.UpdateColumn(tx, taskLogData.ID, map[string]interface{}{
+										"status":     utils.TaskStatusError,
+										"error_info": fmt.Sprintf("查询任务失败：%s", err.Error()),
+									})
+								}
+								return nil
+							})
+						claimed.Delete(job.TaskID)
+						continue
+					}
+					// 开始执行，服务停止时取消handler的ctx
+					err = handler.Run(taskCtx, *tkInfo)
+					handler.Release()
 					if err == nil {
 						// 执行成功
 						if txErr := lgDB.Transaction(
 							func(tx *gorm.DB) error {
-								if repo.NewTaskRepo().FinishTaskByID(lgDB, job.TaskID, tkInfo.ExecuteTime+1) == 1 {
+								if repo.NewTaskRepo().FinishTaskByID(lgDB, job.TaskID, ip, tkInfo.ExecuteTime+1) == 1 {
 									if updateErr := repo.TaskLogRepo.UpdateColumn(lgDB, taskLogData.ID,
 										map[string]interface{}{
 											"status":     utils.TaskStatusFinish,
@@ -79,18 +104,29 @@ func (w *Worker) Start() {
 								return nil
 							}); txErr != nil {
 						}
+					} else if taskCtx.Err() != nil {
+						// 服务停止中断的任务不计入执行次数，重置后由其他服务重新执行
+						_ = lgDB.Transaction(
+							func(tx *gorm.DB) error {
+								if repo.NewTaskRepo().ResetTaskByID(tx, job.TaskID, ip) == 1 {
+									return repo.TaskLogRepo.UpdateColumn(tx, taskLogData.ID, map[string]interface{}{
+										"status":     utils.TaskStatusError,
+										"error_info": "服务停止，任务中断",
+									})
+								}
+								return nil
+							})
 					} else {
 						// 执行失败
-						//还未达到执行次数上限
-						if tkInfo.ExecuteTime < utils.CompensationTotal {
+						//还未达到执行次数上限，按重试策略退避后再执行，否则进入死信
+						policy := handler.RetryPolicy()
+						if tkInfo.ExecuteTime < policy.MaxAttempts {
 							if txErr := lgDB.Transaction(
 								func(tx *gorm.DB) error {
-									_ = repo.NewTaskRepo().ResetTaskByID(lgDB, job.TaskID, tkInfo.NodeId)
-									// 更新任务信息中的执行次数
-									if updateErr := repo.NewTaskRepo().UpdateColumn(lgDB, job.TaskID,
-										"execute_time", tkInfo.ExecuteTime+1); updateErr != nil {
-										return updateErr
-									}
+									// 更新任务信息中的执行次数及下次执行时间
+									nextRunAt := time.Now().Add(policy.Backoff(tkInfo.ExecuteTime))
+									_ = repo.NewTaskRepo().RetryTaskByID(tx, job.TaskID, ip,
+										tkInfo.ExecuteTime+1, nextRunAt)
 
 									if updateErr := repo.TaskLogRepo.UpdateColumn(lgDB, taskLogData.ID,
 										map[string]interface{}{
@@ -103,9 +139,11 @@ func (w *Worker) Start() {
 								}); txErr != nil {
 							}
 						} else {
+							dead := false
 							if txErr := lgDB.Transaction(
 								func(tx *gorm.DB) error {
-									if repo.NewTaskRepo().ErrorTaskByID(lgDB, job.TaskID, tkInfo.ExecuteTime+1) == 1 {
+									if repo.NewTaskRepo().ErrorTaskByID(lgDB, job.TaskID, ip, tkInfo.ExecuteTime+1) == 1 {
+										dead = true
 										if updateErr := repo.TaskLogRepo.UpdateColumn(lgDB, taskLogData.ID,
 											map[string]interface{}{
 												"status":     utils.TaskStatusError,
@@ -117,9 +155,14 @@ func (w *Worker) Start() {
 									return nil
 								}); txErr != nil {
 							}
+							// 进入死信后的回调，如推送合并失败事件
+							if dead {
+								handler.DeadLetter(taskCtx, *tkInfo, err)
+							}
 						}
 					}
 				}
+				claimed.Delete(job.TaskID)
 			case <-taskCtx.Done():
 				return
 			}
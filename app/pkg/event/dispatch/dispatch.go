@@ -2,39 +2,72 @@ package dispatch
 
 import (
 	"context"
+	"fmt"
+	"sync"
 
 	"github.com/qinguoyi/osproxy/app/pkg/base"
+	"github.com/qinguoyi/osproxy/app/pkg/event"
 	_ "github.com/qinguoyi/osproxy/app/pkg/event/handlers" // 为了执行handlers包里的init 自动注册
 	"github.com/qinguoyi/osproxy/app/pkg/repo"
 	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/bootstrap"
 	"github.com/qinguoyi/osproxy/bootstrap/plugins"
 )
 
 var (
 	taskCtx, taskCancel = context.WithCancel(context.Background())
-	JobQueue            = make(chan Job, utils.MaxQueue)
+	jobQueues           = map[string]chan Job{}          // 每个任务类型独立的队列，启动后只读
+	fallbackQueue       = make(chan Job, utils.MaxQueue) // 没有handler的任务
+	claimed             sync.Map                         // 当前进程抢占且还未执行完的任务，只续期这些任务的租约
 )
 
 // RunTask 启动任务
 func RunTask() (*Producer, []Worker) {
+	handlers := event.NewEventsHandler()
+	handlers.Configure(bootstrap.NewConfig("").Task.Pools)
+
+	// 启动消费者，每个任务类型独立的worker池，慢任务不会占满其他类型的worker
+	// 队列长度等于worker数，抢占前已占用名额，生产者不会阻塞
+	var consumers []Worker
+	for _, t := range handlers.Types() {
+		handler := handlers.GetHandler(t)
+		jobQueues[t] = make(chan Job, handler.Workers())
+		consumers = append(consumers, startWorkers(jobQueues[t], handler.Workers())...)
+		bootstrap.NewLogger().Logger.Info(fmt.Sprintf("任务类型%s的worker数：%d，优先级：%d",
+			t, handler.Workers(), handler.Priority()))
+	}
+	// 没有handler的任务由单独的worker标记失败
+	consumers = append(consumers, startWorkers(fallbackQueue, 1)...)
+
 	// 启动生产者
 	p := NewProduce()
-	p.Wg.Add(1)
+	p.Wg.Add(2)
 	go p.Produce()
+	go p.Heartbeat()
+	return p, consumers
+}
 
-	// 启动消费者
+func startWorkers(queue chan Job, n int) []Worker {
 	var consumers []Worker
-	for i := 0; i < utils.MaxWorker; i++ {
-		worker := NewWorker()
+	for i := 0; i < n; i++ {
+		worker := NewWorker(queue)
 		consumers = append(consumers, *worker)
 		worker.Wg.Add(1)
 		worker.Start()
 	}
-	return p, consumers
+	return consumers
+}
+
+// jobQueue 任务类型对应的队列
+func jobQueue(t string) chan Job {
+	if queue, ok := jobQueues[t]; ok {
+		return queue
+	}
+	return fallbackQueue
 }
 
 func StopTask(p *Producer, consumers []Worker) {
-	ip, err := base.GetOutBoundIP()
+	ip, err := base.LocalNodeID()
 	if err != nil {
 		panic(err)
 	}
@@ -44,7 +77,7 @@ func StopTask(p *Producer, consumers []Worker) {
 
 	// 等待生产消费者任务终止
 	p.Stop()
-	for i := 0; i < utils.MaxWorker; i++ {
+	for i := range consumers {
 		consumers[i].Stop()
 	}
 
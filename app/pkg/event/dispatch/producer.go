@@ -2,14 +2,18 @@ package dispatch
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/qinguoyi/osproxy/app/pkg/base"
+	"github.com/qinguoyi/osproxy/app/pkg/drain"
 	"github.com/qinguoyi/osproxy/app/pkg/event"
+	"github.com/qinguoyi/osproxy/app/pkg/event/notify"
 	"github.com/qinguoyi/osproxy/app/pkg/repo"
 	"github.com/qinguoyi/osproxy/app/pkg/utils"
 	"github.com/qinguoyi/osproxy/bootstrap/plugins"
+	"gorm.io/gorm"
 )
 
 type Producer struct {
@@ -22,44 +26,142 @@ func NewProduce() *Producer {
 	}
 }
 
-// Produce 生产者
+// Produce 生产者，收到任务通知时立即抢占，轮询只作兜底
 func (p *Producer) Produce() {
 	timer := time.NewTimer(1 * time.Nanosecond)
-	ip, err := base.GetClientIp()
+	ip, err := base.LocalNodeID()
 	if err != nil {
 		panic(err)
 	}
 	defer timer.Stop()
 	defer p.Wg.Done()
+
+	notifier := notify.NewNotifier()
+	wake := make(chan struct{}, 1)
+	go notifier.Subscribe(taskCtx, wake)
 	for {
 		select {
 		case <-timer.C:
-			var lgDB = new(plugins.LangGoDB).Use("default").NewDB()
-			undoTaskList, _ := repo.NewTaskRepo().FindByStatus(lgDB, utils.TaskStatusUndo)
-			for _, i := range undoTaskList {
-				// 抢占前处理
-				preProcess := event.NewEventsHandler().GetPreProcess(i.TaskType)
-				if preProcess != nil {
-					if f := preProcess(i.ID); !f {
-						continue
-					}
-				}
-				// 抢占任务
-				affectRow := repo.NewTaskRepo().PreemptiveTaskByID(lgDB, i.ID, ip)
-				if affectRow != 0 {
-					JobQueue <- Job{
-						TaskID:   i.ID,
-						TaskType: i.TaskType,
-					}
-				}
+		case <-wake:
+			if !timer.Stop() {
+				<-timer.C
 			}
-
 		case <-taskCtx.Done():
 			fmt.Println("任务生产者终止...")
 			return
 		}
 
-		timer.Reset(500 * time.Millisecond)
+		p.reclaim()
+		// 排空中不再抢占新任务
+		if !drain.Draining() {
+			p.preempt(ip)
+		}
+		timer.Reset(notifier.PollInterval())
+	}
+}
+
+// preempt 抢占待执行的任务
+func (p *Producer) preempt(ip string) {
+	var lgDB = new(plugins.LangGoDB).Use("default").NewDB()
+	undoTaskList, _ := repo.NewTaskRepo().FindRunnable(lgDB)
+	// 按任务类型的优先级抢占，同优先级先创建的先抢占
+	sort.SliceStable(undoTaskList, func(a, b int) bool {
+		return priority(undoTaskList[a].TaskType) > priority(undoTaskList[b].TaskType)
+	})
+	for _, i := range undoTaskList {
+		// 抢占前处理及worker池名额，没有handler的任务仍然抢占，由单独的worker标记失败
+		handler := event.NewEventsHandler().GetHandler(i.TaskType)
+		if handler == nil {
+			if len(fallbackQueue) == cap(fallbackQueue) {
+				continue
+			}
+		} else if !handler.PreProcess(taskCtx, i) || !handler.TryAcquire() {
+			continue
+		}
+		// 抢占任务
+		affectRow := repo.NewTaskRepo().PreemptiveTaskByID(lgDB, i.ID, ip,
+			time.Now().Add(utils.TaskLease*time.Second))
+		if affectRow != 0 {
+			claimed.Store(i.ID, struct{}{})
+			jobQueue(i.TaskType) <- Job{
+				TaskID:   i.ID,
+				TaskType: i.TaskType,
+			}
+		} else if handler != nil {
+			handler.Release()
+		}
+	}
+}
+
+// priority 任务类型的抢占优先级，没有handler时为0
+func priority(t string) int {
+	handler := event.NewEventsHandler().GetHandler(t)
+	if handler == nil {
+		return 0
+	}
+	return handler.Priority()
+}
+
+// reclaim 回收租约过期的任务，执行服务异常退出后按重试策略重新执行或进入死信
+// 本地暂存的分片合并任务只有分片目录所在服务能再次抢占，所在服务已下线时抢占后按重试次数进入死信
+func (p *Producer) reclaim() {
+	var lgDB = new(plugins.LangGoDB).Use("default").NewDB()
+	expiredTaskList, _ := repo.NewTaskRepo().FindLeaseExpired(lgDB)
+	for _, i := range expiredTaskList {
+		task := i
+		policy := event.NewEventsHandler().GetRetryPolicy(task.TaskType)
+		status := utils.TaskStatusUndo
+		if task.ExecuteTime >= policy.MaxAttempts {
+			status = utils.TaskStatusError
+		}
+		nextRunAt := time.Now().Add(policy.Backoff(task.ExecuteTime))
+		reclaimErr := fmt.Errorf("执行服务%s的租约过期，任务已回收", task.NodeId)
+		reclaimed := false
+		_ = lgDB.Transaction(
+			func(tx *gorm.DB) error {
+				if repo.NewTaskRepo().ReclaimTaskByID(tx, task.ID, task.NodeId, status, task.ExecuteTime+1,
+					nextRunAt) == 1 {
+					reclaimed = true
+					return repo.TaskLogRepo.UpdateColumn(tx, int64(task.TaskLogID), map[string]interface{}{
+						"status":     utils.TaskStatusError,
+						"error_info": reclaimErr.Error(),
+					})
+				}
+				return nil
+			})
+		if handler := event.NewEventsHandler().GetHandler(task.TaskType); reclaimed && handler != nil &&
+			status == utils.TaskStatusError {
+			handler.DeadLetter(taskCtx, task, reclaimErr)
+		}
+	}
+}
+
+// Heartbeat 定时续期当前进程抢占的任务租约，包括队列中还未执行的任务
+func (p *Producer) Heartbeat() {
+	ticker := time.NewTicker(utils.TaskLeaseRenewInterval)
+	ip, err := base.LocalNodeID()
+	if err != nil {
+		panic(err)
+	}
+	defer ticker.Stop()
+	defer p.Wg.Done()
+
+	for {
+		select {
+		case <-ticker.C:
+			var taskIDs []int64
+			claimed.Range(func(key, value interface{}) bool {
+				taskIDs = append(taskIDs, key.(int64))
+				return true
+			})
+			if len(taskIDs) == 0 {
+				continue
+			}
+			var lgDB = new(plugins.LangGoDB).Use("default").NewDB()
+			_ = repo.NewTaskRepo().RenewLeaseByIDs(lgDB, taskIDs, ip, time.Now().Add(utils.TaskLease*time.Second))
+		case <-taskCtx.Done():
+			return
+		}
 	}
 }
 
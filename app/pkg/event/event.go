@@ -1,7 +1,11 @@
 package event
 
 import (
+	"sort"
+	"strings"
 	"sync"
+
+	"github.com/qinguoyi/osproxy/config"
 )
 
 var (
@@ -10,24 +14,22 @@ var (
 )
 
 type EventsHandler struct {
-	mux        sync.RWMutex
-	preProcess map[string]func(i interface{}) bool
-	handlers   map[string]func(i interface{}) error
+	mux      sync.RWMutex
+	handlers map[string]*Handler
 }
 
 func NewEventsHandler() *EventsHandler {
 	once.Do(func() {
 		eventsHandler = &EventsHandler{
-			mux:        sync.RWMutex{},
-			preProcess: map[string]func(i interface{}) bool{},
-			handlers:   map[string]func(i interface{}) error{},
+			mux:      sync.RWMutex{},
+			handlers: map[string]*Handler{},
 		}
 	})
 	return eventsHandler
 }
 
-// RegHandler 注册handler
-func (e *EventsHandler) RegHandler(t string, handler func(i interface{}) error) {
+// regHandler 注册handler，同一任务类型只保留首次注册
+func (e *EventsHandler) regHandler(t string, handler *Handler) {
 	e.mux.Lock()
 	defer e.mux.Unlock()
 	_, ok := e.handlers[t]
@@ -36,8 +38,8 @@ func (e *EventsHandler) RegHandler(t string, handler func(i interface{}) error)
 	}
 }
 
-// GetHandler 获取handler
-func (e *EventsHandler) GetHandler(t string) func(i interface{}) error {
+// GetHandler 获取handler，未注册时返回nil
+func (e *EventsHandler) GetHandler(t string) *Handler {
 	e.mux.RLock()
 	defer e.mux.RUnlock()
 	handler, ok := e.handlers[t]
@@ -48,24 +50,48 @@ func (e *EventsHandler) GetHandler(t string) func(i interface{}) error {
 	}
 }
 
-// RegPreProcess 注册预处理PreProcess
-func (e *EventsHandler) RegPreProcess(t string, preProcess func(i interface{}) bool) {
-	e.mux.Lock()
-	defer e.mux.Unlock()
-	_, ok := e.preProcess[t]
-	if !ok {
-		e.preProcess[t] = preProcess
+// GetRetryPolicy 获取重试策略，未注册时使用默认策略
+func (e *EventsHandler) GetRetryPolicy(t string) RetryPolicy {
+	handler := e.GetHandler(t)
+	if handler == nil {
+		return DefaultRetryPolicy
 	}
+	return handler.retry
 }
 
-// GetPreProcess 获取PreProcess
-func (e *EventsHandler) GetPreProcess(t string) func(i interface{}) bool {
+// Types 已注册的任务类型，按优先级从高到低排列
+func (e *EventsHandler) Types() []string {
 	e.mux.RLock()
 	defer e.mux.RUnlock()
-	preProcess, ok := e.preProcess[t]
-	if !ok {
-		return nil
-	} else {
-		return preProcess
+	var types []string
+	for t := range e.handlers {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		pi, pj := e.handlers[types[i]].priority, e.handlers[types[j]].priority
+		if pi != pj {
+			return pi > pj
+		}
+		return types[i] < types[j]
+	})
+	return types
+}
+
+// Configure 按配置覆盖worker数及优先级，配置文件的键不区分大小写，需在启动worker池前调用
+func (e *EventsHandler) Configure(pools map[string]config.TaskPool) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	for name, pool := range pools {
+		for t, handler := range e.handlers {
+			if !strings.EqualFold(name, t) {
+				continue
+			}
+			if pool.Workers > 0 {
+				handler.setWorkers(pool.Workers)
+			}
+			if pool.Priority != 0 {
+				handler.priority = pool.Priority
+			}
+		}
 	}
 }
@@ -1,55 +1,32 @@
 package handlers
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
-	"fmt"
 	"github.com/qinguoyi/osproxy/app/models"
 	"github.com/qinguoyi/osproxy/app/pkg/event"
 	"github.com/qinguoyi/osproxy/app/pkg/repo"
+	"github.com/qinguoyi/osproxy/app/pkg/staging"
 	"github.com/qinguoyi/osproxy/app/pkg/storage"
 	"github.com/qinguoyi/osproxy/app/pkg/utils"
 	"github.com/qinguoyi/osproxy/bootstrap/plugins"
-	"os"
-	"path"
+	"time"
 )
 
 func init() {
-	event.NewEventsHandler().RegPreProcess(utils.TaskPartDelete, preProcessPartDelete)
-	event.NewEventsHandler().RegHandler(utils.TaskPartDelete, handlePartDelete)
+	// 清理失败不影响使用，重试间隔可以更长
+	event.Register(utils.TaskPartDelete, handlePartDelete,
+		event.WithTimeout(10*time.Minute),
+		event.WithConcurrency(5),
+		event.WithRetry(event.RetryPolicy{
+			MaxAttempts: 10,
+			BaseDelay:   time.Minute,
+			MaxDelay:    time.Hour,
+		}))
 }
 
-func preProcessPartDelete(i interface{}) bool {
-	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
-
-	taskID := i.(int64)
-	taskInfo, err := repo.NewTaskRepo().GetByID(lgDB, taskID)
-	if err != nil {
-		fmt.Printf("任务不存在%v", err)
-		return false
-	}
-	// 反序列extraData
-	var msg models.MergeInfo
-	if err := json.Unmarshal([]byte(taskInfo.ExtraData), &msg); err != nil {
-		fmt.Printf("任务不存在%v", err)
-		return false
-	}
-	return true
-}
-
-func handlePartDelete(i interface{}) error {
-	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
-	taskID := i.(int64)
-	taskInfo, err := repo.NewTaskRepo().GetByID(lgDB, taskID)
-	if err != nil {
-		fmt.Printf("任务不存在%v", err)
-		return err
-	}
-	// 反序列extraData
-	var msg models.MergeInfo
-	if err := json.Unmarshal([]byte(taskInfo.ExtraData), &msg); err != nil {
-		return err
-	}
+func handlePartDelete(ctx context.Context, task event.TaskMeta, msg models.MergeInfo) error {
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB().WithContext(ctx)
 
 	//查询分片信息
 	var multiPartInfoList []models.MultiPartInfo
@@ -58,8 +35,7 @@ func handlePartDelete(i interface{}) error {
 		return errors.New("查询分片数据失败")
 	}
 
-	dirName := path.Join(utils.LocalStore, fmt.Sprintf("%d", msg.StorageUid))
-	if err := os.RemoveAll(dirName); err != nil {
+	if err := staging.NewStaging().Remove(msg.StorageUid); err != nil {
 		return errors.New("删除本地脏数据失败")
 	}
 
@@ -2,65 +2,79 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/qinguoyi/osproxy/app/models"
 	"github.com/qinguoyi/osproxy/app/pkg/base"
 	"github.com/qinguoyi/osproxy/app/pkg/event"
 	"github.com/qinguoyi/osproxy/app/pkg/repo"
+	"github.com/qinguoyi/osproxy/app/pkg/staging"
 	"github.com/qinguoyi/osproxy/app/pkg/storage"
 	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/app/pkg/webhook"
 	"github.com/qinguoyi/osproxy/bootstrap/plugins"
+	"gorm.io/gorm"
 	"io"
 	"os"
 	"path"
+	"strconv"
 	"time"
 )
 
 func init() {
-	event.NewEventsHandler().RegPreProcess(utils.TaskPartMerge, preProcessPartMerge)
-	event.NewEventsHandler().RegHandler(utils.TaskPartMerge, handlePartMerge)
+	// 合并占用磁盘及带宽，限制单个服务同时合并的数量；用户等待合并结果，优先抢占
+	// 合并耗时随文件大小增长，不设置超时，执行服务异常退出时由租约回收
+	event.Register(utils.TaskPartMerge, handlePartMerge,
+		event.WithPreProcess(preProcessPartMerge),
+		event.WithConcurrency(10),
+		event.WithPriority(10),
+		event.WithDeadLetter(deadLetterPartMerge),
+		event.WithRetry(event.RetryPolicy{
+			MaxAttempts: utils.CompensationTotal,
+			BaseDelay:   10 * time.Second,
+			MaxDelay:    10 * time.Minute,
+		}))
 }
 
-func preProcessPartMerge(i interface{}) bool {
-	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+func preProcessPartMerge(ctx context.Context, task event.TaskMeta, msg models.MergeInfo) bool {
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB().WithContext(ctx)
 
-	taskID := i.(int64)
-	taskInfo, err := repo.NewTaskRepo().GetByID(lgDB, taskID)
+	// 执行服务在合并完成后、更新任务状态前退出，任务被回收后任意服务都可以直接完成
+	if merged(lgDB, msg.StorageUid) {
+		return true
+	}
+	// 本地暂存时只有上传目录所在服务可以合并
+	if staging.NewStaging().Exists(msg.StorageUid) {
+		return true
+	}
+	// 所在服务已下线时分片无法再合并，抢占后按重试次数进入死信，避免任务一直等待
+	metaData, err := repo.NewMetaDataInfoRepo().GetByUid(lgDB, msg.StorageUid)
 	if err != nil {
-		fmt.Printf("任务不存在%v", err)
 		return false
 	}
+	return ownerGone(metaData.OwnerNode)
+}
 
-	// 反序列extraData
-	var msg models.MergeInfo
-	if err := json.Unmarshal([]byte(taskInfo.ExtraData), &msg); err != nil {
-		fmt.Printf("任务不存在%v", err)
+// ownerGone 上传目录所在服务是否已不在服务列表中，所在服务为当前服务时目录已不存在
+func ownerGone(ownerNode string) bool {
+	if ownerNode == "" {
 		return false
 	}
-	dirName := path.Join(utils.LocalStore, fmt.Sprintf("%d", msg.StorageUid))
-	if _, err := os.Stat(dirName); os.IsNotExist(err) {
-		return false
-	} else {
+	if localNode, err := base.LocalNodeID(); err == nil && localNode == ownerNode {
 		return true
 	}
+	service, err := base.NewDiscovery().Get(ownerNode)
+	return err == nil && service == nil
 }
 
-func handlePartMerge(i interface{}) error {
-	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+func handlePartMerge(ctx context.Context, task event.TaskMeta, msg models.MergeInfo) error {
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB().WithContext(ctx)
 
-	taskID := i.(int64)
-	taskInfo, err := repo.NewTaskRepo().GetByID(lgDB, taskID)
-	if err != nil {
-		fmt.Printf("任务不存在%v", err)
-		return err
+	if merged(lgDB, msg.StorageUid) {
+		return nil
 	}
-
-	// 反序列extraData
-	var msg models.MergeInfo
-	if err := json.Unmarshal([]byte(taskInfo.ExtraData), &msg); err != nil {
-		return err
+	if !staging.NewStaging().Exists(msg.StorageUid) {
+		return errors.New("分片目录所在服务已下线，无法合并")
 	}
 
 	// 按顺序合并分片
@@ -78,22 +92,31 @@ func handlePartMerge(i interface{}) error {
 		return errors.New("当前上传链接无效，uid不存在")
 	}
 
-	fileName := path.Join(utils.LocalStore, fmt.Sprintf("%d", msg.StorageUid), metaData.StorageName)
+	dirName, err := staging.NewStaging().Dir(msg.StorageUid)
+	if err != nil {
+		return errors.New("本地创建目录失败")
+	}
+	fileName := path.Join(dirName, metaData.StorageName)
 	out, err := os.Create(fileName)
 	if err != nil {
 		return errors.New("本地创建文件失败")
 	}
+	defer out.Close()
 
-	for _, i := range multiPartInfoList {
-		partName := path.Join(utils.LocalStore, fmt.Sprintf("%d", msg.StorageUid), i.PartFileName)
-		src, err := os.Open(partName)
-		if err != nil {
-			return errors.New("本地打开分片文件失败")
+	// 分片合并占90%，计算md5及上传到对象存储占剩余部分
+	base.SetMergeProgress(msg.StorageUid, 0)
+	for n, i := range multiPartInfoList {
+		// 服务停止或超时时中断合并
+		if err := ctx.Err(); err != nil {
+			return err
 		}
-		if _, err = io.Copy(out, src); err != nil {
-			return errors.New("分片文件合并成大文件失败")
+		if err := appendPart(out, i); err != nil {
+			return err
 		}
-		_ = src.Close()
+		base.SetMergeProgress(msg.StorageUid, (n+1)*90/len(multiPartInfoList))
+	}
+	if err := out.Close(); err != nil {
+		return errors.New("分片文件合并成大文件失败")
 	}
 
 	// 校验md5
@@ -101,7 +124,8 @@ func handlePartMerge(i interface{}) error {
 	if err != nil {
 		return errors.New(fmt.Sprintf("生成md5失败，详情%s", err.Error()))
 	}
-	if md5Str != metaData.Md5 {
+	// tus等未提供整体md5的上传，以合并后的计算结果为准
+	if metaData.Md5 != "" && md5Str != metaData.Md5 {
 		return errors.New(fmt.Sprintf("校验md5失败，计算结果:%s, 参数:%s", md5Str, metaData.Md5))
 	}
 	//判断是否上传过，md5
@@ -111,24 +135,29 @@ func handlePartMerge(i interface{}) error {
 	}
 	if len(resumeInfo) != 0 {
 		now := time.Now()
-		if err := repo.NewMetaDataInfoRepo().Updates(lgDB, metaData.UID, map[string]interface{}{
+		if err := repo.NewMetaDataInfoRepo().UpdatesWithEvent(lgDB, metaData.UID, map[string]interface{}{
 			"bucket":       resumeInfo[0].Bucket,
 			"storage_name": resumeInfo[0].StorageName,
 			"address":      resumeInfo[0].Address,
+			"md5":          md5Str,
 			"multi_part":   false,
 			"updated_at":   &now,
 			"content_type": resumeInfo[0].ContentType,
-		}); err != nil {
+			"scan_status":  resumeInfo[0].ScanStatus,
+			"scan_result":  resumeInfo[0].ScanResult,
+			"scanned_at":   resumeInfo[0].ScannedAt,
+		}, utils.EventObjectUploaded); err != nil {
 			return errors.New("上传完更新数据失败")
 		}
-		_ = out.Close()
-		fileDir := path.Join(utils.LocalStore, fmt.Sprintf("%d", msg.StorageUid))
-		_ = os.RemoveAll(fileDir)
+		_ = staging.NewStaging().Remove(msg.StorageUid)
 		// 更新数据 删除redis
 		lgRedis := new(plugins.LangGoRedis).NewRedis()
 		lgRedis.Del(context.Background(), fmt.Sprintf("%d-meta", metaData.UID))
+		base.DelMergeProgress(metaData.UID)
+		publishUploaded(lgDB, metaData.UID)
 		return nil
 	}
+	base.SetMergeProgress(msg.StorageUid, 95)
 	// 上传到minio
 	contentType, err := base.DetectContentType(fileName)
 	if err != nil {
@@ -141,18 +170,64 @@ func handlePartMerge(i interface{}) error {
 
 	// 更新元数据
 	now := time.Now()
-	if err := repo.NewMetaDataInfoRepo().Updates(lgDB, metaData.UID, map[string]interface{}{
+	if err := repo.NewMetaDataInfoRepo().UpdatesWithEvent(lgDB, metaData.UID, map[string]interface{}{
+		"md5":          md5Str,
 		"multi_part":   false,
 		"updated_at":   &now,
 		"content_type": contentType,
-	}); err != nil {
+	}, utils.EventObjectUploaded); err != nil {
 		return errors.New("上传完更新数据失败")
 	}
 	// 更新数据 删除redis
 	lgRedis := new(plugins.LangGoRedis).NewRedis()
 	lgRedis.Del(context.Background(), fmt.Sprintf("%d-meta", metaData.UID))
-	_ = out.Close()
-	fileDir := path.Join(utils.LocalStore, fmt.Sprintf("%d", msg.StorageUid))
-	_ = os.RemoveAll(fileDir)
+	base.DelMergeProgress(metaData.UID)
+	_ = staging.NewStaging().Remove(msg.StorageUid)
+	publishUploaded(lgDB, metaData.UID)
 	return nil
 }
+
+// appendPart 将分片追加到合并文件，出错时同样关闭分片文件
+func appendPart(out io.Writer, part models.MultiPartInfo) error {
+	src, err := staging.NewStaging().OpenPart(part)
+	if err != nil {
+		return errors.New("打开分片文件失败")
+	}
+	defer src.Close()
+	if _, err := io.Copy(out, src); err != nil {
+		return errors.New("分片文件合并成大文件失败")
+	}
+	return nil
+}
+
+// publishUploaded 推送上传完成事件，推送任务创建失败不影响合并结果
+func publishUploaded(db *gorm.DB, uid int64) {
+	if err := webhook.PublishObject(db, utils.EventObjectUploaded, uid); err != nil {
+		fmt.Printf("创建上传完成的推送任务失败%v", err)
+	}
+}
+
+// deadLetterPartMerge 合并达到重试上限后发布合并失败事件
+func deadLetterPartMerge(ctx context.Context, task event.TaskMeta, msg models.MergeInfo, err error) {
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	data := models.MergeFailedEventData{
+		ObjectEventData: models.ObjectEventData{Uid: strconv.FormatInt(msg.StorageUid, 10)},
+		TaskID:          task.ID,
+		Error:           err.Error(),
+	}
+	if metaData, getErr := repo.NewMetaDataInfoRepo().GetByUid(lgDB, msg.StorageUid); getErr == nil {
+		data.ObjectEventData = models.NewObjectEventData(metaData)
+	}
+	if pubErr := repo.NewOutboxRepo().Append(lgDB, utils.EventMergeFailed, msg.StorageUid, data); pubErr != nil {
+		fmt.Printf("写入合并失败事件失败%v", pubErr)
+	}
+	if pubErr := webhook.Publish(lgDB, utils.EventMergeFailed, msg.StorageUid, data); pubErr != nil {
+		fmt.Printf("创建合并失败的推送任务失败%v", pubErr)
+	}
+}
+
+// merged 元数据已不是分片状态，说明合并及上传已经完成
+func merged(db *gorm.DB, uid int64) bool {
+	metaData, err := repo.NewMetaDataInfoRepo().GetByUid(db, uid)
+	return err == nil && !metaData.MultiPart
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/qinguoyi/osproxy/app/models"
+	"github.com/qinguoyi/osproxy/app/pkg/event"
+	"github.com/qinguoyi/osproxy/app/pkg/repo"
+	"github.com/qinguoyi/osproxy/app/pkg/scan"
+	"github.com/qinguoyi/osproxy/app/pkg/storage"
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/bootstrap"
+	"github.com/qinguoyi/osproxy/bootstrap/plugins"
+	"gorm.io/gorm"
+)
+
+func init() {
+	// 扫描前不能下载，优先于清理任务执行
+	event.Register(utils.TaskScan, handleScan,
+		event.WithPreProcess(preProcessScan),
+		event.WithTimeout(time.Hour),
+		event.WithConcurrency(5),
+		event.WithPriority(8),
+		event.WithRetry(event.RetryPolicy{
+			MaxAttempts: 5,
+			BaseDelay:   30 * time.Second,
+			MaxDelay:    30 * time.Minute,
+		}),
+		event.WithDeadLetter(deadLetterScan))
+}
+
+// preProcessScan 本地存储时文件只在所在服务，只有所在服务可以扫描，所在服务已下线时抢占后按重试次数进入死信
+func preProcessScan(ctx context.Context, task event.TaskMeta, msg models.ScanInfo) bool {
+	if !bootstrap.NewConfig("").Local.Enabled {
+		return true
+	}
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB().WithContext(ctx)
+	metaData, err := repo.NewMetaDataInfoRepo().GetByUid(lgDB, msg.StorageUid)
+	if err != nil {
+		// 元数据已删除时由handler直接完成
+		return errors.Is(err, gorm.ErrRecordNotFound)
+	}
+	if _, err := os.Stat(path.Join(utils.LocalStore, metaData.Bucket, metaData.StorageName)); err == nil {
+		return true
+	}
+	return ownerGone(metaData.OwnerNode)
+}
+
+func handleScan(ctx context.Context, task event.TaskMeta, msg models.ScanInfo) error {
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB().WithContext(ctx)
+	metaData, err := repo.NewMetaDataInfoRepo().GetByUid(lgDB, msg.StorageUid)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return errors.New("查询元数据失败")
+	}
+	// 已关闭内容扫描，恢复为未扫描
+	scanner := scan.NewScanner()
+	if scanner == nil {
+		return updateScan(lgDB, msg.StorageUid, map[string]interface{}{
+			"scan_status": "",
+		})
+	}
+
+	reader := storage.NewObjectReader(metaData.Bucket, metaData.StorageName, metaData.StorageSize)
+	defer reader.Close()
+	result, err := scanner.Scan(ctx, reader)
+	if err != nil {
+		return errors.New(fmt.Sprintf("扫描失败，详情%s", err.Error()))
+	}
+
+	now := time.Now()
+	columns := map[string]interface{}{
+		"scan_status": utils.ScanStatusClean,
+		"scan_result": "",
+		"scanned_at":  &now,
+	}
+	if result.Infected {
+		columns["scan_status"] = utils.ScanStatusQuarantined
+		columns["scan_result"] = result.Signature
+	}
+	return updateScan(lgDB, msg.StorageUid, columns)
+}
+
+// deadLetterScan 多次扫描失败不放行，管理接口重新执行后可以恢复
+func deadLetterScan(ctx context.Context, task event.TaskMeta, msg models.ScanInfo, err error) {
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	now := time.Now()
+	if updateErr := updateScan(lgDB, msg.StorageUid, map[string]interface{}{
+		"scan_status": utils.ScanStatusFailed,
+		"scan_result": err.Error(),
+		"scanned_at":  &now,
+	}); updateErr != nil {
+		fmt.Printf("更新扫描失败状态失败%v", updateErr)
+	}
+}
+
+// updateScan 更新扫描结果并删除元数据缓存
+func updateScan(db *gorm.DB, uid int64, columns map[string]interface{}) error {
+	if err := repo.NewMetaDataInfoRepo().Updates(db, uid, columns); err != nil {
+		return errors.New("更新扫描结果失败")
+	}
+	lgRedis := new(plugins.LangGoRedis).NewRedis()
+	lgRedis.Del(context.Background(), fmt.Sprintf("%d-meta", uid))
+	return nil
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/qinguoyi/osproxy/app/models"
+	"github.com/qinguoyi/osproxy/app/pkg/event"
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/app/pkg/webhook"
+)
+
+func init() {
+	// 接收方可能短时间不可用，重试次数多、间隔长
+	event.Register(utils.TaskWebhook, handleWebhook,
+		event.WithTimeout(time.Minute),
+		event.WithConcurrency(20),
+		event.WithPriority(5),
+		event.WithRetry(event.RetryPolicy{
+			MaxAttempts: 10,
+			BaseDelay:   30 * time.Second,
+			MaxDelay:    2 * time.Hour,
+		}))
+}
+
+func handleWebhook(ctx context.Context, task event.TaskMeta, msg models.WebhookInfo) error {
+	return webhook.Deliver(ctx, msg)
+}
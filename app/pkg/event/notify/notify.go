@@ -0,0 +1,83 @@
+package notify
+
+import (
+	"context"
+	"time"
+
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/bootstrap"
+	"github.com/qinguoyi/osproxy/config"
+	"gorm.io/gorm"
+)
+
+/*
+任务通知，创建任务后立即唤醒各服务的生产者，轮询只作兜底
+poll：不通知，只轮询
+redis：redis发布订阅
+postgres：postgres LISTEN/NOTIFY，事务内创建任务时随事务提交发出
+*/
+
+// Notifier 任务通知
+type Notifier interface {
+	// Publish 通知有新任务，参数为创建任务使用的连接
+	Publish(*gorm.DB) error
+
+	// Subscribe 收到通知时向ch写入，ch已满时丢弃，直到ctx取消
+	Subscribe(context.Context, chan<- struct{})
+
+	// PollInterval 兜底轮询的间隔
+	PollInterval() time.Duration
+}
+
+var (
+	lgNotifier Notifier
+)
+
+func InitNotifier(conf *config.Configuration) {
+	interval := time.Duration(conf.Task.PollInterval) * time.Millisecond
+	switch conf.Task.Notify {
+	case "", utils.TaskNotifyPoll:
+		if interval <= 0 {
+			interval = utils.TaskPollInterval
+		}
+		lgNotifier = NewPollNotifier(interval)
+		bootstrap.NewLogger().Logger.Info("当前使用的任务通知：Poll")
+		return
+	case utils.TaskNotifyRedis:
+		lgNotifier = NewRedisNotifier(fallbackInterval(interval))
+		bootstrap.NewLogger().Logger.Info("当前使用的任务通知：Redis")
+	case utils.TaskNotifyPostgres:
+		for _, db := range conf.Database {
+			if db.DBName == "default" && db.Driver != "postgres" {
+				panic("postgres任务通知需要默认数据库使用postgres")
+			}
+		}
+		lgNotifier = NewPostgresNotifier(fallbackInterval(interval))
+		bootstrap.NewLogger().Logger.Info("当前使用的任务通知：Postgres")
+	default:
+		panic("任务通知方式只支持poll、redis、postgres")
+	}
+}
+
+func NewNotifier() Notifier {
+	if lgNotifier != nil {
+		return lgNotifier
+	}
+	return NewPollNotifier(utils.TaskPollInterval)
+}
+
+// fallbackInterval 启用通知时兜底轮询的默认间隔
+func fallbackInterval(interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return utils.TaskFallbackPollInterval
+	}
+	return interval
+}
+
+// wake 非阻塞唤醒，生产者正在处理时合并为一次
+func wake(ch chan<- struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
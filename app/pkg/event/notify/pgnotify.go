@@ -0,0 +1,77 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4/stdlib"
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/bootstrap"
+	"github.com/qinguoyi/osproxy/bootstrap/plugins"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// PostgresNotifier postgres LISTEN/NOTIFY，通知随事务提交发出
+type PostgresNotifier struct {
+	interval time.Duration
+}
+
+func NewPostgresNotifier(interval time.Duration) *PostgresNotifier {
+	return &PostgresNotifier{
+		interval: interval,
+	}
+}
+
+// Publish 使用创建任务的连接发出通知，事务回滚时不发出
+func (n *PostgresNotifier) Publish(db *gorm.DB) error {
+	return db.Exec("SELECT pg_notify(?, '')", utils.TaskNotifyChannel).Error
+}
+
+// Subscribe 占用一个连接LISTEN，连接断开时重连
+func (n *PostgresNotifier) Subscribe(ctx context.Context, ch chan<- struct{}) {
+	for ctx.Err() == nil {
+		if err := n.listen(ctx, ch); err != nil && ctx.Err() == nil {
+			bootstrap.NewLogger().Logger.Error("监听任务通知失败", zap.Any("err", err.Error()))
+			time.Sleep(utils.TaskNotifyRetryInterval)
+		}
+	}
+}
+
+func (n *PostgresNotifier) listen(ctx context.Context, ch chan<- struct{}) error {
+	sqlDB, err := new(plugins.LangGoDB).Use("default").NewDB().DB()
+	if err != nil {
+		return err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(driverConn interface{}) error {
+		c, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return errors.New("当前数据库驱动不支持LISTEN")
+		}
+		if _, err := c.Conn().Exec(ctx, "LISTEN "+utils.TaskNotifyChannel); err != nil {
+			return err
+		}
+		defer func() {
+			_, _ = c.Conn().Exec(context.Background(), "UNLISTEN "+utils.TaskNotifyChannel)
+		}()
+		// 重新监听期间可能错过通知，先唤醒一次
+		wake(ch)
+		for {
+			if _, err := c.Conn().WaitForNotification(ctx); err != nil {
+				return err
+			}
+			wake(ch)
+		}
+	})
+}
+
+// PollInterval .
+func (n *PostgresNotifier) PollInterval() time.Duration {
+	return n.interval
+}
@@ -0,0 +1,32 @@
+package notify
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PollNotifier 不通知，生产者按间隔轮询
+type PollNotifier struct {
+	interval time.Duration
+}
+
+func NewPollNotifier(interval time.Duration) *PollNotifier {
+	return &PollNotifier{
+		interval: interval,
+	}
+}
+
+// Publish .
+func (n *PollNotifier) Publish(*gorm.DB) error {
+	return nil
+}
+
+// Subscribe .
+func (n *PollNotifier) Subscribe(context.Context, chan<- struct{}) {}
+
+// PollInterval .
+func (n *PollNotifier) PollInterval() time.Duration {
+	return n.interval
+}
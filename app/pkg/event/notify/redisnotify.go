@@ -0,0 +1,51 @@
+package notify
+
+import (
+	"context"
+	"time"
+
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/bootstrap/plugins"
+	"gorm.io/gorm"
+)
+
+// RedisNotifier redis发布订阅，事务内创建任务时可能先于提交唤醒，由兜底轮询补上
+type RedisNotifier struct {
+	interval time.Duration
+}
+
+func NewRedisNotifier(interval time.Duration) *RedisNotifier {
+	return &RedisNotifier{
+		interval: interval,
+	}
+}
+
+// Publish .
+func (n *RedisNotifier) Publish(*gorm.DB) error {
+	lgRedis := new(plugins.LangGoRedis).NewRedis()
+	return lgRedis.Publish(context.Background(), utils.TaskNotifyChannel, "").Err()
+}
+
+// Subscribe 断线由客户端自动重连
+func (n *RedisNotifier) Subscribe(ctx context.Context, ch chan<- struct{}) {
+	lgRedis := new(plugins.LangGoRedis).NewRedis()
+	pubSub := lgRedis.Subscribe(ctx, utils.TaskNotifyChannel)
+	defer pubSub.Close()
+	msgCh := pubSub.Channel()
+	for {
+		select {
+		case _, ok := <-msgCh:
+			if !ok {
+				return
+			}
+			wake(ch)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// PollInterval .
+func (n *RedisNotifier) PollInterval() time.Duration {
+	return n.interval
+}
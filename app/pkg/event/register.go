@@ -0,0 +1,208 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/qinguoyi/osproxy/app/models"
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+)
+
+/*
+任务注册：按任务类型注册带类型的handler，extraData自动反序列化为payload，
+可选抢占前处理、执行超时、worker数、抢占优先级及重试策略
+*/
+
+// TaskMeta 任务信息
+type TaskMeta struct {
+	ID          int64
+	Type        string
+	UserID      string
+	NodeId      string
+	ExecuteTime int // 本次是第几次执行
+	CreatedAt   *time.Time
+}
+
+// Handler 注册后的任务处理
+type Handler struct {
+	run        func(ctx context.Context, task models.TaskInfo) error
+	preProcess func(ctx context.Context, task models.TaskInfo) bool
+	deadLetter func(ctx context.Context, task models.TaskInfo, err error)
+	timeout    time.Duration
+	workers    int
+	priority   int
+	slots      chan struct{}
+	retry      RetryPolicy
+}
+
+// Option 注册选项
+type Option func(*Handler)
+
+// WithTimeout 单次执行的超时时间，超时后取消handler的ctx
+func WithTimeout(timeout time.Duration) Option {
+	return func(h *Handler) {
+		h.timeout = timeout
+	}
+}
+
+// WithConcurrency 任务类型独立worker池的worker数，即当前服务同时执行的上限，达到上限时不再抢占该类型的任务
+func WithConcurrency(n int) Option {
+	return func(h *Handler) {
+		h.workers = n
+	}
+}
+
+// WithPriority 抢占优先级，越大越先抢占
+func WithPriority(priority int) Option {
+	return func(h *Handler) {
+		h.priority = priority
+	}
+}
+
+// WithRetry 失败后的重试策略，未设置时使用默认策略
+func WithRetry(policy RetryPolicy) Option {
+	return func(h *Handler) {
+		h.retry = policy
+	}
+}
+
+// WithPreProcess 抢占前处理，返回false时当前服务不抢占该任务
+func WithPreProcess[T any](preProcess func(ctx context.Context, task TaskMeta, payload T) bool) Option {
+	return func(h *Handler) {
+		h.preProcess = func(ctx context.Context, task models.TaskInfo) bool {
+			payload, err := decode[T](task)
+			if err != nil {
+				return false
+			}
+			return preProcess(ctx, newTaskMeta(task), payload)
+		}
+	}
+}
+
+// WithDeadLetter 达到重试上限进入死信后的回调
+func WithDeadLetter[T any](deadLetter func(ctx context.Context, task TaskMeta, payload T, err error)) Option {
+	return func(h *Handler) {
+		h.deadLetter = func(ctx context.Context, task models.TaskInfo, err error) {
+			payload, decodeErr := decode[T](task)
+			if decodeErr != nil {
+				return
+			}
+			deadLetter(ctx, newTaskMeta(task), payload, err)
+		}
+	}
+}
+
+// Register 注册任务类型的handler，handler需要响应ctx的取消，服务停止或超时时ctx会被取消
+func Register[T any](taskType string, handler func(ctx context.Context, task TaskMeta, payload T) error,
+	opts ...Option) {
+	h := &Handler{
+		run: func(ctx context.Context, task models.TaskInfo) error {
+			payload, err := decode[T](task)
+			if err != nil {
+				return err
+			}
+			return handler(ctx, newTaskMeta(task), payload)
+		},
+		retry: DefaultRetryPolicy,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.setWorkers(h.workers)
+	NewEventsHandler().regHandler(taskType, h)
+}
+
+// setWorkers 设置worker数，小于1时使用默认值
+func (h *Handler) setWorkers(n int) {
+	if n < 1 {
+		n = utils.TaskPoolWorkers
+	}
+	h.workers = n
+	h.slots = make(chan struct{}, n)
+}
+
+// PreProcess 执行抢占前处理，未注册时直接抢占
+func (h *Handler) PreProcess(ctx context.Context, task models.TaskInfo) bool {
+	if h.preProcess == nil {
+		return true
+	}
+	return h.preProcess(ctx, task)
+}
+
+// DeadLetter 执行进入死信后的回调，未注册时不处理
+func (h *Handler) DeadLetter(ctx context.Context, task models.TaskInfo, err error) {
+	if h.deadLetter == nil {
+		return
+	}
+	h.deadLetter(ctx, task, err)
+}
+
+// TryAcquire 占用一个并发名额，抢占后到执行完成前一直占用，达到上限时返回false
+func (h *Handler) TryAcquire() bool {
+	select {
+	case h.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Release 释放并发名额
+func (h *Handler) Release() {
+	<-h.slots
+}
+
+// Run 执行任务，设置超时时间时派生带超时的ctx
+func (h *Handler) Run(ctx context.Context, task models.TaskInfo) error {
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	return h.run(ctx, task)
+}
+
+// Workers worker数
+func (h *Handler) Workers() int {
+	return h.workers
+}
+
+// Busy 已占用的并发名额，包括队列中等待执行的任务
+func (h *Handler) Busy() int {
+	return len(h.slots)
+}
+
+// Priority 抢占优先级
+func (h *Handler) Priority() int {
+	return h.priority
+}
+
+// RetryPolicy 重试策略
+func (h *Handler) RetryPolicy() RetryPolicy {
+	return h.retry
+}
+
+func newTaskMeta(task models.TaskInfo) TaskMeta {
+	return TaskMeta{
+		ID:          task.ID,
+		Type:        task.TaskType,
+		UserID:      task.UserID,
+		NodeId:      task.NodeId,
+		ExecuteTime: task.ExecuteTime,
+		CreatedAt:   task.CreatedAt,
+	}
+}
+
+// decode 反序列化extraData，为空时使用零值
+func decode[T any](task models.TaskInfo) (T, error) {
+	var payload T
+	if task.ExtraData == "" {
+		return payload, nil
+	}
+	if err := json.Unmarshal([]byte(task.ExtraData), &payload); err != nil {
+		return payload, fmt.Errorf("任务%d的extraData反序列化失败，详情：%s", task.ID, err.Error())
+	}
+	return payload, nil
+}
@@ -0,0 +1,93 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/qinguoyi/osproxy/app/models"
+	"github.com/qinguoyi/osproxy/config"
+)
+
+type testPayload struct {
+	Name string `json:"name"`
+}
+
+func TestRegisterDecodePayload(t *testing.T) {
+	var got testPayload
+	var meta TaskMeta
+	Register("testDecode", func(ctx context.Context, task TaskMeta, payload testPayload) error {
+		got, meta = payload, task
+		return nil
+	})
+	h := NewEventsHandler().GetHandler("testDecode")
+	if h == nil {
+		t.Fatal("Expected handler registered, but got nil")
+	}
+	task := models.TaskInfo{ID: 7, TaskType: "testDecode", ExecuteTime: 2, ExtraData: `{"name":"a"}`}
+	if err := h.Run(context.Background(), task); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if got.Name != "a" || meta.ID != 7 || meta.ExecuteTime != 2 {
+		t.Errorf("Expected payload a of task 7, but got %+v %+v", got, meta)
+	}
+
+	task.ExtraData = "{"
+	if err := h.Run(context.Background(), task); err == nil {
+		t.Error("Expected decode error, but got nil")
+	}
+	if h.PreProcess(context.Background(), task) != true {
+		t.Error("Expected preempt without preProcess")
+	}
+}
+
+func TestRegisterTimeout(t *testing.T) {
+	Register("testTimeout", func(ctx context.Context, task TaskMeta, payload testPayload) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, WithTimeout(10*time.Millisecond))
+	err := NewEventsHandler().GetHandler("testTimeout").Run(context.Background(), models.TaskInfo{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected %v, but got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestRegisterConcurrency(t *testing.T) {
+	Register("testConcurrency", func(ctx context.Context, task TaskMeta, payload testPayload) error {
+		return nil
+	}, WithConcurrency(2))
+	h := NewEventsHandler().GetHandler("testConcurrency")
+	if !h.TryAcquire() || !h.TryAcquire() {
+		t.Fatal("Expected 2 slots acquired")
+	}
+	if h.TryAcquire() {
+		t.Error("Expected acquire fail when reach limit")
+	}
+	h.Release()
+	if !h.TryAcquire() {
+		t.Error("Expected acquire after release")
+	}
+}
+
+func TestGetRetryPolicyDefault(t *testing.T) {
+	if p := NewEventsHandler().GetRetryPolicy("testUnknown"); p != DefaultRetryPolicy {
+		t.Errorf("Expected default policy, but got %+v", p)
+	}
+}
+
+func TestConfigurePool(t *testing.T) {
+	Register("testPool", func(ctx context.Context, task TaskMeta, payload testPayload) error {
+		return nil
+	}, WithConcurrency(1), WithPriority(100))
+	// 配置文件的键会被转成小写
+	NewEventsHandler().Configure(map[string]config.TaskPool{"testpool": {Workers: 3}})
+	h := NewEventsHandler().GetHandler("testPool")
+	if h.Workers() != 3 || h.Priority() != 100 {
+		t.Errorf("Expected 3 workers with priority 100, but got %d workers with priority %d", h.Workers(),
+			h.Priority())
+	}
+	if types := NewEventsHandler().Types(); types[0] != "testPool" {
+		t.Errorf("Expected testPool first, but got %v", types)
+	}
+}
@@ -0,0 +1,40 @@
+package event
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+)
+
+// RetryPolicy 任务失败后的重试策略
+type RetryPolicy struct {
+	MaxAttempts int           // 最多执行次数，达到后进入死信
+	BaseDelay   time.Duration // 首次重试的等待时间，之后按指数增长
+	MaxDelay    time.Duration // 最长等待时间
+}
+
+// DefaultRetryPolicy 未注册重试策略的任务类型使用
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: utils.CompensationTotal,
+	BaseDelay:   utils.TaskRetryBaseDelay,
+	MaxDelay:    utils.TaskRetryMaxDelay,
+}
+
+// Backoff 第attempt次失败后的等待时间，指数增长并在后一半范围内随机抖动，避免同时失败的任务同时重试
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := p.MaxDelay
+	if shift := attempt - 1; shift < 32 {
+		if d := p.BaseDelay << uint(shift); d > 0 && d < p.MaxDelay {
+			delay = d
+		}
+	}
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
@@ -0,0 +1,28 @@
+package event
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	cases := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{5, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+	for _, c := range cases {
+		for i := 0; i < 20; i++ {
+			d := p.Backoff(c.attempt)
+			if d < c.max/2 || d > c.max {
+				t.Errorf("Expected backoff of attempt %d in [%v, %v], but got %v", c.attempt, c.max/2, c.max, d)
+			}
+		}
+	}
+}
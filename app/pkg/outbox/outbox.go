@@ -0,0 +1,135 @@
+package outbox
+
+/*
+发件箱：对象事件和元数据在同一事务内写入outbox表，主节点按自增ID顺序发布到消息队列，
+发布成功后标记已发布；发布方按事件ID去重，主节点切换或标记失败时不会重复发布
+自增ID在写入时分配，并发事务的提交顺序可能与ID顺序不同，后提交的较小ID会在之后的批次发布，
+不同对象的事件不保证顺序，消费方需要按事件中的对象自行处理
+redis：redis Streams，其他消息队列实现Publisher即可接入
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/qinguoyi/osproxy/app/models"
+	"github.com/qinguoyi/osproxy/app/pkg/base"
+	"github.com/qinguoyi/osproxy/app/pkg/repo"
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/bootstrap"
+	"github.com/qinguoyi/osproxy/bootstrap/plugins"
+	"github.com/qinguoyi/osproxy/config"
+)
+
+// Publisher 事件发布
+type Publisher interface {
+	// Publish 发布事件，同一事件ID重复发布时需要保证只投递一次
+	Publish(context.Context, models.ObjectEvent) error
+}
+
+var (
+	lgPublisher Publisher
+)
+
+func init() {
+	base.RegSingleton(base.SingletonJob{
+		Name:     "outboxRelay",
+		Interval: utils.OutboxRelayInterval,
+		Run:      relay,
+	})
+	base.RegSingleton(base.SingletonJob{
+		Name:     "outboxClean",
+		Interval: utils.OutboxCleanInterval,
+		Run:      clean,
+	})
+}
+
+func InitPublisher(conf *config.Configuration) {
+	switch conf.Outbox.Publisher {
+	case "":
+		bootstrap.NewLogger().Logger.Info("未配置对象事件发布")
+	case utils.OutboxRedis:
+		stream, maxLen := conf.Outbox.Stream, conf.Outbox.MaxLen
+		if stream == "" {
+			stream = utils.OutboxDefaultStream
+		}
+		if maxLen <= 0 {
+			maxLen = utils.OutboxDefaultMaxLen
+		}
+		lgPublisher = NewRedisPublisher(stream, maxLen)
+		bootstrap.NewLogger().Logger.Info("当前使用的对象事件发布：Redis Streams")
+	default:
+		panic("对象事件发布方式只支持redis")
+	}
+}
+
+// NewPublisher 未配置时返回nil
+func NewPublisher() Publisher {
+	return lgPublisher
+}
+
+// relay 按ID顺序发布未发布的事件，发布失败时停止，下次从失败的事件继续
+func relay(ctx context.Context, token int64) error {
+	publisher := NewPublisher()
+	if publisher == nil {
+		return nil
+	}
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB().WithContext(ctx)
+	lgRedis := new(plugins.LangGoRedis).NewRedis()
+	for {
+		if ok, err := base.CheckFencing(ctx, lgRedis, token); err != nil || !ok {
+			return err
+		}
+		pending, err := repo.NewOutboxRepo().FindPending(lgDB, utils.OutboxBatch)
+		if err != nil || len(pending) == 0 {
+			return err
+		}
+		ids, publishErr := publishBatch(ctx, publisher, pending)
+		if len(ids) != 0 {
+			if err := repo.NewOutboxRepo().MarkPublished(lgDB, ids); err != nil {
+				return err
+			}
+		}
+		if publishErr != nil {
+			return fmt.Errorf("发布事件失败，详情：%s", publishErr.Error())
+		}
+	}
+}
+
+// publishBatch 依次发布，返回发布成功的事件，遇到失败时停止
+func publishBatch(ctx context.Context, publisher Publisher, pending []models.Outbox) ([]int64, error) {
+	var ids []int64
+	for _, i := range pending {
+		if err := publisher.Publish(ctx, toEvent(i)); err != nil {
+			return ids, err
+		}
+		ids = append(ids, i.ID)
+	}
+	return ids, nil
+}
+
+// clean 清理已发布的事件
+func clean(ctx context.Context, token int64) error {
+	if NewPublisher() == nil {
+		return nil
+	}
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB().WithContext(ctx)
+	_, err := repo.NewOutboxRepo().DeletePublishedBefore(lgDB, time.Now().Add(-utils.OutboxRetention))
+	return err
+}
+
+func toEvent(m models.Outbox) models.ObjectEvent {
+	event := models.ObjectEvent{
+		ID:   strconv.FormatInt(m.ID, 10),
+		Type: m.EventType,
+		Uid:  strconv.FormatInt(m.Uid, 10),
+		Data: json.RawMessage(m.Payload),
+	}
+	if m.CreatedAt != nil {
+		event.Time = *m.CreatedAt
+	}
+	return event
+}
@@ -0,0 +1,150 @@
+package outbox
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/qinguoyi/osproxy/app/models"
+)
+
+type fakePublisher struct {
+	failAt string
+	events []models.ObjectEvent
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, event models.ObjectEvent) error {
+	if event.ID == p.failAt {
+		return errors.New("publish failed")
+	}
+	p.events = append(p.events, event)
+	return nil
+}
+
+func TestPublishBatch(t *testing.T) {
+	pending := []models.Outbox{
+		{ID: 1, EventType: "object.uploaded", Uid: 10, Payload: `{}`},
+		{ID: 2, EventType: "object.uploaded", Uid: 11, Payload: `{}`},
+		{ID: 3, EventType: "object.deleted", Uid: 10, Payload: `{}`},
+	}
+
+	publisher := &fakePublisher{}
+	ids, err := publishBatch(context.Background(), publisher, pending)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if len(ids) != 3 || publisher.events[2].ID != "3" || publisher.events[2].Uid != "10" {
+		t.Errorf("Expected all events to be published in order, but got %v", ids)
+	}
+
+	// 失败时停止，之后的事件留到下次发布
+	publisher = &fakePublisher{failAt: "2"}
+	ids, err = publishBatch(context.Background(), publisher, pending)
+	if err == nil {
+		t.Fatalf("Expected error, but got nil")
+	}
+	if len(ids) != 1 || ids[0] != 1 || len(publisher.events) != 1 {
+		t.Errorf("Expected only the first event to be marked published, but got %v", ids)
+	}
+}
+
+// fakeStream 只支持发布使用的lua脚本
+type fakeStream struct {
+	mu      sync.Mutex
+	dedup   map[string]bool
+	entries []string
+}
+
+func (s *fakeStream) exec(args []string) string {
+	if len(args) < 7 || args[1] != xaddCommand {
+		return "-ERR unsupported\r\n"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dedup[args[4]] {
+		return "$-1\r\n"
+	}
+	s.dedup[args[4]] = true
+	s.entries = append(s.entries, args[7])
+	id := fmt.Sprintf("%d-0", len(s.entries))
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(id), id)
+}
+
+func newFakeStream(t *testing.T) (*fakeStream, *redis.Client) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &fakeStream{dedup: map[string]bool{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					args, err := readCommand(r)
+					if err != nil {
+						return
+					}
+					if _, err := conn.Write([]byte(s.exec(args))); err != nil {
+						return
+					}
+				}
+			}(conn)
+		}
+	}()
+	client := redis.NewClient(&redis.Options{Addr: ln.Addr().String()})
+	t.Cleanup(func() { client.Close() })
+	return s, client
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	var n int
+	if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		var size int
+		if _, err := fmt.Fscanf(r, "$%d\r\n", &size); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func TestRedisPublisherDedup(t *testing.T) {
+	stream, client := newFakeStream(t)
+	publisher := &RedisPublisher{stream: "events", maxLen: 100, store: client}
+
+	event := models.ObjectEvent{ID: "1", Type: "object.uploaded", Uid: "10", Data: []byte(`{}`)}
+	for i := 0; i < 2; i++ {
+		if err := publisher.Publish(context.Background(), event); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+	}
+	event.ID = "2"
+	if err := publisher.Publish(context.Background(), event); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	// 主节点切换后重复发布同一事件只投递一次
+	if len(stream.entries) != 2 || stream.entries[0] != "1" || stream.entries[1] != "2" {
+		t.Errorf("Expected each event to be delivered once, but got %v", stream.entries)
+	}
+}
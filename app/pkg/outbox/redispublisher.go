@@ -0,0 +1,50 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/qinguoyi/osproxy/app/models"
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/bootstrap/plugins"
+)
+
+// 去重标记和XADD在同一脚本内执行，标记存在时说明已经发布过
+const xaddCommand = `if redis.call("SET", KEYS[2], "1", "NX", "EX", ARGV[1]) then
+    return redis.call("XADD", KEYS[1], "MAXLEN", "~", ARGV[2], "*", "id", ARGV[3], "type", ARGV[4], "uid", ARGV[5], "time", ARGV[6], "data", ARGV[7])
+else
+    return false
+end` // lua脚本，用于去重发布
+
+// RedisPublisher redis Streams
+type RedisPublisher struct {
+	stream string
+	maxLen int64
+	store  *redis.Client // 为nil时使用全局的redis客户端
+}
+
+func NewRedisPublisher(stream string, maxLen int64) *RedisPublisher {
+	return &RedisPublisher{
+		stream: stream,
+		maxLen: maxLen,
+	}
+}
+
+// Publish 按事件ID去重，已发布过的事件直接返回成功
+func (p *RedisPublisher) Publish(ctx context.Context, event models.ObjectEvent) error {
+	lgRedis := p.store
+	if lgRedis == nil {
+		lgRedis = new(plugins.LangGoRedis).NewRedis()
+	}
+	dedupKey := fmt.Sprintf("%s:published:%s", p.stream, event.ID)
+	err := lgRedis.Eval(ctx, xaddCommand, []string{p.stream, dedupKey},
+		utils.OutboxDedupTTL, p.maxLen, event.ID, event.Type, event.Uid, event.Time.Format(time.RFC3339Nano),
+		string(event.Data)).Err()
+	// 脚本返回false时redis客户端返回redis.Nil
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	return nil
+}
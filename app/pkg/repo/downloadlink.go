@@ -0,0 +1,66 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/qinguoyi/osproxy/app/models"
+	"gorm.io/gorm"
+)
+
+type downloadLinkRepo struct{}
+
+func NewDownloadLinkRepo() *downloadLinkRepo { return &downloadLinkRepo{} }
+
+// GetByLinkID .
+func (r *downloadLinkRepo) GetByLinkID(db *gorm.DB, linkID string) (*models.DownloadLink, error) {
+	ret := &models.DownloadLink{}
+	if err := db.Where("link_id = ?", linkID).First(ret).Error; err != nil {
+		return ret, err
+	}
+	return ret, nil
+}
+
+// GetActiveByUidList 查询uid下未吊销的链接
+func (r *downloadLinkRepo) GetActiveByUidList(db *gorm.DB, uidList []int64) ([]models.DownloadLink, error) {
+	var ret []models.DownloadLink
+	if err := db.Where("uid in ? and revoked = ?", uidList, false).Find(&ret).Error; err != nil {
+		return ret, err
+	}
+	return ret, nil
+}
+
+// BatchCreate .
+func (r *downloadLinkRepo) BatchCreate(db *gorm.DB, m *[]models.DownloadLink) error {
+	err := db.Create(m).Error
+	return err
+}
+
+// RevokeByUidList 吊销uid下的全部链接
+func (r *downloadLinkRepo) RevokeByUidList(db *gorm.DB, uidList []int64) error {
+	now := time.Now()
+	err := db.Model(&models.DownloadLink{}).Where("uid in ? and revoked = ?", uidList, false).
+		UpdateColumns(map[string]interface{}{
+			"revoked":    true,
+			"updated_at": &now,
+		}).Error
+	return err
+}
+
+// RevokeByLinkIDList 吊销指定链接
+func (r *downloadLinkRepo) RevokeByLinkIDList(db *gorm.DB, linkIDList []string) error {
+	now := time.Now()
+	err := db.Model(&models.DownloadLink{}).Where("link_id in ? and revoked = ?", linkIDList, false).
+		UpdateColumns(map[string]interface{}{
+			"revoked":    true,
+			"updated_at": &now,
+		}).Error
+	return err
+}
+
+// IncrDownloadCount 原子扣减一次下载次数，次数用尽或链接已吊销时更新数量为0
+func (r *downloadLinkRepo) IncrDownloadCount(db *gorm.DB, linkID string) int64 {
+	affected := db.Model(&models.DownloadLink{}).Where(
+		"link_id = ? and revoked = ? and download_count < max_download", linkID, false).
+		UpdateColumn("download_count", gorm.Expr("download_count + ?", 1))
+	return affected.RowsAffected
+}
@@ -1,7 +1,11 @@
 package repo
 
 import (
+	"encoding/json"
+
 	"github.com/qinguoyi/osproxy/app/models"
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/bootstrap"
 	"gorm.io/gorm"
 )
 
@@ -26,8 +30,10 @@ func (r *metaDataInfoRepo) GetByUid(db *gorm.DB, uid int64) (*models.MetaDataInf
 // GetResumeByMd5()函数用于根据md5获取秒传数据
 func (r *metaDataInfoRepo) GetResumeByMd5(db *gorm.DB, md5 []string) ([]models.MetaDataInfo, error) {
 	var ret []models.MetaDataInfo
-	if err := db.Where("md5 in ? and status = 1 and multi_part = ?", md5, false). // Where()函数用于指定查询条件，这里的查询条件是md5 in ? and status = 1 and multi_part = ?
-											Find(&ret).Error; err != nil { // Find()函数用于查询数据，这里的查询条件是md5 in ? and status = 1 and multi_part = ?
+	// 扫描中、已隔离或扫描失败的数据不能秒传；md5为空的数据未经服务端校验，不能秒传
+	if err := db.Where("md5 in ? and md5 <> '' and status = 1 and multi_part = ?", md5, false). // Where()函数用于指定查询条件，这里的查询条件是md5 in ? and status = 1 and multi_part = ?
+													Where("(scan_status is null or scan_status in ?)", []string{"", utils.ScanStatusClean}).
+													Find(&ret).Error; err != nil { // Find()函数用于查询数据，这里的查询条件是md5 in ? and status = 1 and multi_part = ?
 		return ret, err
 	}
 	return ret, nil
@@ -73,9 +79,81 @@ func (r *metaDataInfoRepo) BatchCreate(db *gorm.DB, m *[]models.MetaDataInfo) er
 	return err
 }
 
+// BatchCreateWithEvent 批量创建元数据，并在同一事务内将每个对象的事件写入发件箱
+// 开启内容扫描时，上传完成且未复用扫描结果的对象标记为待扫描，并创建扫描任务
+func (r *metaDataInfoRepo) BatchCreateWithEvent(db *gorm.DB, m *[]models.MetaDataInfo, eventType string) error {
+	scan := eventType == utils.EventObjectUploaded && bootstrap.NewConfig("").Scan.Mode != ""
+	return db.Transaction(func(tx *gorm.DB) error {
+		var scanUids []int64
+		for i := range *m {
+			if scan && (*m)[i].ScanStatus == "" {
+				(*m)[i].ScanStatus = utils.ScanStatusPending
+				scanUids = append(scanUids, (*m)[i].UID)
+			}
+		}
+		if err := r.BatchCreate(tx, m); err != nil {
+			return err
+		}
+		for _, uid := range scanUids {
+			if err := createScanTask(tx, uid); err != nil {
+				return err
+			}
+		}
+		for i := range *m {
+			metaData := &(*m)[i]
+			if err := NewOutboxRepo().Append(tx, eventType, metaData.UID,
+				models.NewObjectEventData(metaData)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 // Updates .
 // Updates()函数用于更新元数据信息,更新到数据库中
 func (r *metaDataInfoRepo) Updates(db *gorm.DB, uid int64, columns map[string]interface{}) error {
 	err := db.Model(&models.MetaDataInfo{}).Where("uid = ?", uid).Updates(columns).Error
 	return err
 }
+
+// UpdatesWithEvent 更新元数据，并在同一事务内将更新后的对象事件写入发件箱
+// 开启内容扫描时，上传完成且未复用扫描结果的对象标记为待扫描，并创建扫描任务
+func (r *metaDataInfoRepo) UpdatesWithEvent(db *gorm.DB, uid int64, columns map[string]interface{},
+	eventType string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		scan := false
+		if status, _ := columns["scan_status"].(string); status == "" && eventType == utils.EventObjectUploaded &&
+			bootstrap.NewConfig("").Scan.Mode != "" {
+			scan = true
+			columns["scan_status"] = utils.ScanStatusPending
+		}
+		if err := r.Updates(tx, uid, columns); err != nil {
+			return err
+		}
+		if scan {
+			if err := createScanTask(tx, uid); err != nil {
+				return err
+			}
+		}
+		metaData, err := r.GetByUid(tx, uid)
+		if err != nil {
+			return err
+		}
+		return NewOutboxRepo().Append(tx, eventType, uid, models.NewObjectEventData(metaData))
+	})
+}
+
+// createScanTask 创建扫描任务，需要和标记待扫描使用同一个事务
+func createScanTask(db *gorm.DB, uid int64) error {
+	b, err := json.Marshal(models.ScanInfo{StorageUid: uid})
+	if err != nil {
+		return err
+	}
+	return NewTaskRepo().Create(db, &models.TaskInfo{
+		Status:    utils.TaskStatusUndo,
+		TaskType:  utils.TaskScan,
+		ExtraData: string(b),
+		RefId:     uid,
+	})
+}
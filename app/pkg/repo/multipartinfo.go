@@ -36,6 +36,26 @@ func (r *multiPartInfoRepo) GetPartNumByUid(db *gorm.DB, uid int64) ([]models.Mu
 	return ret, nil
 }
 
+// GetUploadedPartsByUid 按分片序号查询已上传的分片
+func (r *multiPartInfoRepo) GetUploadedPartsByUid(db *gorm.DB, uid int64) ([]models.MultiPartInfo, error) {
+	var ret []models.MultiPartInfo
+	if err := db.Model(&models.MultiPartInfo{}).Where(
+		"storage_uid = ? and status = ?", uid, 1).Order("chunk_num ASC").Find(&ret).Error; err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// GetUploadedPartByChunkNum 查询指定序号已上传的分片
+func (r *multiPartInfoRepo) GetUploadedPartByChunkNum(db *gorm.DB, uid int64, chunkNum int) ([]models.MultiPartInfo, error) {
+	var ret []models.MultiPartInfo
+	if err := db.Model(&models.MultiPartInfo{}).Where(
+		"storage_uid = ? and chunk_num = ? and status = ?", uid, chunkNum, 1).Find(&ret).Error; err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
 // GetPartInfo .
 // GetPartInfo()函数用于根据uid、num、md5获取多文件上传信息
 func (r *multiPartInfoRepo) GetPartInfo(db *gorm.DB, uid, num int64, md5 string) ([]models.MultiPartInfo, error) {
@@ -60,6 +80,18 @@ func (r *multiPartInfoRepo) Create(db *gorm.DB, m *models.MultiPartInfo) error {
 	return err
 }
 
+// ReplacePart 同一序号重新上传时，旧分片置为无效后写入新分片
+func (r *multiPartInfoRepo) ReplacePart(db *gorm.DB, m *models.MultiPartInfo) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.MultiPartInfo{}).Where(
+			"storage_uid = ? and chunk_num = ? and status = 1", m.StorageUid, m.ChunkNum).
+			Update("status", -1).Error; err != nil {
+			return err
+		}
+		return tx.Create(m).Error
+	})
+}
+
 // BatchCreate .
 func (r *multiPartInfoRepo) BatchCreate(db *gorm.DB, m *[]models.MultiPartInfo) error {
 	err := db.Create(m).Error
@@ -0,0 +1,54 @@
+package repo
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/qinguoyi/osproxy/app/models"
+	"github.com/qinguoyi/osproxy/bootstrap"
+	"gorm.io/gorm"
+)
+
+type outboxRepo struct{}
+
+func NewOutboxRepo() *outboxRepo { return &outboxRepo{} }
+
+// Append 写入发件箱，未配置发布方式时不写入；需要和业务更新使用同一个事务
+func (r *outboxRepo) Append(db *gorm.DB, eventType string, uid int64, data interface{}) error {
+	if bootstrap.NewConfig("").Outbox.Publisher == "" {
+		return nil
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return db.Create(&models.Outbox{
+		EventType: eventType,
+		Uid:       uid,
+		Payload:   string(b),
+	}).Error
+}
+
+// FindPending 按ID顺序查询未发布的事件，ID顺序不一定是事务提交顺序
+func (r *outboxRepo) FindPending(db *gorm.DB, limit int) ([]models.Outbox, error) {
+	var ret []models.Outbox
+	if err := db.Where("published = ?", false).Order("id asc").Limit(limit).Find(&ret).Error; err != nil {
+		return ret, err
+	}
+	return ret, nil
+}
+
+// MarkPublished .
+func (r *outboxRepo) MarkPublished(db *gorm.DB, ids []int64) error {
+	now := time.Now()
+	return db.Model(&models.Outbox{}).Where("id in ?", ids).UpdateColumns(map[string]interface{}{
+		"published":    true,
+		"published_at": &now,
+	}).Error
+}
+
+// DeletePublishedBefore 清理已发布的事件
+func (r *outboxRepo) DeletePublishedBefore(db *gorm.DB, t time.Time) (int64, error) {
+	affected := db.Where("published = ? and published_at < ?", true, t).Delete(&models.Outbox{})
+	return affected.RowsAffected, affected.Error
+}
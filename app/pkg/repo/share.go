@@ -0,0 +1,59 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/qinguoyi/osproxy/app/models"
+	"gorm.io/gorm"
+)
+
+type shareRepo struct{}
+
+func NewShareRepo() *shareRepo { return &shareRepo{} }
+
+// GetByCode .
+func (r *shareRepo) GetByCode(db *gorm.DB, code string) (*models.Share, error) {
+	ret := &models.Share{}
+	if err := db.Where("code = ?", code).First(ret).Error; err != nil {
+		return ret, err
+	}
+	return ret, nil
+}
+
+// Create .
+func (r *shareRepo) Create(db *gorm.DB, m *models.Share) error {
+	err := db.Create(m).Error
+	return err
+}
+
+// IncrDownloadCount 原子扣减一次访问次数，次数用尽或已过期时更新数量为0
+func (r *shareRepo) IncrDownloadCount(db *gorm.DB, code string) int64 {
+	affected := db.Model(&models.Share{}).Where(
+		"code = ? and expire_at > ? and (max_download = 0 or download_count < max_download)", code, time.Now()).
+		UpdateColumn("download_count", gorm.Expr("download_count + ?", 1))
+	return affected.RowsAffected
+}
+
+type shareAccessLogRepo struct{}
+
+func NewShareAccessLogRepo() *shareAccessLogRepo { return &shareAccessLogRepo{} }
+
+// Create .
+func (r *shareAccessLogRepo) Create(db *gorm.DB, m *models.ShareAccessLog) error {
+	err := db.Create(m).Error
+	return err
+}
+
+// PageByCode 按访问时间倒序分页查询访问日志
+func (r *shareAccessLogRepo) PageByCode(db *gorm.DB, code string, page, size int) ([]models.ShareAccessLog, int64, error) {
+	var ret []models.ShareAccessLog
+	var total int64
+	query := db.Model(&models.ShareAccessLog{}).Where("share_code = ?", code)
+	if err := query.Count(&total).Error; err != nil {
+		return ret, 0, err
+	}
+	if err := query.Order("id desc").Offset((page - 1) * size).Limit(size).Find(&ret).Error; err != nil {
+		return ret, 0, err
+	}
+	return ret, total, nil
+}
@@ -1,7 +1,10 @@
 package repo
 
 import (
+	"time"
+
 	"github.com/qinguoyi/osproxy/app/models"
+	"github.com/qinguoyi/osproxy/app/pkg/event/notify"
 	"github.com/qinguoyi/osproxy/app/pkg/utils"
 	"gorm.io/gorm"
 )
@@ -22,18 +25,54 @@ func (r *taskInfoRepo) GetByID(db *gorm.DB, taskID int64) (*models.TaskInfo, err
 }
 
 // PreemptiveTaskByID 抢占任务  这里的update需要查看更新的数量，更新数量为0的时候，err也是nil；其他的update先不管
-func (r *taskInfoRepo) PreemptiveTaskByID(db *gorm.DB, taskID int64, nodeId string) int64 {
+func (r *taskInfoRepo) PreemptiveTaskByID(db *gorm.DB, taskID int64, nodeId string, leaseUntil time.Time) int64 {
 	affected := db.Model(&models.TaskInfo{}).Where("id = ? and status = ?", taskID, utils.TaskStatusUndo).
 		UpdateColumns(map[string]interface{}{
-			"status":  utils.TaskStatusRunning,
-			"node_id": nodeId,
+			"status":      utils.TaskStatusRunning,
+			"node_id":     nodeId,
+			"lease_until": leaseUntil,
+		})
+	return affected.RowsAffected
+}
+
+// RenewLeaseByIDs 续期当前进程抢占的执行中任务的租约，已被回收的任务不会续期
+// 同一节点重启后，之前进程遗留的任务不在其中，租约过期后由其他服务回收
+func (r *taskInfoRepo) RenewLeaseByIDs(db *gorm.DB, taskIDs []int64, nodeId string, leaseUntil time.Time) int64 {
+	affected := db.Model(&models.TaskInfo{}).Where("id in ? and node_id = ? and status = ?", taskIDs, nodeId,
+		utils.TaskStatusRunning).
+		UpdateColumn("lease_until", leaseUntil)
+	return affected.RowsAffected
+}
+
+// FindLeaseExpired 租约过期的执行中任务，没有租约的任务由所在服务停止时重置
+func (r *taskInfoRepo) FindLeaseExpired(db *gorm.DB) ([]models.TaskInfo, error) {
+	var ret []models.TaskInfo
+	if err := db.Where("status = ? and lease_until < ?", utils.TaskStatusRunning, time.Now()).
+		Find(&ret).Error; err != nil {
+		return ret, err
+	}
+	return ret, nil
+}
+
+// ReclaimTaskByID 回收租约过期的任务，再次判断租约避免回收刚续期的任务
+func (r *taskInfoRepo) ReclaimTaskByID(db *gorm.DB, taskID int64, nodeId string, status, exeTime int,
+	nextRunAt time.Time) int64 {
+	affected := db.Model(&models.TaskInfo{}).Where(
+		"id = ? and node_id = ? and status = ? and lease_until < ?", taskID, nodeId, utils.TaskStatusRunning,
+		time.Now()).
+		UpdateColumns(map[string]interface{}{
+			"status":       status,
+			"node_id":      "",
+			"execute_time": exeTime,
+			"next_run_at":  nextRunAt,
 		})
 	return affected.RowsAffected
 }
 
-// FinishTaskByID 完成任务
-func (r *taskInfoRepo) FinishTaskByID(db *gorm.DB, taskID int64, exeTime int) int64 {
-	affected := db.Model(&models.TaskInfo{}).Where("id = ? and status = ?", taskID, utils.TaskStatusRunning).
+// FinishTaskByID 完成任务，任务已被其他服务回收时不更新
+func (r *taskInfoRepo) FinishTaskByID(db *gorm.DB, taskID int64, nodeId string, exeTime int) int64 {
+	affected := db.Model(&models.TaskInfo{}).Where(
+		"id = ? and node_id = ? and status = ?", taskID, nodeId, utils.TaskStatusRunning).
 		UpdateColumns(map[string]interface{}{
 			"status":       utils.TaskStatusFinish,
 			"execute_time": exeTime,
@@ -41,9 +80,10 @@ func (r *taskInfoRepo) FinishTaskByID(db *gorm.DB, taskID int64, exeTime int) in
 	return affected.RowsAffected
 }
 
-// ErrorTaskByID 任务失败
-func (r *taskInfoRepo) ErrorTaskByID(db *gorm.DB, taskID int64, exeTime int) int64 {
-	affected := db.Model(&models.TaskInfo{}).Where("id = ? and status = ?", taskID, utils.TaskStatusRunning).
+// ErrorTaskByID 任务失败，任务已被其他服务回收时不更新
+func (r *taskInfoRepo) ErrorTaskByID(db *gorm.DB, taskID int64, nodeId string, exeTime int) int64 {
+	affected := db.Model(&models.TaskInfo{}).Where(
+		"id = ? and node_id = ? and status = ?", taskID, nodeId, utils.TaskStatusRunning).
 		UpdateColumns(map[string]interface{}{
 			"status":       utils.TaskStatusError,
 			"execute_time": exeTime,
@@ -62,6 +102,122 @@ func (r *taskInfoRepo) ResetTaskByID(db *gorm.DB, taskID int64, nodeId string) i
 	return affected.RowsAffected
 }
 
+// RetryTaskByID 任务失败后等待重试，到达下次执行时间前不会被抢占
+func (r *taskInfoRepo) RetryTaskByID(db *gorm.DB, taskID int64, nodeId string, exeTime int, nextRunAt time.Time) int64 {
+	affected := db.Model(&models.TaskInfo{}).Where(
+		"id = ? and node_id = ? and status =?", taskID, nodeId, utils.TaskStatusRunning).
+		UpdateColumns(map[string]interface{}{
+			"status":       utils.TaskStatusUndo,
+			"node_id":      "",
+			"execute_time": exeTime,
+			"next_run_at":  nextRunAt,
+		})
+	return affected.RowsAffected
+}
+
+// RequeueTaskByID 失败或取消的任务重新执行，执行次数重新计算；等待重试的任务立即执行
+func (r *taskInfoRepo) RequeueTaskByID(db *gorm.DB, taskID int64) int64 {
+	affected := db.Model(&models.TaskInfo{}).Where("id = ? and status in ?", taskID,
+		[]int{utils.TaskStatusUndo, utils.TaskStatusError, utils.TaskStatusCancel}).
+		UpdateColumns(map[string]interface{}{
+			"status":       utils.TaskStatusUndo,
+			"node_id":      "",
+			"execute_time": 1,
+			"next_run_at":  nil,
+		})
+	if affected.RowsAffected != 0 {
+		_ = notify.NewNotifier().Publish(db)
+	}
+	return affected.RowsAffected
+}
+
+// CancelTaskByID 取消未执行的任务，执行中的任务不能取消
+func (r *taskInfoRepo) CancelTaskByID(db *gorm.DB, taskID int64) int64 {
+	affected := db.Model(&models.TaskInfo{}).Where("id = ? and status = ?", taskID, utils.TaskStatusUndo).
+		UpdateColumn("status", utils.TaskStatusCancel)
+	return affected.RowsAffected
+}
+
+// FindRunnable 已到执行时间的未执行任务，按创建顺序排列
+func (r *taskInfoRepo) FindRunnable(db *gorm.DB) ([]models.TaskInfo, error) {
+	var ret []models.TaskInfo
+	if err := db.Where("status = ? and (next_run_at is null or next_run_at <= ?)", utils.TaskStatusUndo,
+		time.Now()).Order("id asc").Find(&ret).Error; err != nil {
+		return ret, err
+	}
+	return ret, nil
+}
+
+// Page 按id倒序分页查询任务
+func (r *taskInfoRepo) Page(db *gorm.DB, filter models.TaskFilter, page, size int) ([]models.TaskInfo, int64, error) {
+	var ret []models.TaskInfo
+	var total int64
+	query := db.Model(&models.TaskInfo{})
+	if filter.TaskType != "" {
+		query = query.Where("task_type = ?", filter.TaskType)
+	}
+	if filter.Status != nil {
+		query = query.Where("status = ?", *filter.Status)
+	}
+	if filter.NodeId != "" {
+		query = query.Where("node_id = ?", filter.NodeId)
+	}
+	if filter.Start != nil {
+		query = query.Where("created_at >= ?", *filter.Start)
+	}
+	if filter.End != nil {
+		query = query.Where("created_at < ?", *filter.End)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return ret, 0, err
+	}
+	if err := query.Order("id desc").Offset((page - 1) * size).Limit(size).Find(&ret).Error; err != nil {
+		return ret, 0, err
+	}
+	return ret, total, nil
+}
+
+// CountByStatus 按任务类型及状态统计数量
+func (r *taskInfoRepo) CountByStatus(db *gorm.DB) ([]models.TaskCount, error) {
+	var ret []models.TaskCount
+	if err := db.Model(&models.TaskInfo{}).Select("task_type, status, count(*) as count").
+		Group("task_type, status").Scan(&ret).Error; err != nil {
+		return ret, err
+	}
+	return ret, nil
+}
+
+// CountRunnable 按任务类型统计已到执行时间的未执行任务
+func (r *taskInfoRepo) CountRunnable(db *gorm.DB) ([]models.TaskCount, error) {
+	var ret []models.TaskCount
+	if err := db.Model(&models.TaskInfo{}).Select("task_type, status, count(*) as count").
+		Where("status = ? and (next_run_at is null or next_run_at <= ?)", utils.TaskStatusUndo, time.Now()).
+		Group("task_type, status").Scan(&ret).Error; err != nil {
+		return ret, err
+	}
+	return ret, nil
+}
+
+// GetLatestByRef 业务ID关联的最近一个任务
+func (r *taskInfoRepo) GetLatestByRef(db *gorm.DB, taskType string, refId int64) (*models.TaskInfo, error) {
+	ret := &models.TaskInfo{}
+	if err := db.Where("task_type = ? and ref_id = ?", taskType, refId).Order("id desc").
+		First(ret).Error; err != nil {
+		return ret, err
+	}
+	return ret, nil
+}
+
+// FindByRef 业务ID关联的任务，按创建顺序
+func (r *taskInfoRepo) FindByRef(db *gorm.DB, taskType string, refId int64) ([]models.TaskInfo, error) {
+	var ret []models.TaskInfo
+	if err := db.Where("task_type = ? and ref_id = ?", taskType, refId).Order("id asc").
+		Find(&ret).Error; err != nil {
+		return ret, err
+	}
+	return ret, nil
+}
+
 // FindByStatus .
 func (r *taskInfoRepo) FindByStatus(db *gorm.DB, status int) ([]models.TaskInfo, error) {
 	var ret []models.TaskInfo
@@ -71,15 +227,21 @@ func (r *taskInfoRepo) FindByStatus(db *gorm.DB, status int) ([]models.TaskInfo,
 	return ret, nil
 }
 
-// Create .
+// Create 创建后通知各服务的生产者，通知失败时由轮询兜底
 func (r *taskInfoRepo) Create(db *gorm.DB, m *models.TaskInfo) error {
 	err := db.Create(m).Error
+	if err == nil {
+		_ = notify.NewNotifier().Publish(db)
+	}
 	return err
 }
 
 // BatchCreate .
 func (r *taskInfoRepo) BatchCreate(db *gorm.DB, m []*models.TaskInfo) error {
 	err := db.Create(m).Error
+	if err == nil {
+		_ = notify.NewNotifier().Publish(db)
+	}
 	return err
 }
 
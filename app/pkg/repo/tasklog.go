@@ -31,3 +31,21 @@ func (r *taskLogRepo) GetByTaskID(db *gorm.DB, TaskID int64) (*models.TaskLog, e
 	}
 	return ret, nil
 }
+
+// GetByID .
+func (r *taskLogRepo) GetByID(db *gorm.DB, logID int64) (*models.TaskLog, error) {
+	ret := &models.TaskLog{}
+	if err := db.Where("id = ?", logID).First(ret).Error; err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// ListByTaskID 任务的全部执行日志，按执行顺序排列
+func (r *taskLogRepo) ListByTaskID(db *gorm.DB, taskID int64) ([]models.TaskLog, error) {
+	var ret []models.TaskLog
+	if err := db.Where("task_id = ?", taskID).Order("id asc").Find(&ret).Error; err != nil {
+		return ret, err
+	}
+	return ret, nil
+}
@@ -23,3 +23,16 @@ func (u *uidRepo) Updates(db *gorm.DB, bizId string, columns map[string]interfac
 	err := db.Model(&models.Uid{}).Where("business_id = ?", bizId).Updates(columns).Error
 	return err
 }
+
+// Create .
+func (u *uidRepo) Create(db *gorm.DB, m *models.Uid) error {
+	err := db.Create(m).Error
+	return err
+}
+
+// IncrMaxId 号段加一个步长，并发获取号段时由数据库保证原子性
+func (u *uidRepo) IncrMaxId(db *gorm.DB, bizId string) error {
+	err := db.Model(&models.Uid{}).Where("business_id = ?", bizId).
+		UpdateColumn("max_id", gorm.Expr("max_id + step")).Error
+	return err
+}
@@ -0,0 +1,31 @@
+package repo
+
+import (
+	"github.com/qinguoyi/osproxy/app/models"
+	"gorm.io/gorm"
+)
+
+type uploadSessionRepo struct{}
+
+func NewUploadSessionRepo() *uploadSessionRepo { return &uploadSessionRepo{} }
+
+// GetByUid .
+func (r *uploadSessionRepo) GetByUid(db *gorm.DB, uid int64) (*models.UploadSession, error) {
+	ret := &models.UploadSession{}
+	if err := db.Where("uid = ?", uid).First(ret).Error; err != nil {
+		return ret, err
+	}
+	return ret, nil
+}
+
+// Create .
+func (r *uploadSessionRepo) Create(db *gorm.DB, m *models.UploadSession) error {
+	err := db.Create(m).Error
+	return err
+}
+
+// Updates .
+func (r *uploadSessionRepo) Updates(db *gorm.DB, uid int64, columns map[string]interface{}) error {
+	err := db.Model(&models.UploadSession{}).Where("uid = ?", uid).Updates(columns).Error
+	return err
+}
@@ -0,0 +1,92 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+)
+
+type clamd struct {
+	address string
+	timeout time.Duration
+}
+
+// NewClamd .
+func NewClamd(address string, timeout time.Duration) Scanner {
+	return &clamd{address: address, timeout: timeout}
+}
+
+// Scan zINSTREAM：数据按块发送，每块前4字节为大端长度，长度为0的块表示结束
+func (s *clamd) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.address)
+	if err != nil {
+		return Result{}, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(deadline(ctx, s.timeout)); err != nil {
+		return Result{}, err
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return Result{}, err
+	}
+	buf := make([]byte, 4+utils.ScanChunkSize)
+	for {
+		n, readErr := r.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				// 超过StreamMaxLength时clamd会返回错误并关闭连接
+				if reply, replyErr := readReply(conn); replyErr == nil {
+					return Result{}, errors.New(fmt.Sprintf("clamd返回错误：%s", reply))
+				}
+				return Result{}, err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return Result{}, readErr
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return Result{}, err
+	}
+
+	reply, err := readReply(conn)
+	if err != nil {
+		return Result{}, err
+	}
+	return parseClamdReply(reply)
+}
+
+func readReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return "", err
+	}
+	return strings.TrimRight(reply, "\x00\n"), nil
+}
+
+// parseClamdReply stream: OK / stream: Eicar-Signature FOUND / ... ERROR
+func parseClamdReply(reply string) (Result, error) {
+	body := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	switch {
+	case body == "OK":
+		return Result{}, nil
+	case strings.HasSuffix(body, "FOUND"):
+		return Result{Infected: true, Signature: strings.TrimSpace(strings.TrimSuffix(body, "FOUND"))}, nil
+	default:
+		return Result{}, errors.New(fmt.Sprintf("clamd返回错误：%s", reply))
+	}
+}
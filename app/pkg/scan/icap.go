@@ -0,0 +1,114 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+)
+
+// icapResHeader 封装的HTTP响应头，响应体使用chunked编码发送
+const icapResHeader = "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n"
+
+type icap struct {
+	address string
+	service string
+	timeout time.Duration
+}
+
+// NewIcap .
+func NewIcap(address, service string, timeout time.Duration) Scanner {
+	return &icap{address: address, service: strings.TrimPrefix(service, "/"), timeout: timeout}
+}
+
+// Scan RESPMOD，204表示未修改即未发现恶意内容，200表示扫描服务替换了响应内容
+func (s *icap) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.address)
+	if err != nil {
+		return Result{}, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(deadline(ctx, s.timeout)); err != nil {
+		return Result{}, err
+	}
+
+	w := bufio.NewWriterSize(conn, utils.ScanChunkSize+32)
+	fmt.Fprintf(w, "RESPMOD icap://%s/%s ICAP/1.0\r\n", s.address, s.service)
+	fmt.Fprintf(w, "Host: %s\r\n", s.address)
+	fmt.Fprintf(w, "Allow: 204\r\n")
+	fmt.Fprintf(w, "Encapsulated: res-hdr=0, res-body=%d\r\n\r\n", len(icapResHeader))
+	w.WriteString(icapResHeader)
+
+	buf := make([]byte, utils.ScanChunkSize)
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			fmt.Fprintf(w, "%x\r\n", n)
+			w.Write(buf[:n])
+			w.WriteString("\r\n")
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return Result{}, readErr
+		}
+	}
+	w.WriteString("0\r\n\r\n")
+	if err := w.Flush(); err != nil {
+		return Result{}, err
+	}
+
+	tp := textproto.NewReader(bufio.NewReader(conn))
+	line, err := tp.ReadLine()
+	if err != nil {
+		return Result{}, err
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return Result{}, err
+	}
+	return parseIcapReply(line, header)
+}
+
+func parseIcapReply(line string, header textproto.MIMEHeader) (Result, error) {
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 || !strings.HasPrefix(parts[0], "ICAP/") {
+		return Result{}, errors.New(fmt.Sprintf("icap响应格式错误：%s", line))
+	}
+	code, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return Result{}, errors.New(fmt.Sprintf("icap响应格式错误：%s", line))
+	}
+	switch code {
+	case 204:
+		return Result{}, nil
+	case 200:
+		return Result{Infected: true, Signature: icapSignature(header)}, nil
+	default:
+		return Result{}, errors.New(fmt.Sprintf("icap返回错误：%s", line))
+	}
+}
+
+// icapSignature X-Virus-ID: EICAR，X-Infection-Found: Type=0; Resolution=2; Threat=EICAR;
+func icapSignature(header textproto.MIMEHeader) string {
+	if id := header.Get("X-Virus-ID"); id != "" {
+		return id
+	}
+	for _, field := range strings.Split(header.Get("X-Infection-Found"), ";") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) == 2 && kv[0] == "Threat" {
+			return kv[1]
+		}
+	}
+	return "unknown"
+}
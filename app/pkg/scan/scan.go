@@ -0,0 +1,66 @@
+package scan
+
+/*
+内容扫描：上传完成后以任务的方式将对象以流的方式发送到扫描服务
+clamd：ClamAV clamd INSTREAM协议
+icap：ICAP RESPMOD，适用于支持ICAP的杀毒网关
+*/
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/bootstrap"
+	"github.com/qinguoyi/osproxy/config"
+)
+
+// Result 扫描结果
+type Result struct {
+	Infected  bool
+	Signature string // 发现的恶意内容名称
+}
+
+// Scanner 内容扫描
+type Scanner interface {
+	// Scan 扫描数据流，扫描服务异常时返回error
+	Scan(ctx context.Context, r io.Reader) (Result, error)
+}
+
+var (
+	lgScanner Scanner
+)
+
+func InitScanner(conf *config.Configuration) {
+	timeout := time.Duration(conf.Scan.Timeout) * time.Second
+	if conf.Scan.Timeout <= 0 {
+		timeout = utils.ScanDefaultTimeout * time.Second
+	}
+	switch conf.Scan.Mode {
+	case "":
+		bootstrap.NewLogger().Logger.Info("未配置内容扫描")
+	case utils.ScanClamd:
+		lgScanner = NewClamd(conf.Scan.Address, timeout)
+		bootstrap.NewLogger().Logger.Info("当前使用的内容扫描：clamd")
+	case utils.ScanIcap:
+		lgScanner = NewIcap(conf.Scan.Address, conf.Scan.Service, timeout)
+		bootstrap.NewLogger().Logger.Info("当前使用的内容扫描：icap")
+	default:
+		panic("内容扫描只支持clamd、icap")
+	}
+}
+
+// NewScanner 未配置时返回nil
+func NewScanner() Scanner {
+	return lgScanner
+}
+
+// deadline 扫描超时时间和ctx截止时间中较早的一个
+func deadline(ctx context.Context, timeout time.Duration) time.Time {
+	d := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(d) {
+		return ctxDeadline
+	}
+	return d
+}
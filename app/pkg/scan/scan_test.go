@@ -0,0 +1,159 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeServer 每个连接读取完整的数据后交给handle返回结果
+func fakeServer(t *testing.T, handle func(conn net.Conn)) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// fakeClamd 按INSTREAM协议读取数据，包含eicar时返回FOUND
+func fakeClamd(t *testing.T) string {
+	return fakeServer(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		cmd, err := r.ReadString(0)
+		if err != nil || cmd != "zINSTREAM\x00" {
+			conn.Write([]byte("UNKNOWN COMMAND\x00"))
+			return
+		}
+		var data bytes.Buffer
+		for {
+			var size uint32
+			if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+				return
+			}
+			if size == 0 {
+				break
+			}
+			if _, err := io.CopyN(&data, r, int64(size)); err != nil {
+				return
+			}
+		}
+		if bytes.Contains(data.Bytes(), []byte(eicar)) {
+			conn.Write([]byte("stream: Eicar-Signature FOUND\x00"))
+			return
+		}
+		conn.Write([]byte("stream: OK\x00"))
+	})
+}
+
+// fakeIcap 解析RESPMOD请求及chunked响应体，包含eicar时返回200
+func fakeIcap(t *testing.T) string {
+	return fakeServer(t, func(conn net.Conn) {
+		br := bufio.NewReader(conn)
+		tp := textproto.NewReader(br)
+		line, _ := tp.ReadLine()
+		header, _ := tp.ReadMIMEHeader()
+		if !strings.HasPrefix(line, "RESPMOD icap://") || !strings.HasSuffix(line, "/avscan ICAP/1.0") ||
+			header.Get("Encapsulated") == "" {
+			conn.Write([]byte("ICAP/1.0 400 Bad Request\r\n\r\n"))
+			return
+		}
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			conn.Write([]byte("ICAP/1.0 400 Bad Request\r\n\r\n"))
+			return
+		}
+		data, _ := io.ReadAll(resp.Body)
+		if bytes.Contains(data, []byte(eicar)) {
+			conn.Write([]byte("ICAP/1.0 200 OK\r\nX-Infection-Found: Type=0; Resolution=2; Threat=EICAR;\r\n" +
+				"Encapsulated: null-body=0\r\n\r\n"))
+			return
+		}
+		conn.Write([]byte("ICAP/1.0 204 No Content\r\nEncapsulated: null-body=0\r\n\r\n"))
+	})
+}
+
+func testScanner(t *testing.T, s Scanner) {
+	// 超过一个块的正常数据
+	clean := bytes.Repeat([]byte("osproxy"), 20000)
+	res, err := s.Scan(context.Background(), bytes.NewReader(clean))
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if res.Infected {
+		t.Errorf("Expected clean, but got infected %s", res.Signature)
+	}
+
+	infected := append(clean, []byte(eicar)...)
+	res, err = s.Scan(context.Background(), bytes.NewReader(infected))
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if !res.Infected {
+		t.Errorf("Expected infected, but got clean")
+	}
+	if res.Signature == "" || res.Signature == "unknown" {
+		t.Errorf("Expected signature, but got %q", res.Signature)
+	}
+}
+
+func TestClamd(t *testing.T) {
+	testScanner(t, NewClamd(fakeClamd(t), time.Second))
+}
+
+func TestIcap(t *testing.T) {
+	testScanner(t, NewIcap(fakeIcap(t), "avscan", time.Second))
+}
+
+func TestScanError(t *testing.T) {
+	addr := fakeServer(t, func(conn net.Conn) {
+		io.Copy(io.Discard, io.LimitReader(conn, 10))
+		conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+	})
+	if _, err := NewClamd(addr, time.Second).Scan(context.Background(), strings.NewReader("data")); err == nil {
+		t.Errorf("Expected error, but got nil")
+	}
+	if _, err := NewIcap(fakeIcap(t), "other", time.Second).Scan(context.Background(),
+		strings.NewReader("data")); err == nil {
+		t.Errorf("Expected error, but got nil")
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	// 任务超时较长时以扫描超时为准
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	if d := deadline(ctx, time.Minute); d.After(time.Now().Add(time.Minute)) {
+		t.Errorf("Expected scan timeout to be used, but got %v", d)
+	}
+
+	// 任务即将超时时以ctx为准
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	expected, _ := ctx.Deadline()
+	if d := deadline(ctx, time.Minute); !d.Equal(expected) {
+		t.Errorf("Expected ctx deadline %v, but got %v", expected, d)
+	}
+}
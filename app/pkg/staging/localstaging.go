@@ -0,0 +1,64 @@
+package staging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/qinguoyi/osproxy/app/models"
+	"github.com/qinguoyi/osproxy/app/pkg/storage"
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+)
+
+// LocalStaging 分片暂存在本地目录，合并时直接读取本地分片
+type LocalStaging struct {
+	RootPath string
+}
+
+func NewLocalStaging() *LocalStaging {
+	return &LocalStaging{
+		RootPath: utils.LocalStore,
+	}
+}
+
+// Shared .
+func (s *LocalStaging) Shared() bool {
+	return false
+}
+
+// Create .
+func (s *LocalStaging) Create(uid int64) error {
+	return os.MkdirAll(path.Join(s.RootPath, fmt.Sprintf("%d", uid)), 0755)
+}
+
+// Exists 本地目录存在才能处理
+func (s *LocalStaging) Exists(uid int64) bool {
+	_, err := os.Stat(path.Join(s.RootPath, fmt.Sprintf("%d", uid)))
+	return !os.IsNotExist(err)
+}
+
+// Dir .
+func (s *LocalStaging) Dir(uid int64) (string, error) {
+	return path.Join(s.RootPath, fmt.Sprintf("%d", uid)), nil
+}
+
+// PartName .
+func (s *LocalStaging) PartName(uid int64, chunkNum int) string {
+	return fmt.Sprintf("%d_%d", uid, chunkNum)
+}
+
+// PutPart 上传到对象存储的同时保留本地文件，用于合并
+func (s *LocalStaging) PutPart(bucketName, partName, fileName string) error {
+	return storage.NewStorage().Storage.PutObject(bucketName, partName, fileName, "application/octet-stream")
+}
+
+// OpenPart .
+func (s *LocalStaging) OpenPart(part models.MultiPartInfo) (io.ReadCloser, error) {
+	return os.Open(path.Join(s.RootPath, fmt.Sprintf("%d", part.StorageUid), part.PartFileName))
+}
+
+// Remove .
+func (s *LocalStaging) Remove(uid int64) error {
+	return os.RemoveAll(path.Join(s.RootPath, fmt.Sprintf("%d", uid)))
+}
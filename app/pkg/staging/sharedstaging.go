@@ -0,0 +1,115 @@
+package staging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/qinguoyi/osproxy/app/models"
+	"github.com/qinguoyi/osproxy/app/pkg/repo"
+	"github.com/qinguoyi/osproxy/app/pkg/storage"
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/bootstrap/plugins"
+)
+
+// SharedStaging 分片暂存在对象存储的临时前缀下，本地只保留请求处理过程中的临时文件
+type SharedStaging struct {
+	RootPath string
+	Prefix   string
+}
+
+func NewSharedStaging(prefix string) *SharedStaging {
+	if prefix == "" {
+		prefix = "staging"
+	}
+	return &SharedStaging{
+		RootPath: utils.LocalStore,
+		Prefix:   prefix,
+	}
+}
+
+// Shared .
+func (s *SharedStaging) Shared() bool {
+	return true
+}
+
+// Create 不需要创建本地目录
+func (s *SharedStaging) Create(uid int64) error {
+	return nil
+}
+
+// Exists 任意服务都可以处理
+func (s *SharedStaging) Exists(uid int64) bool {
+	return true
+}
+
+// Dir 所有uid共用一个临时目录，文件名都以uid开头，不会冲突
+func (s *SharedStaging) Dir(uid int64) (string, error) {
+	dirName := path.Join(s.RootPath, s.Prefix)
+	if err := os.MkdirAll(dirName, 0755); err != nil {
+		return "", err
+	}
+	return dirName, nil
+}
+
+// PartName .
+func (s *SharedStaging) PartName(uid int64, chunkNum int) string {
+	return path.Join(s.Prefix, fmt.Sprintf("%d", uid), fmt.Sprintf("%d_%d", uid, chunkNum))
+}
+
+// PutPart 上传到对象存储后删除本地文件
+func (s *SharedStaging) PutPart(bucketName, partName, fileName string) error {
+	if err := storage.NewStorage().Storage.PutObject(bucketName, partName, fileName,
+		"application/octet-stream"); err != nil {
+		return err
+	}
+	_ = os.Remove(fileName)
+	return nil
+}
+
+// OpenPart 从对象存储按块读取分片
+func (s *SharedStaging) OpenPart(part models.MultiPartInfo) (io.ReadCloser, error) {
+	return storage.NewObjectReader(part.Bucket, part.StorageName, part.StorageSize), nil
+}
+
+// Remove 删除临时目录中uid的文件，包括分片uid_n及合并文件uid.ext，以及对象存储中uid的分片
+func (s *SharedStaging) Remove(uid int64) error {
+	if err := s.removeLocal(uid); err != nil {
+		return err
+	}
+	// 重新上传的分片和旧分片同名，按名称去重
+	lgDB := new(plugins.LangGoDB).Use("default").NewDB()
+	parts, err := repo.NewMultiPartInfoRepo().GetPartNumByUid(lgDB, uid)
+	if err != nil {
+		return err
+	}
+	removed := map[string]bool{}
+	sto := storage.NewStorage().Storage
+	for _, part := range parts {
+		if removed[part.StorageName] {
+			continue
+		}
+		if err := sto.DeleteObject(part.Bucket, part.StorageName); err != nil {
+			return err
+		}
+		removed[part.StorageName] = true
+	}
+	return nil
+}
+
+func (s *SharedStaging) removeLocal(uid int64) error {
+	for _, pattern := range []string{"%d_*", "%d.*"} {
+		files, err := filepath.Glob(path.Join(s.RootPath, s.Prefix, fmt.Sprintf(pattern, uid)))
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package staging
+
+import (
+	"io"
+
+	"github.com/qinguoyi/osproxy/app/models"
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/bootstrap"
+	"github.com/qinguoyi/osproxy/config"
+)
+
+/*
+分片暂存区，分片在合并前暂存的位置
+local：暂存在生成链接的服务本地目录，后续请求需要转发到该服务
+shared：暂存在对象存储的临时前缀下，任意服务都可以接收分片及合并
+*/
+
+// Staging 分片暂存区
+type Staging interface {
+	// Shared 暂存区是否集群共享，共享时不需要转发到上传目录所在服务
+	Shared() bool
+
+	// Create 生成上传链接时创建暂存区
+	Create(int64) error
+
+	// Exists 当前服务能否处理uid的上传
+	Exists(int64) bool
+
+	// Dir 当前服务处理uid上传时的本地工作目录，不存在时创建
+	Dir(int64) (string, error)
+
+	// PartName 分片在对象存储中的名称
+	PartName(int64, int) string
+
+	// PutPart 将写入本地文件的分片保存到暂存区，参数依次为桶、分片名称、本地文件
+	PutPart(string, string, string) error
+
+	// OpenPart 读取暂存区中的分片
+	OpenPart(models.MultiPartInfo) (io.ReadCloser, error)
+
+	// Remove 清理uid在当前服务的本地工作目录，共享暂存时同时删除对象存储中的分片
+	Remove(int64) error
+}
+
+var (
+	lgStaging Staging
+)
+
+func InitStaging(conf *config.Configuration) {
+	if conf.Staging == nil || conf.Staging.Mode == "" || conf.Staging.Mode == utils.StagingLocal {
+		lgStaging = NewLocalStaging()
+		bootstrap.NewLogger().Logger.Info("当前使用的分片暂存：Local")
+		return
+	}
+	if conf.Staging.Mode != utils.StagingShared {
+		panic("分片暂存方式只支持local、shared")
+	}
+	if conf.Local.Enabled {
+		panic("本地存储不支持共享分片暂存")
+	}
+	lgStaging = NewSharedStaging(conf.Staging.Prefix)
+	bootstrap.NewLogger().Logger.Info("当前使用的分片暂存：Shared")
+}
+
+func NewStaging() Staging {
+	if lgStaging != nil {
+		return lgStaging
+	}
+	return NewLocalStaging()
+}
@@ -9,6 +9,8 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -113,3 +115,98 @@ func (s *CosStorage) DeleteObject(bucketName, objectName string) error {
 	_, err := client.Object.Delete(context.Background(), objectName, nil)
 	return err
 }
+
+// newClient 创建存储桶对应的客户端
+func (s *CosStorage) newClient(bucketName string) *cos.Client {
+	u, _ := url.Parse(fmt.Sprintf("https://%s-%s.cos.%s.myqcloud.com", bucketName, s.Appid, s.Region))
+	b := &cos.BaseURL{BucketURL: u}
+	return cos.NewClient(b, &http.Client{
+		Transport: &cos.AuthorizationTransport{
+			SecretID:  s.SecretId,
+			SecretKey: s.SecretKey,
+		},
+	})
+}
+
+// StatObject .
+func (s *CosStorage) StatObject(bucketName, objectName string) (*ObjectStat, error) {
+	resp, err := s.newClient(bucketName).Object.Head(context.Background(), objectName, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectStat{
+		Size:        resp.ContentLength,
+		ETag:        strings.Trim(resp.Header.Get("ETag"), "\""),
+		ContentType: resp.Header.Get("Content-Type"),
+	}, nil
+}
+
+// PresignPutObject .
+func (s *CosStorage) PresignPutObject(bucketName, objectName string, expire time.Duration) (string, error) {
+	u, err := s.newClient(bucketName).Object.GetPresignedURL(context.Background(), http.MethodPut, objectName,
+		s.SecretId, s.SecretKey, expire, nil)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// NewMultipartUpload .
+func (s *CosStorage) NewMultipartUpload(bucketName, objectName, contentType string) (string, error) {
+	result, _, err := s.newClient(bucketName).Object.InitiateMultipartUpload(context.Background(), objectName,
+		&cos.InitiateMultipartUploadOptions{
+			ObjectPutHeaderOptions: &cos.ObjectPutHeaderOptions{ContentType: contentType},
+		})
+	if err != nil {
+		return "", err
+	}
+	return result.UploadID, nil
+}
+
+// PresignUploadPart .
+func (s *CosStorage) PresignUploadPart(bucketName, objectName, uploadId string, partNumber int,
+	expire time.Duration) (string, error) {
+	query := url.Values{}
+	query.Set("uploadId", uploadId)
+	query.Set("partNumber", strconv.Itoa(partNumber))
+	u, err := s.newClient(bucketName).Object.GetPresignedURL(context.Background(), http.MethodPut, objectName,
+		s.SecretId, s.SecretKey, expire, &cos.PresignedURLOptions{Query: &query})
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// CompleteMultipartUpload .
+func (s *CosStorage) CompleteMultipartUpload(bucketName, objectName, uploadId string) error {
+	ctx := context.Background()
+	client := s.newClient(bucketName)
+	var parts []cos.Object
+	marker := ""
+	for {
+		result, _, err := client.Object.ListParts(ctx, objectName, uploadId, &cos.ObjectListPartsOptions{
+			MaxParts:         "1000",
+			PartNumberMarker: marker,
+		})
+		if err != nil {
+			return err
+		}
+		for _, part := range result.Parts {
+			parts = append(parts, cos.Object{PartNumber: part.PartNumber, ETag: part.ETag})
+		}
+		if !result.IsTruncated {
+			break
+		}
+		marker = result.NextPartNumberMarker
+	}
+	_, _, err := client.Object.CompleteMultipartUpload(ctx, objectName, uploadId,
+		&cos.CompleteMultipartUploadOptions{Parts: parts})
+	return err
+}
+
+// CopyObject .
+func (s *CosStorage) CopyObject(bucketName, srcObjectName, dstObjectName string) error {
+	source := fmt.Sprintf("%s-%s.cos.%s.myqcloud.com/%s", bucketName, s.Appid, s.Region, srcObjectName)
+	_, _, err := s.newClient(bucketName).Object.MultiCopy(context.Background(), dstObjectName, source, nil)
+	return err
+}
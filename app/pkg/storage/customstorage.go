@@ -2,6 +2,7 @@ package storage
 
 import (
 	"sync"
+	"time"
 
 	"github.com/qinguoyi/osproxy/bootstrap"
 	"github.com/qinguoyi/osproxy/config"
@@ -22,6 +23,34 @@ type CustomStorage interface {
 	DeleteObject(string, string) error
 }
 
+// PresignStorage 支持预签名直传的存储，客户端直接上传到对象存储，本地存储不支持
+type PresignStorage interface {
+	// PresignPutObject 生成单个对象的预签名上传链接
+	PresignPutObject(string, string, time.Duration) (string, error)
+
+	// NewMultipartUpload 初始化分片上传，返回uploadId
+	NewMultipartUpload(string, string, string) (string, error)
+
+	// PresignUploadPart 生成分片的预签名上传链接，分片序号从1开始
+	PresignUploadPart(string, string, string, int, time.Duration) (string, error)
+
+	// CompleteMultipartUpload 查询已上传的分片并完成分片上传
+	CompleteMultipartUpload(string, string, string) error
+
+	// StatObject 查询对象信息
+	StatObject(string, string) (*ObjectStat, error)
+
+	// CopyObject 同一存储桶内服务端复制对象，大对象按分片复制
+	CopyObject(string, string, string) error
+}
+
+// ObjectStat 对象信息
+type ObjectStat struct {
+	Size        int64
+	ETag        string
+	ContentType string
+}
+
 type LangGoStorage struct {
 	Mux     *sync.RWMutex
 	Storage CustomStorage
@@ -61,6 +90,12 @@ func InitStorage(conf *config.Configuration) {
 	}
 }
 
+// Presign 当前存储是否支持预签名直传
+func (lg *LangGoStorage) Presign() (PresignStorage, bool) {
+	p, ok := lg.Storage.(PresignStorage)
+	return p, ok
+}
+
 func NewStorage() *LangGoStorage {
 	if lgStorage != nil {
 		return lgStorage
@@ -3,6 +3,9 @@ package storage
 import (
 	"context" // context包提供了对处理单个请求的跟踪，取消和超时的操作
 	"io"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/qinguoyi/osproxy/app/pkg/utils"
@@ -69,13 +72,78 @@ func (s *MinIOStorage) PutObject(bucketName, objectName, filePath, contentType s
 }
 
 // StatObject .
-func (s *MinIOStorage) StatObject(bucketName, objectName string) (int64, error) {
+func (s *MinIOStorage) StatObject(bucketName, objectName string) (*ObjectStat, error) {
 	ctx := context.Background()
 	objectInfo, err := s.client.StatObject(ctx, bucketName, objectName, minio.StatObjectOptions{})
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	return &ObjectStat{
+		Size:        objectInfo.Size,
+		ETag:        objectInfo.ETag,
+		ContentType: objectInfo.ContentType,
+	}, nil
+}
+
+// PresignPutObject .
+func (s *MinIOStorage) PresignPutObject(bucketName, objectName string, expire time.Duration) (string, error) {
+	u, err := s.client.PresignedPutObject(context.Background(), bucketName, objectName, expire)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// NewMultipartUpload .
+func (s *MinIOStorage) NewMultipartUpload(bucketName, objectName, contentType string) (string, error) {
+	core := minio.Core{Client: s.client}
+	return core.NewMultipartUpload(context.Background(), bucketName, objectName,
+		minio.PutObjectOptions{ContentType: contentType})
+}
+
+// PresignUploadPart .
+func (s *MinIOStorage) PresignUploadPart(bucketName, objectName, uploadId string, partNumber int,
+	expire time.Duration) (string, error) {
+	params := url.Values{}
+	params.Set("uploadId", uploadId)
+	params.Set("partNumber", strconv.Itoa(partNumber))
+	u, err := s.client.Presign(context.Background(), "PUT", bucketName, objectName, expire, params)
+	if err != nil {
+		return "", err
 	}
-	return objectInfo.Size, nil
+	return u.String(), nil
+}
+
+// CompleteMultipartUpload .
+func (s *MinIOStorage) CompleteMultipartUpload(bucketName, objectName, uploadId string) error {
+	ctx := context.Background()
+	core := minio.Core{Client: s.client}
+	var parts []minio.CompletePart
+	marker := 0
+	for {
+		result, err := core.ListObjectParts(ctx, bucketName, objectName, uploadId, marker, 1000)
+		if err != nil {
+			return err
+		}
+		for _, part := range result.ObjectParts {
+			parts = append(parts, minio.CompletePart{PartNumber: part.PartNumber, ETag: part.ETag})
+		}
+		if !result.IsTruncated {
+			break
+		}
+		marker = result.NextPartNumberMarker
+	}
+	_, err := core.CompleteMultipartUpload(ctx, bucketName, objectName, uploadId, parts, minio.PutObjectOptions{})
+	return err
+}
+
+// CopyObject .
+func (s *MinIOStorage) CopyObject(bucketName, srcObjectName, dstObjectName string) error {
+	// Start为-1表示复制整个对象，不超过5G时单次复制，保留对象的md5 ETag
+	_, err := s.client.ComposeObject(context.Background(),
+		minio.CopyDestOptions{Bucket: bucketName, Object: dstObjectName},
+		minio.CopySrcOptions{Bucket: bucketName, Object: srcObjectName, Start: -1})
+	return err
 }
 
 func (s *MinIOStorage) DeleteObject(bucketName, objectName string) error {
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"io"
+)
+
+// objectReader 按块读取对象，避免整个对象读入内存
+type objectReader struct {
+	bucket string
+	object string
+	offset int64
+	size   int64
+	buf    []byte
+}
+
+// NewObjectReader 按块顺序读取对象，size为对象大小
+func NewObjectReader(bucket, object string, size int64) io.ReadCloser {
+	return &objectReader{
+		bucket: bucket,
+		object: object,
+		size:   size,
+	}
+}
+
+func (r *objectReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		if r.offset >= r.size {
+			return 0, io.EOF
+		}
+		length := int64(4 * 1024 * 1024)
+		if r.offset+length > r.size {
+			length = r.size - r.offset
+		}
+		data, err := NewStorage().Storage.GetObject(r.bucket, r.object, r.offset, length)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if len(data) == 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		r.buf = data
+		r.offset += int64(len(data))
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *objectReader) Close() error {
+	return nil
+}
@@ -3,6 +3,9 @@ package storage
 import (
 	"fmt"
 	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 	"github.com/qinguoyi/osproxy/app/pkg/utils"
@@ -81,3 +84,100 @@ func (s *OssStorage) DeleteObject(bucketName, objectName string) error {
 	err = bucket.DeleteObject(objectName)
 	return err
 }
+
+// StatObject .
+func (s *OssStorage) StatObject(bucketName, objectName string) (*ObjectStat, error) {
+	bucket, err := s.client.Bucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	header, err := bucket.GetObjectDetailedMeta(objectName)
+	if err != nil {
+		return nil, err
+	}
+	size, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectStat{
+		Size:        size,
+		ETag:        strings.Trim(header.Get("ETag"), "\""),
+		ContentType: header.Get("Content-Type"),
+	}, nil
+}
+
+// PresignPutObject .
+func (s *OssStorage) PresignPutObject(bucketName, objectName string, expire time.Duration) (string, error) {
+	bucket, err := s.client.Bucket(bucketName)
+	if err != nil {
+		return "", err
+	}
+	return bucket.SignURL(objectName, oss.HTTPPut, int64(expire.Seconds()))
+}
+
+// NewMultipartUpload .
+func (s *OssStorage) NewMultipartUpload(bucketName, objectName, contentType string) (string, error) {
+	bucket, err := s.client.Bucket(bucketName)
+	if err != nil {
+		return "", err
+	}
+	imur, err := bucket.InitiateMultipartUpload(objectName, oss.ContentType(contentType))
+	if err != nil {
+		return "", err
+	}
+	return imur.UploadID, nil
+}
+
+// PresignUploadPart .
+func (s *OssStorage) PresignUploadPart(bucketName, objectName, uploadId string, partNumber int,
+	expire time.Duration) (string, error) {
+	bucket, err := s.client.Bucket(bucketName)
+	if err != nil {
+		return "", err
+	}
+	return bucket.SignURL(objectName, oss.HTTPPut, int64(expire.Seconds()),
+		oss.AddParam("uploadId", uploadId), oss.AddParam("partNumber", strconv.Itoa(partNumber)))
+}
+
+// CompleteMultipartUpload .
+func (s *OssStorage) CompleteMultipartUpload(bucketName, objectName, uploadId string) error {
+	bucket, err := s.client.Bucket(bucketName)
+	if err != nil {
+		return err
+	}
+	imur := oss.InitiateMultipartUploadResult{Bucket: bucketName, Key: objectName, UploadID: uploadId}
+	var parts []oss.UploadPart
+	marker := 0
+	for {
+		result, err := bucket.ListUploadedParts(imur, oss.MaxParts(1000), oss.PartNumberMarker(marker))
+		if err != nil {
+			return err
+		}
+		for _, part := range result.UploadedParts {
+			parts = append(parts, oss.UploadPart{PartNumber: part.PartNumber, ETag: part.ETag})
+		}
+		if !result.IsTruncated {
+			break
+		}
+		marker, _ = strconv.Atoi(result.NextPartNumberMarker)
+	}
+	_, err = bucket.CompleteMultipartUpload(imur, parts)
+	return err
+}
+
+// CopyObject .
+func (s *OssStorage) CopyObject(bucketName, srcObjectName, dstObjectName string) error {
+	bucket, err := s.client.Bucket(bucketName)
+	if err != nil {
+		return err
+	}
+	stat, err := s.StatObject(bucketName, srcObjectName)
+	if err != nil {
+		return err
+	}
+	if stat.Size <= utils.OssCopyObjectMax {
+		_, err = bucket.CopyObject(srcObjectName, dstObjectName)
+		return err
+	}
+	return bucket.CopyFile(bucketName, srcObjectName, dstObjectName, utils.OssCopyPartSize)
+}
@@ -1,21 +1,24 @@
 package thirdparty
 
 import (
-	"bytes"
 	"fmt"
 	"io"
-	"mime/multipart"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/qinguoyi/osproxy/app/pkg/base"
+	"github.com/qinguoyi/osproxy/bootstrap"
+	"go.uber.org/zap"
 )
 
 type storageService struct{}
 
 // 这个go文件的作用是：1.将请求转发到storage服务；2.将storage服务的响应返回给客户端
-// 包含的函数有：1.Locate()函数，用于获取存储服务的地址；2.UploadForward()函数，用于将上传请求转发到存储服务；3.MergeForward()函数，用于将合并请求转发到存储服务；4.DownloadForward()函数，用于将下载请求转发到存储服务
+// 包含的函数有：1.Locate()函数，用于获取存储服务的地址；2.RawForward()函数，用于将上传、合并、下载等请求原样转发到存储服务；
+// 3.PushStaging()函数，用于排空时将本地暂存的分片推送到其他服务
 
 // NewStorageService .
 func NewStorageService() *storageService { return &storageService{} }
@@ -28,6 +31,7 @@ func (s *storageService) Locate(scheme, ip, port, uid string) (string, error) {
 		Body:   io.NopCloser(strings.NewReader("")),
 		Method: "GET",
 		Params: map[string]string{"uid": uid},
+		Peer:   true,
 	}
 	_, data, _, err := base.Ask(req)
 	if err != nil {
@@ -37,115 +41,42 @@ func (s *storageService) Locate(scheme, ip, port, uid string) (string, error) {
 	return strings.Trim(string(data.Data), "\""), nil // Trim()函数用于去掉字符串两端的指定字符
 }
 
-// UploadForward .
-func (s *storageService) UploadForward(c *gin.Context, scheme, ip, port, uid string, single bool) (int, *base.Response, http.Header, error) {
-	var urlStr string
-	if single {
-		urlStr = fmt.Sprintf("/api/storage/v0/upload/%s", uid)
-	} else {
-		urlStr = fmt.Sprintf("/api/storage/v0/upload/%s/multi", uid)
-	}
-	// 获取查询参数
-	queryParam := map[string]string{}
-	query := c.Request.URL.Query()
-	for k, v := range query {
-		queryParam[k] = v[0]
-	}
-
-	form, err := c.MultipartForm() // MultipartForm()函数用于获取表单数据
-	if err != nil {
-		return 500, nil, nil, err
-	}
-	// 创建表单数据
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	for key, value := range form.Value {
-		for _, v := range value {
-			_ = writer.WriteField(key, v)
-		}
-	}
-
-	// 处理文件数据
-	files := form.File["file"]
-	for _, file := range files {
-		// 打开文件
-		src, err := file.Open()
-		if err != nil {
-			return 500, nil, nil, err
-		}
-		defer func(src multipart.File) {
-			err := src.Close()
-			if err != nil {
-
-			}
-		}(src)
-
-		// 创建表单数据项
-		dst, err := writer.CreateFormFile("file", file.Filename)
-		if err != nil {
-			return 500, nil, nil, err
-		}
-
-		// 将文件内容写入表单数据项
-		if _, err = io.Copy(dst, src); err != nil {
-			return 500, nil, nil, err
-		}
-	}
-	err = writer.Close()
-	if err != nil {
-		return 500, nil, nil, err
-	}
+// PushStaging 推送暂存目录中的文件，name为空时只创建目录
+func (s *storageService) PushStaging(scheme, ip, port, uid, name string, body io.Reader) error {
+	urlStr := "/api/storage/v0/proxy/staging"
 	req := base.Request{
-		Url:  fmt.Sprintf("%s://%s:%s%s", scheme, ip, port, urlStr),
-		Body: io.NopCloser(body),
-		HeaderSet: map[string]string{
-			"Content-Type": writer.FormDataContentType(),
-		},
+		Url:    fmt.Sprintf("%s://%s:%s%s", scheme, ip, port, urlStr),
+		Body:   io.NopCloser(body),
 		Method: "PUT",
-		Params: queryParam,
+		Params: map[string]string{"uid": uid, "name": name},
+		Peer:   true,
 	}
-	return base.Ask(req)
-}
-
-// MergeForward .
-func (s *storageService) MergeForward(c *gin.Context, scheme, ip, port, uid string) (int, *base.Response, http.Header, error) {
-	urlStr := fmt.Sprintf("/api/storage/v0/upload/%s/merge", uid)
-	// 获取查询参数
-	queryParam := map[string]string{}
-	query := c.Request.URL.Query()
-	for k, v := range query {
-		queryParam[k] = v[0]
+	code, _, _, err := base.Ask(req)
+	if err != nil {
+		return err
 	}
-
-	req := base.Request{
-		Url:       fmt.Sprintf("%s://%s:%s%s", scheme, ip, port, urlStr),
-		Body:      nil,
-		HeaderSet: map[string]string{},
-		Method:    "PUT",
-		Params:    queryParam,
+	if code != http.StatusOK {
+		return fmt.Errorf("推送暂存分片失败，状态码：%d", code)
 	}
-	return base.Ask(req)
+	return nil
 }
 
-// DownloadForward .
-func (s *storageService) DownloadForward(c *gin.Context, scheme, ip, port string) (int, io.ReadCloser, http.Header, error) {
-	// 获取查询参数
-	queryParam := map[string]string{}
-	query := c.Request.URL.Query()
-	for k, v := range query {
-		queryParam[k] = v[0]
+// RawForward 原样转发请求，请求体和响应以流的方式透传，Range、状态码、响应头及request-id保持不变
+func (s *storageService) RawForward(c *gin.Context, scheme, ip, port string) {
+	target := &url.URL{Scheme: scheme, Host: fmt.Sprintf("%s:%s", ip, port)}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = base.Client.Transport
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		base.SignPeerRequest(r)
 	}
-
-	newUrl := c.Request.URL.RequestURI()
-	proxyUrl := fmt.Sprintf("%s://%s:%s%s", scheme, ip, port, newUrl)
-
-	req := base.Request{
-		Url:       proxyUrl,
-		Body:      io.NopCloser(strings.NewReader("")),
-		HeaderSet: map[string]string{},
-		Method:    "GET",
-		Params:    queryParam,
+	// 下载数据边收边发，不在内存中缓冲
+	proxy.FlushInterval = -1
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		bootstrap.NewLogger().Logger.Error(fmt.Sprintf("转发到%s失败，详情：%s", target.Host, err.Error()),
+			zap.String("traceId", r.Header.Get("request-id")))
+		w.WriteHeader(http.StatusBadGateway)
 	}
-
-	return base.AskFile(req)
+	proxy.ServeHTTP(c.Writer, c.Request)
 }
@@ -14,10 +14,132 @@ const (
 	MultiPartDownload     = 10
 )
 
+// 集群内请求
+const (
+	PeerTimestampHeader = "X-Osproxy-Timestamp"
+	PeerSignatureHeader = "X-Osproxy-Signature"
+	PeerNonceHeader     = "X-Osproxy-Nonce" // 随机数，签名有效期内不能重复
+	PeerSignatureExpire = 5 * 60            // 签名有效期，秒
+
+	PeerBodySignatureHeader = "X-Osproxy-Body-Signature" // 请求体签名，以trailer的方式在请求体之后发送
+	PeerContentLengthHeader = "X-Osproxy-Content-Length" // 请求体以chunked发送时原始的Content-Length
+	PeerStreamingBody       = "STREAMING-BODY"           // 请求头签名中请求体摘要的占位，实际摘要在trailer中签名
+	PeerContextKey          = "peer"                     // 校验通过的集群内请求在上下文中的标记
+)
+
+// 服务发现方式
+const (
+	DiscoveryRedis  = "redis"
+	DiscoveryStatic = "static"
+	DiscoveryDns    = "dns"
+)
+
+// 发号方式
+const (
+	IdSnowflake = "snowflake"
+	IdSegment   = "segment"
+)
+
+// 雪花算法
+const (
+	WorkerLease       = 30  // worker id租约，秒，持有期间自动续期
+	ClockMaxBackwards = 500 // 可容忍的时钟回拨，毫秒，回拨范围内等待时钟追上
+)
+
+// 分布式锁
+const LockAcquireTimeout = 30 * time.Second // 阻塞加锁的最长等待时间
+
+// 选主
+const (
+	LeaderRedisKey      = "leader:proxy"       // 主节点锁
+	LeaderTokenRedisKey = "leader:proxy:token" // fencing token，每次当选递增
+	LeaderLease         = 15                   // 主节点租约，秒
+	LeaderRenewInterval = 5 * time.Second      // 续期及竞选间隔
+)
+
+// 排空
+const (
+	DrainDefaultTimeout = 10 * 60 // 等待进行中的上传及合并完成的默认时间，秒
+	DrainCheckInterval  = time.Second
+)
+
+// 分片暂存方式
+const (
+	StagingLocal  = "local"
+	StagingShared = "shared"
+)
+
 // 任务类型
 const (
 	TaskPartMerge  = "partMerge"
 	TaskPartDelete = "partDelete"
+	TaskWebhook    = "webhook"
+	TaskScan       = "scan"
+)
+
+// 对象事件，推送到webhook及消息队列
+const (
+	EventObjectUploaded = "object.uploaded" // 上传完成，分片上传在合并完成后推送
+	EventMergeFailed    = "merge.failed"    // 合并达到重试上限仍失败
+	EventObjectDeleted  = "object.deleted"  // 对象被删除，目前为上传终止后删除已上传的数据
+)
+
+// 内容扫描
+const (
+	ScanClamd = "clamd"
+	ScanIcap  = "icap"
+
+	ScanStatusPending     = "pending"     // 等待扫描，不能下载
+	ScanStatusClean       = "clean"       // 扫描通过
+	ScanStatusQuarantined = "quarantined" // 发现恶意内容，拒绝下载
+	ScanStatusFailed      = "failed"      // 达到重试上限仍扫描失败，不能下载，可由管理接口重新执行
+
+	ScanDefaultTimeout = 300       // 单个对象的默认扫描超时时间，秒
+	ScanChunkSize      = 64 * 1024 // 发送到扫描服务的块大小
+)
+
+// 发件箱
+const (
+	OutboxRedis         = "redis"
+	OutboxDefaultStream = "osproxy:events"
+	OutboxDefaultMaxLen = 100000
+	OutboxBatch         = 100                // 每次发布的事件数量
+	OutboxRelayInterval = time.Second        // 主节点发布间隔
+	OutboxDedupTTL      = 7 * 24 * 60 * 60   // 已发布事件ID的去重标记保留时间，秒
+	OutboxRetention     = 7 * 24 * time.Hour // 已发布事件在发件箱中的保留时间
+	OutboxCleanInterval = time.Hour
+)
+
+// webhook
+const (
+	WebhookHeaderEvent     = "X-Osproxy-Event"
+	WebhookHeaderDelivery  = "X-Osproxy-Delivery" // 事件ID，重试时不变，接收方据此去重
+	WebhookHeaderTimestamp = "X-Osproxy-Timestamp"
+	WebhookHeaderSignature = "X-Osproxy-Signature" // sha256=hex(hmac(secret, timestamp.body))
+	WebhookDefaultTimeout  = 10                    // 单次推送默认超时时间，秒
+)
+
+// 分片合并状态
+const (
+	MergeStatusPending  = "pending"
+	MergeStatusRunning  = "running"
+	MergeStatusFinished = "finished"
+	MergeStatusFailed   = "failed"
+	MergeStatusCanceled = "canceled"
+)
+
+// 上传状态
+const (
+	UploadStatusPending   = "pending"   // 还没有上传数据
+	UploadStatusUploading = "uploading" // 分片上传中
+	UploadStatusMerging   = "merging"   // 已提交合并，等待合并完成
+	UploadStatusScanning  = "scanning"  // 等待内容扫描
+	UploadStatusReady     = "ready"     // 可以下载
+	UploadStatusFailed    = "failed"    // 上传终止、合并失败或未通过扫描
+
+	MergeProgressExpire       = time.Hour        // 合并进度的缓存时间，每次更新时续期
+	UploadStatusPushInterval  = time.Second      // 推送上传状态的检查间隔
+	UploadStatusStreamTimeout = 10 * time.Minute // 单个推送连接的最长时间，之后由客户端重连
 )
 
 // 任务状态
@@ -25,13 +147,83 @@ const (
 	TaskStatusUndo    = 0
 	TaskStatusRunning = 1
 	TaskStatusFinish  = 2
+	TaskStatusCancel  = 3
 	TaskStatusError   = 99
 )
 
+// 任务通知方式
+const (
+	TaskNotifyPoll     = "poll"
+	TaskNotifyRedis    = "redis"
+	TaskNotifyPostgres = "postgres"
+)
+
+// 任务通知
+const (
+	TaskNotifyChannel        = "osproxy_task" // redis频道及postgres通知频道
+	TaskPollInterval         = 500 * time.Millisecond
+	TaskFallbackPollInterval = 5 * time.Second // 启用通知时兜底轮询的间隔
+	TaskNotifyRetryInterval  = 3 * time.Second
+)
+
 // worker和队列
 const (
-	MaxWorker = 100
-	MaxQueue  = 200
+	TaskPoolWorkers = 10  // 注册时未设置时每个任务类型的worker数
+	MaxQueue        = 200 // 没有handler的任务的队列长度
 )
 
 const CompensationTotal = 5 // 补偿次数总量
+
+// 任务重试
+const (
+	TaskRetryBaseDelay = 10 * time.Second // 首次重试的等待时间
+	TaskRetryMaxDelay  = 30 * time.Minute // 最长等待时间
+)
+
+// 任务租约
+const (
+	TaskLease              = 30               // 执行中任务的租约，秒，过期后由任意服务回收
+	TaskLeaseRenewInterval = 10 * time.Second // 续期间隔
+)
+
+// 上传会话状态
+const (
+	SessionStatusUploading = 0
+	SessionStatusComplete  = 1
+	SessionStatusAbort     = 2
+)
+
+// 上传会话
+const (
+	SessionDefaultExpire = 24 * 3600 // 默认有效期，单位秒
+	SessionMaxChunk      = 10000     // 最大分片数量
+)
+
+// 预签名直传
+const (
+	DirectMaxExpire     = 7 * 24 * 3600     // 预签名链接最长有效期，单位秒
+	DirectMaxPart       = 10000             // 分片直传最大分片数量
+	DirectStagingPrefix = "direct-staging/" // 预签名链接指向的暂存对象前缀，完成后复制到客户端无法写入的对象
+	OssCopyObjectMax    = 1 << 30           // oss单次复制的对象大小上限，超过时按分片复制
+	OssCopyPartSize     = 100 << 20         // oss分片复制的分片大小
+)
+
+// tus协议
+const (
+	TusResumable         = "1.0.0"
+	TusExtension         = "creation,termination,checksum"
+	TusChecksumAlgorithm = "md5,sha1,sha256"
+)
+
+// 下载链接
+const DownloadResumeWindow = 10 * time.Minute // 限制次数的链接扣减后，同一客户端在窗口内的断点续传不再扣减
+
+// 分享
+const (
+	ShareCodeLength = 8   // 分享码长度
+	ShareLinkExpire = 600 // 分享解析出的下载链接有效期，单位秒
+
+	SharePasswordFailWindow  = 15 * time.Minute // 密码错误次数的统计窗口
+	SharePasswordFailPerIP   = 5                // 同一分享码、同一IP在窗口内允许的错误次数
+	SharePasswordFailPerCode = 50               // 同一分享码在窗口内允许的错误次数，限制分散IP猜测
+)
@@ -22,7 +22,7 @@ type Response struct {
 // StreamSuccess .
 func StreamSuccess(c *gin.Context, step func(w io.Writer) bool) {
 	flag := c.Stream(step)
-	fmt.Println(fmt.Sprintf("+++---%s---+++", flag))
+	fmt.Println(fmt.Sprintf("+++---%v---+++", flag))
 	if flag {
 		c.Status(200)
 	} else {
@@ -74,3 +74,30 @@ func NotFoundResource(c *gin.Context, msg string) {
 		"",
 	})
 }
+
+// ServiceUnavailable 服务不可用
+func ServiceUnavailable(c *gin.Context, msg string) {
+	c.JSON(http.StatusServiceUnavailable, Response{
+		0,
+		msg,
+		"",
+	})
+}
+
+// Forbidden 禁止访问
+func Forbidden(c *gin.Context, msg string) {
+	c.JSON(http.StatusForbidden, Response{
+		0,
+		msg,
+		"",
+	})
+}
+
+// TooManyRequests 请求过于频繁
+func TooManyRequests(c *gin.Context, msg string) {
+	c.JSON(http.StatusTooManyRequests, Response{
+		0,
+		msg,
+		"",
+	})
+}
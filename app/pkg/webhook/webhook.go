@@ -0,0 +1,162 @@
+package webhook
+
+/*
+webhook：上传完成、合并最终失败、对象删除时向配置的地址推送签名的json事件
+每个地址创建一个推送任务，由任务调度执行，失败后按重试策略退避重试
+*/
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/qinguoyi/osproxy/app/models"
+	"github.com/qinguoyi/osproxy/app/pkg/base"
+	"github.com/qinguoyi/osproxy/app/pkg/repo"
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+	"github.com/qinguoyi/osproxy/bootstrap"
+	"gorm.io/gorm"
+)
+
+var errNoSecret = errors.New("未配置webhook签名密钥，不推送事件")
+
+// Publish 为每个配置的地址创建推送任务，未配置地址时不推送，未配置签名密钥时不创建
+// 同一对象的同类事件再次推送时沿用之前的事件ID，接收方可按X-Osproxy-Delivery去重
+func Publish(db *gorm.DB, eventType string, uid int64, data interface{}) error {
+	conf := bootstrap.NewConfig("").Webhook
+	if len(conf.Urls) == 0 {
+		return nil
+	}
+	if conf.Secret == "" {
+		return errNoSecret
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	id, err := eventID(db, eventType, uid)
+	if err != nil {
+		return err
+	}
+	event := models.ObjectEvent{
+		ID:   id,
+		Type: eventType,
+		Uid:  strconv.FormatInt(uid, 10),
+		Time: time.Now(),
+		Data: b,
+	}
+	var tasks []*models.TaskInfo
+	for _, url := range conf.Urls {
+		extra, err := json.Marshal(models.WebhookInfo{Url: url, Event: event})
+		if err != nil {
+			return err
+		}
+		tasks = append(tasks, &models.TaskInfo{
+			Status:    utils.TaskStatusUndo,
+			TaskType:  utils.TaskWebhook,
+			ExtraData: string(extra),
+			RefId:     uid,
+		})
+	}
+	return repo.NewTaskRepo().BatchCreate(db, tasks)
+}
+
+// eventID 已推送过的事件沿用原来的ID，否则生成新的ID
+func eventID(db *gorm.DB, eventType string, uid int64) (string, error) {
+	tasks, err := repo.NewTaskRepo().FindByRef(db, utils.TaskWebhook, uid)
+	if err != nil {
+		return "", err
+	}
+	if id := findEventID(tasks, eventType); id != "" {
+		return id, nil
+	}
+	id, err := base.NewIdGenerator().NextId()
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(id, 10), nil
+}
+
+// findEventID 推送任务中同类事件的ID，没有时为空
+func findEventID(tasks []models.TaskInfo, eventType string) string {
+	for _, task := range tasks {
+		var msg models.WebhookInfo
+		if err := json.Unmarshal([]byte(task.ExtraData), &msg); err != nil {
+			continue
+		}
+		if msg.Event.Type == eventType && msg.Event.ID != "" {
+			return msg.Event.ID
+		}
+	}
+	return ""
+}
+
+// PublishObject 查询最新的元数据后推送对象事件
+func PublishObject(db *gorm.DB, eventType string, uid int64) error {
+	if len(bootstrap.NewConfig("").Webhook.Urls) == 0 {
+		return nil
+	}
+	metaData, err := repo.NewMetaDataInfoRepo().GetByUid(db, uid)
+	if err != nil {
+		return err
+	}
+	return Publish(db, eventType, uid, models.NewObjectEventData(metaData))
+}
+
+// Deliver 推送事件，接收方返回2xx以外的状态码时视为失败
+func Deliver(ctx context.Context, msg models.WebhookInfo) error {
+	conf := bootstrap.NewConfig("").Webhook
+	timeout := time.Duration(conf.Timeout) * time.Second
+	if conf.Timeout <= 0 {
+		timeout = utils.WebhookDefaultTimeout * time.Second
+	}
+	return deliver(ctx, &http.Client{Timeout: timeout}, conf.Secret, msg)
+}
+
+func deliver(ctx context.Context, client *http.Client, secret string, msg models.WebhookInfo) error {
+	// 接收方无法校验未签名的请求
+	if secret == "" {
+		return errNoSecret
+	}
+	body, err := json.Marshal(msg.Event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, msg.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(utils.WebhookHeaderEvent, msg.Event.Type)
+	req.Header.Set(utils.WebhookHeaderDelivery, msg.Event.ID)
+	req.Header.Set(utils.WebhookHeaderTimestamp, timestamp)
+	req.Header.Set(utils.WebhookHeaderSignature, "sha256="+Sign(secret, timestamp, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("推送%s失败，状态码：%d", msg.Url, resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign 对时间戳及请求体签名，接收方用相同方式计算后比较，并校验时间戳防止重放
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,80 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/qinguoyi/osproxy/app/models"
+	"github.com/qinguoyi/osproxy/app/pkg/utils"
+)
+
+func TestDeliverSigned(t *testing.T) {
+	var gotSignature, gotBody, gotTimestamp, gotDelivery string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+		gotSignature = r.Header.Get(utils.WebhookHeaderSignature)
+		gotTimestamp = r.Header.Get(utils.WebhookHeaderTimestamp)
+		gotDelivery = r.Header.Get(utils.WebhookHeaderDelivery)
+	}))
+	defer srv.Close()
+
+	msg := models.WebhookInfo{
+		Url:   srv.URL,
+		Event: models.ObjectEvent{ID: "1", Type: utils.EventObjectUploaded, Uid: "2", Data: []byte(`{}`)},
+	}
+	if err := deliver(context.Background(), srv.Client(), "secret", msg); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if expected := "sha256=" + Sign("secret", gotTimestamp, []byte(gotBody)); gotSignature != expected {
+		t.Errorf("Expected signature %s, but got %s", expected, gotSignature)
+	}
+	if gotDelivery != "1" {
+		t.Errorf("Expected delivery 1, but got %s", gotDelivery)
+	}
+}
+
+func TestDeliverFailed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	msg := models.WebhookInfo{Url: srv.URL, Event: models.ObjectEvent{ID: "1"}}
+	if err := deliver(context.Background(), srv.Client(), "secret", msg); err == nil {
+		t.Error("Expected error when status code is 502, but got nil")
+	}
+}
+
+func TestDeliverWithoutSecret(t *testing.T) {
+	called := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer srv.Close()
+
+	msg := models.WebhookInfo{Url: srv.URL, Event: models.ObjectEvent{ID: "1"}}
+	if err := deliver(context.Background(), srv.Client(), "", msg); err == nil {
+		t.Error("Expected error when secret is empty, but got nil")
+	}
+	if called {
+		t.Error("Expected unsigned event not to be sent")
+	}
+}
+
+func TestFindEventID(t *testing.T) {
+	tasks := []models.TaskInfo{
+		{ExtraData: `{"url":"http://a","event":{"id":"1","type":"object.deleted"}}`},
+		{ExtraData: `{"url":"http://a","event":{"id":"2","type":"object.uploaded"}}`},
+		{ExtraData: `{"url":"http://b","event":{"id":"2","type":"object.uploaded"}}`},
+	}
+	if id := findEventID(tasks, utils.EventObjectUploaded); id != "2" {
+		t.Errorf("Expected original event id 2, but got %q", id)
+	}
+	if id := findEventID(tasks, utils.EventMergeFailed); id != "" {
+		t.Errorf("Expected no event id, but got %q", id)
+	}
+}
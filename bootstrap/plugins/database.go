@@ -233,6 +233,12 @@ func initMySqlTables(db *gorm.DB) {
 		models.MultiPartInfo{},
 		models.TaskInfo{},
 		models.TaskLog{},
+		models.DownloadLink{},
+		models.Share{},
+		models.ShareAccessLog{},
+		models.UploadSession{},
+		models.Uid{},
+		models.Outbox{},
 	)
 	if err != nil {
 		bootstrap.NewLogger().Logger.Error("migrate table failed", zap.Any("err", err))
@@ -1,12 +1,16 @@
 package main
 
 import (
-	"github.com/qinguoyi/osproxy/api"               // api包用于初始化路由
-	"github.com/qinguoyi/osproxy/app"               // app包用于初始化http服务
-	"github.com/qinguoyi/osproxy/app/pkg/base"      // base包用于初始化Snowflake
-	"github.com/qinguoyi/osproxy/app/pkg/storage"   // storage包用于初始化storage
-	"github.com/qinguoyi/osproxy/bootstrap"         // bootstrap包用于初始化配置文件和日志
-	"github.com/qinguoyi/osproxy/bootstrap/plugins" // plugins包用于初始化插件资源
+	"github.com/qinguoyi/osproxy/api"                  // api包用于初始化路由
+	"github.com/qinguoyi/osproxy/app"                  // app包用于初始化http服务
+	"github.com/qinguoyi/osproxy/app/pkg/base"         // base包用于初始化发号器
+	"github.com/qinguoyi/osproxy/app/pkg/event/notify" // notify包用于初始化任务通知
+	"github.com/qinguoyi/osproxy/app/pkg/outbox"       // outbox包用于初始化对象事件发布
+	"github.com/qinguoyi/osproxy/app/pkg/scan"         // scan包用于初始化内容扫描
+	"github.com/qinguoyi/osproxy/app/pkg/staging"      // staging包用于初始化分片暂存区
+	"github.com/qinguoyi/osproxy/app/pkg/storage"      // storage包用于初始化storage
+	"github.com/qinguoyi/osproxy/bootstrap"            // bootstrap包用于初始化配置文件和日志
+	"github.com/qinguoyi/osproxy/bootstrap/plugins"    // plugins包用于初始化插件资源
 )
 
 // @title    ObjectStorageProxy
@@ -24,12 +28,27 @@ func main() {
 	plugins.NewPlugins()         // NewPlugins()函数用于初始化插件资源
 	defer plugins.ClosePlugins() //	ClosePlugins()函数用于释放插件资源 defer关键字用于延迟函数的执行
 
-	// init Snowflake
-	base.InitSnowFlake() // InitSnowFlake()函数用于初始化Snowflake,雪花算法
+	// init cluster 集群内请求签名及双向TLS
+	base.InitCluster(lgConfig)
+
+	// init id generator
+	base.InitIdGenerator(lgConfig) // InitIdGenerator()函数用于初始化发号器，雪花算法或数据库号段
 
 	// init storage
 	storage.InitStorage(lgConfig) // InitStorage()函数用于初始化storage
 
+	// init staging
+	staging.InitStaging(lgConfig) // InitStaging()函数用于初始化分片暂存区
+
+	// init task notifier
+	notify.InitNotifier(lgConfig) // InitNotifier()函数用于初始化任务通知
+
+	// init outbox publisher
+	outbox.InitPublisher(lgConfig) // InitPublisher()函数用于初始化对象事件发布
+
+	// init content scanner
+	scan.InitScanner(lgConfig) // InitScanner()函数用于初始化内容扫描
+
 	// router
 	engine := api.NewRouter(lgConfig, lgLogger)   // NewRouter()函数用于初始化路由,enigne是gin的核心结构体，包含了路由、中间件等信息
 	server := app.NewHttpServer(lgConfig, engine) // NewHttpServer()函数用于初始化http服务
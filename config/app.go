@@ -2,8 +2,9 @@ package config
 
 // App 配置apps对应的结构体
 type App struct {
-	Env     string `mapstructure:"env" json:"env" yaml:"env"`
-	Port    string `mapstructure:"port" json:"port" yaml:"port"`
-	AppName string `mapstructure:"app_name" json:"app_name" yaml:"app_name"`
-	AppUrl  string `mapstructure:"app_url" json:"app_url" yaml:"app_url"`
+	Env           string `mapstructure:"env" json:"env" yaml:"env"`
+	Port          string `mapstructure:"port" json:"port" yaml:"port"`
+	AppName       string `mapstructure:"app_name" json:"app_name" yaml:"app_name"`
+	AppUrl        string `mapstructure:"app_url" json:"app_url" yaml:"app_url"`
+	AdvertiseAddr string `mapstructure:"advertise_addr" json:"advertise_addr" yaml:"advertise_addr"` // 集群内访问当前服务的地址
 }
@@ -0,0 +1,10 @@
+package config
+
+// Cluster 集群内通信配置
+type Cluster struct {
+	Secret   string `mapstructure:"secret" json:"secret" yaml:"secret"`          // 集群内请求的签名密钥，为空时不校验
+	Tls      bool   `mapstructure:"tls" json:"tls" yaml:"tls"`                   // 是否启用双向TLS
+	CaFile   string `mapstructure:"ca_file" json:"ca_file" yaml:"ca_file"`       // 签发集群证书的CA
+	CertFile string `mapstructure:"cert_file" json:"cert_file" yaml:"cert_file"` // 当前服务的证书
+	KeyFile  string `mapstructure:"key_file" json:"key_file" yaml:"key_file"`    // 当前服务的私钥
+}
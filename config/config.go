@@ -4,12 +4,21 @@ import "github.com/qinguoyi/osproxy/config/plugins"
 
 // Configuration 配置文件中所有字段对应的结构体
 type Configuration struct { //yaml文件中的配置项
-	App      App                 `mapstructure:"app" json:"app" yaml:"app"`
-	Log      Log                 `mapstructure:"log" json:"log" yaml:"log"`
-	Database []*plugins.Database `mapstructure:"database" json:"database" yaml:"database"`
-	Redis    *plugins.Redis      `mapstructure:"redis" json:"redis" yaml:"redis"`
-	Minio    *plugins.Minio      `mapstructure:"minio" json:"minio" yaml:"minio"`
-	Cos      *plugins.Cos        `mapstructure:"cos" json:"cos" yaml:"cos"`
-	Oss      *plugins.Oss        `mapstructure:"oss" json:"oss" yaml:"oss"`
-	Local    *plugins.Local      `mapstructure:"local" json:"local" yaml:"local"`
+	App       App                 `mapstructure:"app" json:"app" yaml:"app"`
+	Log       Log                 `mapstructure:"log" json:"log" yaml:"log"`
+	Cluster   Cluster             `mapstructure:"cluster" json:"cluster" yaml:"cluster"`
+	Discovery Discovery           `mapstructure:"discovery" json:"discovery" yaml:"discovery"`
+	Drain     Drain               `mapstructure:"drain" json:"drain" yaml:"drain"`
+	Id        IdGenerator         `mapstructure:"id_generator" json:"id_generator" yaml:"id_generator"`
+	Task      Task                `mapstructure:"task" json:"task" yaml:"task"`
+	Webhook   Webhook             `mapstructure:"webhook" json:"webhook" yaml:"webhook"`
+	Outbox    Outbox              `mapstructure:"outbox" json:"outbox" yaml:"outbox"`
+	Scan      Scan                `mapstructure:"scan" json:"scan" yaml:"scan"`
+	Database  []*plugins.Database `mapstructure:"database" json:"database" yaml:"database"`
+	Redis     *plugins.Redis      `mapstructure:"redis" json:"redis" yaml:"redis"`
+	Minio     *plugins.Minio      `mapstructure:"minio" json:"minio" yaml:"minio"`
+	Cos       *plugins.Cos        `mapstructure:"cos" json:"cos" yaml:"cos"`
+	Oss       *plugins.Oss        `mapstructure:"oss" json:"oss" yaml:"oss"`
+	Local     *plugins.Local      `mapstructure:"local" json:"local" yaml:"local"`
+	Staging   *plugins.Staging    `mapstructure:"staging" json:"staging" yaml:"staging"`
 }
@@ -0,0 +1,10 @@
+package config
+
+// Discovery 服务发现配置
+type Discovery struct {
+	Mode    string   `mapstructure:"mode" json:"mode" yaml:"mode"`             // redis、static、dns
+	Stale   int      `mapstructure:"stale" json:"stale" yaml:"stale"`          // redis模式下超过该时间未上报心跳的服务视为下线，秒
+	Peers   []string `mapstructure:"peers" json:"peers" yaml:"peers"`          // static模式下的服务列表，ip:port
+	DnsName string   `mapstructure:"dns_name" json:"dns_name" yaml:"dns_name"` // dns模式下查询的域名
+	DnsSrv  bool     `mapstructure:"dns_srv" json:"dns_srv" yaml:"dns_srv"`    // dns模式下是否查询SRV记录，否则查询A记录并使用当前服务端口
+}
@@ -0,0 +1,7 @@
+package config
+
+// Drain 排空配置
+type Drain struct {
+	Push    bool `mapstructure:"push" json:"push" yaml:"push"`          // 排空时是否将本地暂存的分片推送到其他服务
+	Timeout int  `mapstructure:"timeout" json:"timeout" yaml:"timeout"` // 等待进行中的上传及合并完成的最长时间，秒
+}
@@ -0,0 +1,8 @@
+package config
+
+// IdGenerator 发号器配置
+type IdGenerator struct {
+	Mode  string `mapstructure:"mode" json:"mode" yaml:"mode"`       // snowflake、segment
+	BizId string `mapstructure:"biz_id" json:"biz_id" yaml:"biz_id"` // segment模式下的业务ID
+	Step  int64  `mapstructure:"step" json:"step" yaml:"step"`       // segment模式下业务ID不存在时初始化的号段步长
+}
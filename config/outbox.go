@@ -0,0 +1,8 @@
+package config
+
+// Outbox 对象事件发布配置
+type Outbox struct {
+	Publisher string `mapstructure:"publisher" json:"publisher" yaml:"publisher"` // 为空时不发布，目前支持redis
+	Stream    string `mapstructure:"stream" json:"stream" yaml:"stream"`          // redis stream名称
+	MaxLen    int64  `mapstructure:"max_len" json:"max_len" yaml:"max_len"`       // stream保留的大致长度
+}
@@ -0,0 +1,7 @@
+package plugins
+
+// Staging 分片暂存配置
+type Staging struct {
+	Mode   string `mapstructure:"mode" json:"mode" yaml:"mode"`       // local:本地目录，shared:对象存储
+	Prefix string `mapstructure:"prefix" json:"prefix" yaml:"prefix"` // shared模式下分片在对象存储中的前缀
+}
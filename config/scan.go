@@ -0,0 +1,9 @@
+package config
+
+// Scan 内容扫描配置
+type Scan struct {
+	Mode    string `mapstructure:"mode" json:"mode" yaml:"mode"`          // 为空时不扫描，clamd、icap
+	Address string `mapstructure:"address" json:"address" yaml:"address"` // 扫描服务地址，host:port
+	Service string `mapstructure:"service" json:"service" yaml:"service"` // icap服务名，如avscan
+	Timeout int    `mapstructure:"timeout" json:"timeout" yaml:"timeout"` // 单个对象的扫描超时时间，秒
+}
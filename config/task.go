@@ -0,0 +1,14 @@
+package config
+
+// Task 任务调度配置
+type Task struct {
+	Notify       string              `mapstructure:"notify" json:"notify" yaml:"notify"`                      // poll、redis、postgres
+	PollInterval int                 `mapstructure:"poll_interval" json:"poll_interval" yaml:"poll_interval"` // 轮询间隔，毫秒，启用通知时轮询只作兜底
+	Pools        map[string]TaskPool `mapstructure:"pools" json:"pools" yaml:"pools"`                         // 按任务类型覆盖注册时的worker池设置
+}
+
+// TaskPool 任务类型的worker池
+type TaskPool struct {
+	Workers  int `mapstructure:"workers" json:"workers" yaml:"workers"`    // worker数，即当前服务同时执行的上限
+	Priority int `mapstructure:"priority" json:"priority" yaml:"priority"` // 抢占优先级，越大越先抢占
+}
@@ -0,0 +1,8 @@
+package config
+
+// Webhook 事件推送配置
+type Webhook struct {
+	Urls    []string `mapstructure:"urls" json:"urls" yaml:"urls"`          // 接收事件的地址，为空时不推送
+	Secret  string   `mapstructure:"secret" json:"secret" yaml:"secret"`    // HMAC签名密钥
+	Timeout int      `mapstructure:"timeout" json:"timeout" yaml:"timeout"` // 单次推送超时时间，秒
+}